@@ -2,13 +2,62 @@
 // Licensed under the MIT License.
 package main
 
+import "time"
+
 type ServerCmd struct {
 	HttpAddr        string `arg:"--http-addr" help:"address of the server" default:"127.0.0.1:5000"`
 	HttpsAddr       string `arg:"--https-addr" help:"address of the server" default:"0.0.0.0:5001"`
+	PeerTransport   string `arg:"--peer-transport" help:"how content is requested from peers, over their https address, or over libp2p streams to their router address" default:"https" valid:"https,libp2p"`
+	MutualTLS       bool   `arg:"--mutual-tls" help:"require peers to present the certificate of their p2p identity on the https address, and only treat such requests as from a peer" default:"false"`
 	RouterAddr      string `arg:"--router-addr" help:"address of the router (p2p)" default:"0.0.0.0:5003"`
+	RouterAddr6     string `arg:"--router-addr6" help:"additional IPv6 address of the router (p2p) for dual-stack hosts, such as [::]:5003"`
+	RouterTransport string `arg:"--router-transport" help:"comma separated transports of the router (p2p), tcp and/or quic, quic listens on the UDP port of the same number" default:"tcp"`
 	PromAddr        string `arg:"--prom-addr" help:"address of prometheus metrics endpoint" default:"0.0.0.0:5004"`
 	PrefetchWorkers int    `arg:"--prefetch-workers" help:"number of workers to prefetch content" default:"50"`
 
+	// Cache configuration.
+	CachePath          string `arg:"--cache-path" help:"directory of the cached chunks, mount it from the host to keep the cache across restarts" default:"/tmp/distribution/peerd/cache"`
+	ManifestsPath      string `arg:"--manifests-path" help:"directory of the manifest entries of the cached chunks, kept with the cache across restarts" default:"/tmp/distribution/peerd/manifests"`
+	VerifyCachedChunks bool   `arg:"--verify-cached-chunks" help:"check the digest of every chunk left in the cache by a previous run before serving it again, instead of only its size" default:"false"`
+	MemoryCacheSize    int64  `arg:"--memory-cache-size" help:"maximum bytes of hot chunks held in memory in front of the disk cache, disabled if zero; the memory limit of the process must allow for it" default:"0"`
+	DiskHighWatermark  int    `arg:"--disk-high-watermark" help:"percentage of the disk holding the cache in use above which the least recently read chunks are evicted" default:"75"`
+	DiskLowWatermark   int    `arg:"--disk-low-watermark" help:"percentage of the disk holding the cache in use that eviction stops at" default:"65"`
+	DiskFillLimit      int    `arg:"--disk-fill-limit" help:"percentage of the disk holding the cache in use above which chunks are served without caching them, keep it below the image garbage collection threshold of kubelet" default:"80"`
+
+	// Upload configuration.
+	UploadRate     int64 `arg:"--upload-rate" help:"maximum bytes per second uploaded to all peers, unlimited if zero" default:"0"`
+	UploadPeerRate int64 `arg:"--upload-peer-rate" help:"maximum bytes per second uploaded to a single peer, unlimited if zero" default:"0"`
+	MaxUploads     int   `arg:"--max-uploads" help:"maximum number of concurrent uploads to peers, further peers are asked to retry elsewhere; unlimited if zero" default:"0"`
+
+	// Origin configuration.
+	OriginRetries          int           `arg:"--origin-retries" help:"number of times a request to origin is retried after a 5xx, 429 or connection error" default:"3"`
+	OriginBackoff          time.Duration `arg:"--origin-backoff" help:"delay before the first retry of a request to origin, doubled for every further retry" default:"100ms"`
+	OriginMaxBackoff       time.Duration `arg:"--origin-max-backoff" help:"longest delay before a retry of a request to origin, requests are not retried if origin asks to wait longer with Retry-After" default:"5s"`
+	OriginBreakerThreshold int           `arg:"--origin-breaker-threshold" help:"number of failed requests in a row after which requests to an origin host are stopped, and peers are given longer to resolve" default:"5"`
+	OriginBreakerCooldown  time.Duration `arg:"--origin-breaker-cooldown" help:"how long requests to an origin host are stopped before one is allowed through again" default:"30s"`
+
+	// URL parser configuration.
+	URLParserRules string `arg:"--url-parser-rules" help:"TOML file with rules for parsing the digest of blob URLs that the built-in parsers do not know, from the URL or a request header"`
+
+	// Identity configuration.
+	IdentityKey       string        `arg:"--identity-key" help:"path of the private key of the p2p identity of this node, created if missing; the identity changes on every start if empty"`
+	IdentityKeyMaxAge time.Duration `arg:"--identity-key-max-age" help:"rotate the identity key on start once it is older than this, never if zero" default:"0s"`
+
+	// Private network configuration.
+	PSKFile   string `arg:"--psk-file" help:"file with the libp2p pre-shared key of the private network, the network is public if neither this nor --psk-secret is set"`
+	PSKSecret string `arg:"--psk-secret" help:"kubernetes secret in the namespace of peerd with the libp2p pre-shared key of the private network under the swarm.key key"`
+
+	// Bootstrap configuration.
+	Bootstrap      string   `arg:"--bootstrap" help:"source of the p2p bootstrap peers" default:"k8s" valid:"k8s,static,dns,file"`
+	BootstrapPeers []string `arg:"--bootstrap-peers" help:"p2p multiaddresses of the bootstrap peers, used with --bootstrap=static"`
+	BootstrapDns   string   `arg:"--bootstrap-dns" help:"DNS name resolving to the bootstrap peers, SRV if it starts with '_' else A/AAAA, used with --bootstrap=dns"`
+	BootstrapFile  string   `arg:"--bootstrap-file" help:"file with one p2p multiaddress per line, re-read on every bootstrap, used with --bootstrap=file"`
+
+	// Topology configuration.
+	Zone            string        `arg:"--zone" help:"zone of this node, read from the topology.kubernetes.io/zone label of the node if empty"`
+	ForbidCrossZone bool          `arg:"--forbid-cross-zone" help:"only use peers in the same zone as this node, no peer is used if the zone of this node is unknown" default:"false"`
+	CrossZoneDelay  time.Duration `arg:"--cross-zone-delay" help:"how long peers that are not known to be in the same zone are held back while resolving, so that peers in the same zone are tried first" default:"50ms"`
+
 	// Mirror configuration.
 	Hosts                     []string `arg:"--hosts" help:"list of hosts to mirror"`
 	AddMirrorConfiguration    bool     `arg:"--add-mirror-configuration" help:"add mirror configuration to containerd host configuration" default:"false"`
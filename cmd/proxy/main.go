@@ -15,15 +15,21 @@ import (
 	"time"
 
 	"github.com/alexflint/go-arg"
+	"github.com/azure/peerd/pkg/cache"
 	"github.com/azure/peerd/pkg/containerd"
 	pcontext "github.com/azure/peerd/pkg/context"
 	"github.com/azure/peerd/pkg/discovery/content/provider"
+	"github.com/azure/peerd/pkg/discovery/content/reader"
 	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/azure/peerd/pkg/discovery/routing/bootstrap"
 	"github.com/azure/peerd/pkg/files/store"
 	"github.com/azure/peerd/pkg/handlers"
+	"github.com/azure/peerd/pkg/handlers/upload"
 	"github.com/azure/peerd/pkg/k8s"
 	"github.com/azure/peerd/pkg/k8s/events"
 	"github.com/azure/peerd/pkg/metrics"
+	"github.com/azure/peerd/pkg/peernet"
+	"github.com/azure/peerd/pkg/urlparser"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/spf13/afero"
@@ -80,6 +86,29 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 	l := zerolog.Ctx(ctx)
 
 	store.PrefetchWorkers = args.PrefetchWorkers
+	store.VerifyCachedChunks = args.VerifyCachedChunks
+	store.ManifestsPath = args.ManifestsPath
+	cache.Path = args.CachePath
+	cache.MemoryCacheMaxCost = args.MemoryCacheSize
+	if args.DiskLowWatermark >= args.DiskHighWatermark {
+		return fmt.Errorf("disk low watermark %v%% must be below the high watermark %v%%", args.DiskLowWatermark, args.DiskHighWatermark)
+	}
+	cache.DiskHighWatermark = args.DiskHighWatermark
+	cache.DiskLowWatermark = args.DiskLowWatermark
+	cache.DiskFillLimit = args.DiskFillLimit
+	store.Origin = reader.OriginConfig{
+		Retries:          args.OriginRetries,
+		Backoff:          args.OriginBackoff,
+		MaxBackoff:       args.OriginMaxBackoff,
+		BreakerThreshold: args.OriginBreakerThreshold,
+		BreakerCooldown:  args.OriginBreakerCooldown,
+	}
+
+	if args.URLParserRules != "" {
+		if store.URLParserRules, err = urlparser.LoadRules(args.URLParserRules); err != nil {
+			return err
+		}
+	}
 
 	_, httpsPort, err := net.SplitHostPort(args.HttpsAddr)
 	if err != nil {
@@ -88,7 +117,12 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 
 	clientset, err := k8s.NewKubernetesInterface(pcontext.KubeConfigPath, pcontext.NodeName)
 	if err != nil {
-		return err
+		if args.Bootstrap == bootstrap.ModeK8s {
+			return err
+		}
+		// Kubernetes is optional when bootstrapping from other sources.
+		l.Info().Err(err).Msg("kubernetes not available, continuing without it")
+		clientset = nil
 	}
 
 	ctx, err = events.WithContext(ctx, clientset)
@@ -104,7 +138,42 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 
 	eventsRecorder.Initializing()
 
-	r, err := routing.NewRouter(ctx, clientset, args.RouterAddr, httpsPort)
+	b, err := newBootstrapper(args, clientset, httpsPort)
+	if err != nil {
+		return err
+	}
+
+	routerAddrs := []string{args.RouterAddr}
+	if args.RouterAddr6 != "" {
+		routerAddrs = append(routerAddrs, args.RouterAddr6)
+	}
+
+	topology := routing.Topology{Zone: args.Zone, ForbidCrossZone: args.ForbidCrossZone, CrossZoneDelay: args.CrossZoneDelay}
+	if topology.Zone == "" && clientset != nil {
+		topology.Zone, err = clientset.NodeZone(ctx)
+		if err != nil {
+			// Peers are used regardless of their zone, or not at all if cross zone peers are forbidden.
+			l.Warn().Err(err).Msg("could not get zone of node")
+		}
+	}
+
+	key, err := routing.LoadIdentity(ctx, args.IdentityKey, args.IdentityKeyMaxAge)
+	if err != nil {
+		return err
+	}
+
+	psk, err := routing.LoadPSK(ctx, clientset, args.PSKFile, args.PSKSecret)
+	if err != nil {
+		return err
+	}
+
+	transports, err := routing.ParseTransports(args.RouterTransport)
+	if err != nil {
+		return err
+	}
+
+	hostOpts := routing.HostOptions{Addrs: routerAddrs, Key: key, PSK: psk, Transports: transports, PeerTransport: args.PeerTransport}
+	r, err := routing.NewRouter(ctx, clientset, b, hostOpts, httpsPort, topology)
 	if err != nil {
 		return err
 	}
@@ -125,6 +194,10 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 		l.Info().Msg(fmt.Sprintf("mirrors args: %v, hosts: %v", args.Hosts, hosts))
 
 		defaultMirror, _ := url.Parse("https://localhost:30001")
+		if args.MutualTLS {
+			// containerd has no certificate for the https listener, so it is pointed at the http listener.
+			defaultMirror, _ = url.Parse("http://localhost:30000")
+		}
 		mirrors := append([]url.URL{}, *defaultMirror)
 
 		if len(args.Mirrors) > 0 {
@@ -157,19 +230,28 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		provider.Provide(ctx, r, containerdStore, filesStore.Subscribe())
+		provider.Provide(ctx, r, containerdStore, filesStore.Subscribe(), filesStore.SubscribeEvictions())
 		return nil
 	})
 
-	handler, err := handlers.Handler(ctx, r, containerdStore, filesStore)
+	handlerOpts := handlers.Options{
+		MutualTLS: args.MutualTLS,
+		Upload:    upload.Config{Rate: args.UploadRate, PeerRate: args.UploadPeerRate, MaxUploads: args.MaxUploads},
+	}
+	handler, err := handlers.Handler(ctx, r, containerdStore, filesStore, handlerOpts)
 	if err != nil {
 		return err
 	}
 
+	tlsConfig := r.Net().DefaultTLSConfig()
+	if args.MutualTLS {
+		tlsConfig = r.Net().MutualTLSConfig()
+	}
+
 	httpsSrv := &http.Server{
 		Addr:      args.HttpsAddr,
 		Handler:   handler,
-		TLSConfig: r.Net().DefaultTLSConfig(),
+		TLSConfig: tlsConfig,
 	}
 	g.Go(func() error {
 		if err := httpsSrv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -184,6 +266,31 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 		return httpsSrv.Shutdown(shutdownCtx)
 	})
 
+	if nl, ok := r.Net().(peernet.Listener); ok {
+		var streamListener net.Listener
+		streamListener, err = nl.Listen()
+		if err != nil {
+			return err
+		}
+
+		streamSrv := &http.Server{
+			Handler:     handler,
+			ConnContext: peernet.StreamConnContext,
+		}
+		g.Go(func() error {
+			if err := streamSrv.Serve(streamListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+		g.Go(func() error {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			return streamSrv.Shutdown(shutdownCtx)
+		})
+	}
+
 	httpSrv := &http.Server{
 		Addr:    args.HttpAddr,
 		Handler: handler,
@@ -209,7 +316,7 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 		return nil
 	})
 
-	l.Info().Str("https", args.HttpsAddr).Str("http", args.HttpAddr).Str("router", args.RouterAddr).Str("prom", args.PromAddr).Msg("server start")
+	l.Info().Str("https", args.HttpsAddr).Str("http", args.HttpAddr).Strs("router", routerAddrs).Str("prom", args.PromAddr).Msg("server start")
 	err = g.Wait()
 	if err != nil {
 		return err
@@ -218,6 +325,26 @@ func serverCommand(ctx context.Context, args *ServerCmd) (err error) {
 	return nil
 }
 
+// newBootstrapper creates the source of bootstrap peers for the router.
+func newBootstrapper(args *ServerCmd, clientset *k8s.ClientSet, httpsPort string) (bootstrap.Bootstrapper, error) {
+	switch args.Bootstrap {
+	case bootstrap.ModeK8s:
+		return bootstrap.NewK8s(clientset), nil
+	case bootstrap.ModeStatic:
+		return bootstrap.NewStatic(args.BootstrapPeers)
+	case bootstrap.ModeDns:
+		_, routerPort, err := net.SplitHostPort(args.RouterAddr)
+		if err != nil {
+			return nil, err
+		}
+		return bootstrap.NewDns(args.BootstrapDns, routerPort, httpsPort)
+	case bootstrap.ModeFile:
+		return bootstrap.NewFile(args.BootstrapFile)
+	default:
+		return nil, fmt.Errorf("unknown bootstrap mode: %s", args.Bootstrap)
+	}
+}
+
 func toUrls(hosts []string) ([]url.URL, error) {
 	var urls []url.URL
 	for _, h := range hosts {
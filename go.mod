@@ -21,6 +21,7 @@ require (
 	github.com/schollz/progressbar/v3 v3.18.0
 	github.com/swaggo/swag v1.16.4
 	golang.org/x/sync v0.10.0
+	golang.org/x/time v0.9.0
 	k8s.io/api v0.32.1
 	k8s.io/apimachinery v0.32.1
 )
@@ -179,7 +180,6 @@ require (
 	golang.org/x/oauth2 v0.25.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
-	golang.org/x/time v0.9.0 // indirect
 	golang.org/x/tools v0.29.0 // indirect
 	gonum.org/v1/gonum v0.15.1 // indirect
 	google.golang.org/genproto v0.0.0-20250122153221-138b5a5a4fd4 // indirect
@@ -3,27 +3,56 @@
 package cache
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
-	"time"
 
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/dgraph-io/ristretto"
 	"github.com/rs/zerolog"
 )
 
+// metainfo is the name of the file that holds the length of a file, next to its chunks.
+const metainfo = "metainfo"
+
+// Tiers and results of chunk cache lookups, reported in metrics.
+const (
+	tierMemory = "memory"
+	tierDisk   = "disk"
+	resultHit  = "hit"
+	resultMiss = "miss"
+)
+
 // fileCache implements FileCache.
 type fileCache struct {
 	fileCache     *ristretto.Cache
+	memory        *memoryCache
+	buffers       *bufferPool
 	metadataCache *SyncMap
 	path          string
+	blockSize     int64
 	lock          sync.RWMutex
 	log           zerolog.Logger
+
+	// items holds the chunks on disk by key, to find the least recently read ones under disk pressure.
+	items sync.Map
+
+	// onEvict is called with the file name and offset of every evicted chunk.
+	onEvict func(name string, offset int64)
+
+	// usage returns the bytes in use and the size of the file system holding the cache.
+	usage func(path string) (uint64, uint64, error)
+
+	// refusing is set while the disk is too full to cache more chunks, and pressured while chunks are evicted to free it.
+	refusing  atomic.Bool
+	pressured atomic.Bool
+
+	metricsRecorder metrics.Metrics
 }
 
 var _ Cache = &fileCache{}
@@ -46,42 +75,98 @@ func (c *fileCache) Exists(name string, offset int64) bool {
 	return false
 }
 
-// GetOrCreate gets the cached value if available, otherwise fetches it.
+// GetOrCreate gets a copy of the cached value if available, otherwise fetches it.
 func (c *fileCache) GetOrCreate(name string, alignedOffset int64, count int, fetch func() ([]byte, error)) ([]byte, error) {
+	var result []byte
+	err := c.View(name, alignedOffset, count, fetch, func(data []byte) {
+		result = bytes.Clone(data)
+	})
+	return result, err
+}
+
+// View calls view with the cached value if available, otherwise fetches it first.
+// The value is read from memory if it is hot, otherwise from disk, and is only valid until view returns.
+func (c *fileCache) View(name string, alignedOffset int64, count int, fetch func() ([]byte, error), view func(data []byte)) error {
 	key := c.getKey(name, alignedOffset)
+	if b, ok := c.memory.get(key, count); ok {
+		defer b.release()
+		c.metricsRecorder.RecordChunkCache(tierMemory, resultHit)
+		view(b.data)
+		return nil
+	}
+
+	if c.memory != nil {
+		c.metricsRecorder.RecordChunkCache(tierMemory, resultMiss)
+	}
+
+	b, filled, err := c.readDisk(key, count, fetch)
+	if err != nil {
+		return err
+	}
+	defer b.release()
+
+	if filled {
+		c.metricsRecorder.RecordChunkCache(tierDisk, resultMiss)
+	} else {
+		// Chunks are only promoted once they are read again, so that prefetching does not flush the memory tier.
+		c.metricsRecorder.RecordChunkCache(tierDisk, resultHit)
+		c.memory.put(key, b)
+	}
+
+	view(b.data)
+	return nil
+}
+
+// readDisk reads the chunk with the given key from its file, filling it first if it is not cached, and returns whether
+// it was filled. The returned buffer is held by the caller, which must release it.
+func (c *fileCache) readDisk(key string, count int, fetch func() ([]byte, error)) (*buffer, bool, error) {
 	val, found := c.fileCache.Get(key)
 	if !found {
 		c.lock.Lock()
 		if val, found = c.fileCache.Get(key); found && val != nil {
 			c.lock.Unlock()
+		} else if c.refusing.Load() {
+			c.lock.Unlock()
+			return nil, false, ErrFillRefused
 		} else {
-			var err error
-			val, err = newItem(key, c.log)
+			i, err := newItem(key, c.log)
 			if err != nil {
 				c.lock.Unlock()
-				return nil, err
+				return nil, false, err
 			}
-			ok := c.fileCache.Set(key, val, 0)
-			if !ok {
+
+			c.items.Store(key, i)
+			if !c.fileCache.Set(key, i, 0) {
+				c.items.CompareAndDelete(key, i)
 				c.lock.Unlock()
-				return nil, io.ErrUnexpectedEOF
+				i.drop(c.log)
+				return nil, false, ErrFillRefused
 			}
 
-			// wait for value to pass through buffers
-			waitForSet()
+			// wait for value to pass through buffers, otherwise a concurrent set of the key would replace and drop it
+			c.fileCache.Wait()
+
+			// The admission policy may have rejected the chunk, which dropped its file.
+			if _, found = c.fileCache.Get(key); !found {
+				c.lock.Unlock()
+				return nil, false, ErrFillRefused
+			}
 
+			val = i
 			c.lock.Unlock()
 		}
 	}
 
 	cacheItem := val.(*item)
+	cacheItem.touch()
+	filled := false
 
 	cacheItem.lock.RLock()
 	info, err := cacheItem.file.Stat()
 
 	if err != nil {
 		cacheItem.lock.RUnlock()
-		return nil, err
+		return nil, false, err
 	}
 
 	if info.Size() != int64(count) {
@@ -93,16 +178,16 @@ func (c *fileCache) GetOrCreate(name string, alignedOffset int64, count int, fet
 		info, err = cacheItem.file.Stat()
 		if err != nil {
 			cacheItem.lock.Unlock()
-			return nil, err
+			return nil, false, err
 		} else if info.Size() != int64(count) {
-
+			filled = true
 			n, err := cacheItem.fill(c.log, fetch)
 			cacheItem.lock.Unlock()
 
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			} else if int64(n) != int64(count) {
-				return nil, fmt.Errorf("fill did not retrieve expected number of bytes, expected: %v, got: %v", count, n)
+				return nil, false, fmt.Errorf("fill did not retrieve expected number of bytes, expected: %v, got: %v", count, n)
 			}
 		} else {
 			cacheItem.lock.Unlock()
@@ -110,46 +195,153 @@ func (c *fileCache) GetOrCreate(name string, alignedOffset int64, count int, fet
 		cacheItem.lock.RLock()
 	}
 
-	result := cacheItem.bytes(c.log)
+	b := c.buffers.get(count)
+	data := cacheItem.bytes(c.log, b.data)
 	cacheItem.lock.RUnlock()
 
-	if len(result) != count {
-		return result, fmt.Errorf("bytes did not retrieve expected number of bytes, expected: %v, got: %v", count, len(result))
+	// The buffer keeps its data when the read fails, so that it can be returned to its pool.
+	if len(data) != count {
+		b.release()
+		return nil, false, fmt.Errorf("bytes did not retrieve expected number of bytes, expected: %v, got: %v", count, len(data))
+	}
+
+	b.data = data
+	return b, filled, nil
+}
+
+// Open opens the file of the given chunk of the file for reading, if it is cached with the given length.
+func (c *fileCache) Open(name string, offset int64, count int) (*os.File, error) {
+	key := c.getKey(name, offset)
+	val, found := c.fileCache.Get(key)
+	if !found {
+		return nil, os.ErrNotExist
+	}
+
+	cacheItem := val.(*item)
+	cacheItem.lock.RLock()
+	defer cacheItem.lock.RUnlock()
+
+	// The file is dropped or filled while the lock is held for writing.
+	if cacheItem.file == nil {
+		return nil, os.ErrNotExist
+	}
+
+	if info, err := cacheItem.file.Stat(); err != nil || info.Size() != int64(count) {
+		return nil, os.ErrNotExist
+	}
+
+	// The file has its own offset, so that readers do not move each other's.
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	cacheItem.touch()
+	c.metricsRecorder.RecordChunkCache(tierDisk, resultHit)
+	return f, nil
 }
 
+// Delete removes the given chunk of the file from the cache.
+func (c *fileCache) Delete(name string, offset int64) {
+	key := c.getKey(name, offset)
+	_, found := c.fileCache.Get(key)
+
+	c.fileCache.Del(key)
+	c.fileCache.Wait()
+
+	if found {
+		c.evicted(key)
+	}
+}
+
+// evicted calls the eviction callback with the file name and offset of the chunk with the given key, if there is one.
+func (c *fileCache) evicted(key string) {
+	if c.onEvict == nil {
+		return
+	}
+
+	name, offset, err := c.nameAndOffset(key)
+	if err != nil {
+		c.log.Error().Err(err).Str("key", key).Msg("failed to parse evicted key")
+		return
+	}
+	c.onEvict(name, offset)
+}
+
+// heldSize is the length of a file that is only kept in memory.
+type heldSize int64
+
 // Size gets the length of the file.
 func (c *fileCache) Size(name string) (int64, bool) {
-	key := filepath.Join(name, "metainfo")
+	key := filepath.Join(name, metainfo)
 	// c.metadataCache.Wait()
 	val, found := c.metadataCache.Get(key)
 	if !found {
 		return 0, false
 	}
-	return val.(int64), true
+
+	switch v := val.(type) {
+	case heldSize:
+		return int64(v), true
+	default:
+		return v.(int64), true
+	}
 }
 
 // PutSize puts the length of the file.
+// It is also written next to the chunks of the file, so that they can be loaded after a restart.
 func (c *fileCache) PutSize(name string, len int64) bool {
-	key := filepath.Join(name, "metainfo")
+	key := filepath.Join(name, metainfo)
+	if val, found := c.metadataCache.Get(key); found && val == any(len) {
+		return true
+	}
+
 	c.metadataCache.Set(key, len)
 	c.log.Debug().Str("key", key).Int64("len", len).Msg("put len")
+
+	if err := writeSize(filepath.Join(c.path, key), len); err != nil {
+		c.log.Error().Err(err).Str("key", key).Msg("failed to persist len")
+	}
 	return true
 }
 
+// HoldSize puts the length of the file in memory only.
+func (c *fileCache) HoldSize(name string, len int64) {
+	key := filepath.Join(name, metainfo)
+	c.metadataCache.Set(key, heldSize(len))
+	c.log.Debug().Str("key", key).Int64("len", len).Msg("hold len")
+}
+
+// DeleteSize removes the length of the file from memory and from disk.
+func (c *fileCache) DeleteSize(name string) {
+	c.metadataCache.Delete(filepath.Join(name, metainfo))
+	c.remove(name)
+}
+
 func (c *fileCache) getKey(name string, offset int64) string {
 	return filepath.Join(c.path, name, strconv.FormatInt(offset, 10))
 }
 
-func waitForSet() {
-	time.Sleep(10 * time.Millisecond)
+// nameAndOffset returns the file name and offset of the given item key.
+func (c *fileCache) nameAndOffset(key string) (string, int64, error) {
+	rel, err := filepath.Rel(c.path, key)
+	if err != nil {
+		return "", 0, err
+	}
+
+	offset, err := strconv.ParseInt(filepath.Base(rel), 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return filepath.Dir(rel), offset, nil
 }
 
 // New creates a new cache of files.
 // cacheBlockSize is the fixed size of the cache block in bytes, and is used to evaluate the cost of each item in the cache.
-func New(ctx context.Context, cacheBlockSize int64) Cache {
+// onEvict, if not nil, is called with the file name and offset of every cached chunk that is evicted or deleted. Chunks
+// that were not admitted to the cache are not reported, since they were never advertised.
+func New(ctx context.Context, cacheBlockSize int64, onEvict func(name string, offset int64)) Cache {
 	log := zerolog.Ctx(ctx).With().Str("component", "cache").Logger()
 
 	atomic.StoreInt32(&fdCnt, 0)
@@ -159,20 +351,38 @@ func New(ctx context.Context, cacheBlockSize int64) Cache {
 	}
 
 	cache := &fileCache{
-		log:           log,
-		path:          Path,
-		metadataCache: NewSyncMap(1e7),
+		log:             log,
+		path:            Path,
+		blockSize:       cacheBlockSize,
+		buffers:         newBufferPool(int(cacheBlockSize)),
+		metadataCache:   NewSyncMap(1e7),
+		onEvict:         onEvict,
+		usage:           diskUsage,
+		metricsRecorder: metrics.FromContext(ctx),
 	}
 
 	var err error
+	if cache.memory, err = newMemoryCache(MemoryCacheMaxCost); err != nil {
+		// This will call os.Exit(1)
+		log.Fatal().Err(err).Msg("failed to initialize memory cache")
+	}
+
 	if cache.fileCache, err = ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,
 		MaxCost:     FilesCacheMaxCost,
 		BufferItems: 64,
 
+		// OnExit is also called for chunks that are rejected by the admission policy or replaced, which were never
+		// evicted, so only OnEvict reports evictions.
 		OnExit: func(val interface{}) {
 			item := val.(*item)
 			item.drop(log)
+			cache.items.CompareAndDelete(item.key, item)
+			cache.memory.del(item.key)
+		},
+
+		OnEvict: func(i *ristretto.Item) {
+			cache.evicted(i.Value.(*item).key)
 		},
 
 		Cost: func(val interface{}) int64 {
@@ -183,5 +393,7 @@ func New(ctx context.Context, cacheBlockSize int64) Cache {
 		log.Fatal().Err(err).Msg("failed to initialize file cache")
 	}
 
+	go cache.watchDisk(ctx)
+
 	return cache
 }
@@ -4,9 +4,11 @@ package cache
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,7 +27,7 @@ var (
 func TestGetKey(t *testing.T) {
 	name := newRandomStringN(10)
 	offset := int64(100)
-	c := New(context.Background(), cacheBlockSize)
+	c := New(ctxWithMetrics, cacheBlockSize, nil)
 	got := c.(*fileCache).getKey(name, offset)
 	want := fmt.Sprintf("%v/%v/%v", Path, name, offset)
 	if got != want {
@@ -33,8 +35,93 @@ func TestGetKey(t *testing.T) {
 	}
 }
 
+func TestOnEvict(t *testing.T) {
+	type evicted struct {
+		name   string
+		offset int64
+	}
+	evictedCh := make(chan evicted, 1)
+
+	c := New(ctxWithMetrics, cacheBlockSize, func(name string, offset int64) {
+		evictedCh <- evicted{name, offset}
+	})
+
+	name := newRandomStringN(10)
+	offset := int64(1048576)
+	if _, err := c.GetOrCreate(name, offset, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clearing the cache evicts every chunk.
+	c.(*fileCache).fileCache.Clear()
+
+	select {
+	case got := <-evictedCh:
+		if got.name != name || got.offset != offset {
+			t.Errorf("expected eviction of %v at %v, got %v at %v", name, offset, got.name, got.offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected eviction callback")
+	}
+}
+
+func TestOnEvictIgnoresRejectedChunks(t *testing.T) {
+	maxCost := FilesCacheMaxCost
+	FilesCacheMaxCost = cacheBlockSize - 1
+	defer func() { FilesCacheMaxCost = maxCost }()
+
+	evictedCh := make(chan int64, 1)
+	c := New(ctxWithMetrics, cacheBlockSize, func(name string, offset int64) {
+		evictedCh <- offset
+	})
+
+	// Every chunk costs more than the capacity of the cache, so it is rejected.
+	name := newRandomStringN(10)
+	if _, err := c.GetOrCreate(name, 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); !errors.Is(err, ErrFillRefused) {
+		t.Fatalf("expected %v, got %v", ErrFillRefused, err)
+	}
+
+	// Deleting a chunk that is not cached does not evict it either.
+	c.Delete(name, 0)
+
+	select {
+	case offset := <-evictedCh:
+		t.Errorf("expected no eviction callback, got one for %v", offset)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDelete(t *testing.T) {
+	evictedCh := make(chan int64, 1)
+	c := New(ctxWithMetrics, cacheBlockSize, func(name string, offset int64) {
+		evictedCh <- offset
+	})
+
+	name := newRandomStringN(10)
+	if _, err := c.GetOrCreate(name, 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Delete(name, 0)
+	if c.Exists(name, 0) {
+		t.Error("expected chunk to be deleted")
+	}
+
+	select {
+	case <-evictedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected eviction callback")
+	}
+}
+
 func TestExists(t *testing.T) {
-	c := New(context.Background(), cacheBlockSize)
+	c := New(ctxWithMetrics, cacheBlockSize, nil)
 
 	filesThatExist := []string{}
 	for i := 0; i < 5; i++ {
@@ -117,7 +204,7 @@ func TestExists(t *testing.T) {
 }
 
 func TestPutAndGetSize(t *testing.T) {
-	c := New(context.Background(), cacheBlockSize)
+	c := New(ctxWithMetrics, cacheBlockSize, nil)
 	var eg errgroup.Group
 
 	for i := 0; i < 1000; i++ {
@@ -154,7 +241,7 @@ func TestPutAndGetSize(t *testing.T) {
 func TestGetOrCreate(t *testing.T) {
 	zerolog.TimeFieldFormat = time.RFC3339
 	//c := New(zerolog.New(os.Stdout).With().Timestamp().Logger().WithContext(context.Background()))
-	c := New(context.Background(), cacheBlockSize)
+	c := New(ctxWithMetrics, cacheBlockSize, nil)
 	var eg errgroup.Group
 
 	fileNames := new(sync.Map)
@@ -228,3 +315,40 @@ func TestGetOrCreate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestOpen(t *testing.T) {
+	c := New(ctxWithMetrics, cacheBlockSize, nil)
+	name := newRandomStringN(10)
+
+	if _, err := c.Open(name, 0, 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v for chunk that is not cached, got %v", os.ErrNotExist, err)
+	}
+
+	if _, err := c.GetOrCreate(name, 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Open(name, 0, 5); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v for chunk of another length, got %v", os.ErrNotExist, err)
+	}
+
+	f, err := c.Open(name, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The opened file is still readable once the chunk is removed.
+	c.Delete(name, 0)
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "0123456789" {
+		t.Errorf("expected %v, got %v", "0123456789", string(got))
+	}
+}
@@ -2,6 +2,13 @@
 // Licensed under the MIT License.
 package cache
 
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
 // Cache describes a cache of files.
 type Cache interface {
 	// Size gets the size of the file.
@@ -10,19 +17,61 @@ type Cache interface {
 	// PutSize sets size of the file.
 	PutSize(path string, length int64) bool
 
+	// HoldSize sets the size of the file in memory only, such as a size that cannot be trusted yet. It is not loaded
+	// after a restart, unless PutSize is called with it first.
+	HoldSize(path string, length int64)
+
+	// DeleteSize removes the size of the file, from memory and from disk.
+	DeleteSize(path string)
+
 	// Exists checks if the given chunk of the file is already cached.
 	Exists(name string, offset int64) bool
 
-	// GetOrCreate gets the cached value if available, otherwise downloads the file.
+	// GetOrCreate gets a copy of the cached value if available, otherwise downloads the file.
 	GetOrCreate(name string, offset int64, count int, fetch func() ([]byte, error)) ([]byte, error)
+
+	// View calls view with the cached value if available, otherwise downloads the file first. The value is only valid
+	// until view returns, and must not be modified.
+	View(name string, offset int64, count int, fetch func() ([]byte, error), view func(data []byte)) error
+
+	// Open opens the file of the given chunk of the file for reading, if it is cached with the given length, so that it
+	// can be sent without reading it into memory. The caller must close it.
+	Open(name string, offset int64, count int) (*os.File, error)
+
+	// Delete removes the given chunk of the file from the cache, as if it was evicted.
+	Delete(name string, offset int64)
+
+	// Load indexes the chunks left on disk by a previous run and returns their number. Chunks that are not valid, or
+	// that keep does not accept, are removed.
+	Load(keep func(name string, offset int64, chunk *io.SectionReader) bool) int
 }
 
+// ErrFillRefused is returned when a chunk that is not cached is not filled, such as when the disk is under pressure or
+// the chunk is not admitted to the cache. The chunk can still be read from its source without caching it.
+var ErrFillRefused = errors.New("cache fill refused")
+
 var (
-	// FilesCacheMaxCost is the capacity of the files cache.
+	// FilesCacheMaxCost is the capacity of the files cache. The cache is also bounded by the free space on disk, see
+	// DiskHighWatermark.
 	FilesCacheMaxCost int64 = 4 * 1024 * 1024 * 1024 // 4 Gib
 
-	// MemoryCacheMaxCost is the capacity of the memory cache.
-	MemoryCacheMaxCost int64 = 1 * 1024 * 1024 * 1024 // 1 Gib
+	// DiskHighWatermark is the percentage of the file system holding Path in use above which chunks are evicted, least
+	// recently read first, until the usage is back to DiskLowWatermark.
+	DiskHighWatermark = 75
+
+	// DiskLowWatermark is the percentage of the file system holding Path in use that eviction under pressure stops at.
+	DiskLowWatermark = 65
+
+	// DiskFillLimit is the percentage of the file system holding Path in use above which chunks are no longer cached.
+	// It is below the default image garbage collection threshold of kubelet (85%), so that cached chunks do not make
+	// kubelet remove images.
+	DiskFillLimit = 80
+
+	// DiskPressureInterval is how often the usage of the file system holding Path is checked, never if zero.
+	DiskPressureInterval = 10 * time.Second
+
+	// MemoryCacheMaxCost is the capacity of the memory cache, disabled if zero.
+	MemoryCacheMaxCost int64 = 0
 
 	// Path is the path to the cache directory.
 	Path string = "/tmp/distribution/peerd/cache"
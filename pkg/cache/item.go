@@ -8,6 +8,7 @@ import (
 	"path"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -19,6 +20,14 @@ type item struct {
 	key  string
 	file *os.File
 	lock *sync.RWMutex
+
+	// used is the time the chunk was last read, in nanoseconds since the epoch.
+	used atomic.Int64
+}
+
+// touch marks the chunk as read now.
+func (i *item) touch() {
+	i.used.Store(time.Now().UnixNano())
 }
 
 // drop deletes the underlying file.
@@ -40,9 +49,9 @@ func (i *item) drop(l zerolog.Logger) {
 	i.file = nil
 }
 
-// bytes returns the file bytes.
-func (i *item) bytes(l zerolog.Logger) []byte {
-	b, err := readFromStart(i.file)
+// bytes returns the file bytes, read into buf if it is large enough.
+func (i *item) bytes(l zerolog.Logger, buf []byte) []byte {
+	b, err := readFromStart(i.file, buf)
 	if err != nil {
 		l.Error().Err(err).Str("name", i.file.Name()).Msg("failed to read file")
 		return nil
@@ -72,15 +81,18 @@ func (i *item) fill(log zerolog.Logger, fetch func() ([]byte, error)) (int, erro
 	return l, nil
 }
 
-// readFromStart reads the entire file from the beginning.
-func readFromStart(file *os.File) ([]byte, error) {
+// readFromStart reads the entire file from the beginning, into buf if it is large enough.
+func readFromStart(file *os.File, buf []byte) ([]byte, error) {
 	info, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 
 	fileSize := info.Size()
-	fileContent := make([]byte, fileSize)
+	if int64(cap(buf)) < fileSize {
+		buf = make([]byte, fileSize)
+	}
+	fileContent := buf[:fileSize]
 	offset := int64(0)
 
 	for offset < fileSize && err == nil {
@@ -129,5 +141,6 @@ func newItem(key string, l zerolog.Logger) (*item, error) {
 		return nil, err
 	}
 
+	cacheItem.touch()
 	return cacheItem, nil
 }
@@ -68,7 +68,7 @@ func TestReadFromStart(t *testing.T) {
 	}
 
 	// Test
-	got, err := readFromStart(i.file)
+	got, err := readFromStart(i.file, nil)
 	if err != nil {
 		t.Fatal(err)
 	} else if string(got) != string(data) {
@@ -141,7 +141,7 @@ func TestBytes(t *testing.T) {
 	}
 
 	// Test
-	got = i.bytes(l)
+	got = i.bytes(l, nil)
 
 	// Assert
 	if string(got) != string(data) {
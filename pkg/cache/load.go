@@ -0,0 +1,165 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Load indexes the chunks left on disk by a previous run, such as before a restart, along with the lengths of their files.
+// A chunk is kept if the length of its file is known, its size matches its offset in the file, and keep, if not nil,
+// accepts it. Other chunks, and the lengths of files without chunks, are removed from disk.
+func (c *fileCache) Load(keep func(name string, offset int64, chunk *io.SectionReader) bool) int {
+	sizes := map[string]int64{}
+	chunks := map[string][]int64{}
+
+	removed := 0
+	if err := filepath.WalkDir(c.path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.path, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Dir(rel)
+		if name != "." {
+			if d.Name() == metainfo {
+				if size, err := readSize(p); err == nil {
+					sizes[name] = size
+					return nil
+				}
+			} else if offset, err := strconv.ParseInt(d.Name(), 10, 64); err == nil {
+				chunks[name] = append(chunks[name], offset)
+				return nil
+			}
+		}
+
+		// Leftovers, such as partially written lengths.
+		removed++
+		return os.Remove(p)
+	}); err != nil {
+		c.log.Error().Err(err).Str("path", c.path).Msg("failed to scan cache directory")
+	}
+
+	loaded := 0
+	for name, offsets := range chunks {
+		size, ok := sizes[name]
+		kept := 0
+		for _, offset := range offsets {
+			if ok && c.load(name, offset, size, keep) {
+				kept++
+				continue
+			}
+
+			if !ok {
+				// Chunks that are not valid are removed by load.
+				_ = os.Remove(c.getKey(name, offset))
+			}
+			removed++
+		}
+
+		if kept == 0 {
+			c.remove(name)
+			continue
+		}
+
+		c.metadataCache.Set(filepath.Join(name, metainfo), size)
+		loaded += kept
+	}
+
+	for name := range sizes {
+		if _, ok := chunks[name]; !ok {
+			c.remove(name)
+		}
+	}
+
+	c.fileCache.Wait()
+	c.log.Info().Int("loaded", loaded).Int("removed", removed).Msg("cache load")
+	return loaded
+}
+
+// load indexes the chunk of the file at offset, or removes it from disk if it is not valid.
+func (c *fileCache) load(name string, offset, size int64, keep func(name string, offset int64, chunk *io.SectionReader) bool) bool {
+	key := c.getKey(name, offset)
+	i, err := newItem(key, c.log)
+	if err != nil {
+		c.log.Error().Err(err).Str("key", key).Msg("failed to open cached chunk")
+		_ = os.Remove(key)
+		return false
+	}
+
+	info, err := i.file.Stat()
+	valid := err == nil && offset%c.blockSize == 0 && offset < size && info.Size() == min(c.blockSize, size-offset)
+	if valid && keep != nil {
+		valid = keep(name, offset, io.NewSectionReader(i.file, 0, info.Size()))
+	}
+
+	if !valid {
+		i.drop(c.log)
+		return false
+	}
+
+	// Chunks left by a previous run were last read at the latest when they were written.
+	i.used.Store(info.ModTime().UnixNano())
+
+	c.items.Store(key, i)
+	if !c.fileCache.Set(key, i, 0) {
+		c.items.CompareAndDelete(key, i)
+		i.drop(c.log)
+		return false
+	}
+
+	return true
+}
+
+// remove removes the length of the file without chunks and its directory from disk.
+func (c *fileCache) remove(name string) {
+	if err := os.Remove(filepath.Join(c.path, name, metainfo)); err != nil && !os.IsNotExist(err) {
+		c.log.Error().Err(err).Str("name", name).Msg("failed to remove file length")
+	}
+
+	// The directory is only removed if it is empty.
+	_ = os.Remove(filepath.Join(c.path, name))
+}
+
+// readSize reads the length of a file.
+func readSize(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// writeSize writes the length of a file, replacing the previous one at once.
+func writeSize(path string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), metainfo+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatInt(size, 10)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
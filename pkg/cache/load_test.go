@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	defer func(p string) { Path = p }(Path)
+	Path = t.TempDir()
+
+	blockSize := int64(10)
+	c := New(ctxWithMetrics, blockSize, nil)
+
+	// A file of two chunks, one of which is corrupt.
+	c.PutSize("file", 15)
+	for offset, content := range map[int64]string{0: "0123456789", 10: "abcde"} {
+		if _, err := c.GetOrCreate("file", offset, len(content), func() ([]byte, error) {
+			return []byte(content), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A chunk of the wrong size.
+	c.PutSize("short", 15)
+	if _, err := c.GetOrCreate("short", 0, 5, func() ([]byte, error) {
+		return []byte("01234"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A chunk of a file of unknown size, and the size of a file without chunks.
+	if _, err := c.GetOrCreate("unknown", 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.PutSize("empty", 15)
+
+	// A chunk of a file whose size was removed.
+	c.PutSize("deleted", 10)
+	if _, err := c.GetOrCreate("deleted", 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.DeleteSize("deleted")
+	if _, ok := c.Size("deleted"); ok {
+		t.Error("expected deleted size to be removed")
+	}
+
+	// A chunk of a file whose size is only held in memory.
+	c.HoldSize("held", 10)
+	if _, err := c.GetOrCreate("held", 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := c.Size("held"); !ok || size != 10 {
+		t.Errorf("expected held size 10, got %v", size)
+	}
+
+	// A leftover of a length being written.
+	if err := os.WriteFile(filepath.Join(Path, "file", metainfo+".123"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache is loaded again, as after a restart.
+	loaded := New(ctxWithMetrics, blockSize, nil)
+	n := loaded.Load(func(name string, offset int64, chunk *io.SectionReader) bool {
+		b, err := io.ReadAll(chunk)
+		return err == nil && string(b) != "abcde"
+	})
+
+	if n != 1 {
+		t.Errorf("expected 1 chunk loaded, got %v", n)
+	}
+
+	if !loaded.Exists("file", 0) {
+		t.Error("expected valid chunk to be loaded")
+	}
+
+	if size, ok := loaded.Size("file"); !ok || size != 15 {
+		t.Errorf("expected size 15 to be loaded, got %v", size)
+	}
+
+	b, err := loaded.GetOrCreate("file", 0, 10, func() ([]byte, error) {
+		t.Error("expected loaded chunk to not be fetched")
+		return nil, io.EOF
+	})
+	if err != nil || string(b) != "0123456789" {
+		t.Errorf("expected loaded chunk content, got %v, %v", string(b), err)
+	}
+
+	for _, p := range []string{"file/10", "file/" + metainfo + ".123", "short", "unknown", "empty", "held", "deleted"} {
+		if _, err := os.Stat(filepath.Join(Path, p)); !os.IsNotExist(err) {
+			t.Errorf("expected %v to be removed, got %v", p, err)
+		}
+	}
+}
@@ -3,11 +3,18 @@
 package cache
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"math/big"
 	"os"
 	"testing"
+
+	"github.com/azure/peerd/pkg/metrics"
+)
+
+var (
+	ctxWithMetrics, _ = metrics.WithContext(context.Background(), "test", "peerd")
 )
 
 func TestMain(m *testing.M) {
@@ -23,6 +30,9 @@ func TestMain(m *testing.M) {
 func setup() {
 	suf := newRandomStringN(10)
 	Path += suf
+
+	// The memory tier is disabled by default.
+	MemoryCacheMaxCost = 1 * 1024 * 1024 * 1024
 }
 
 // teardown removes the cache directory.
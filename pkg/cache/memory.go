@@ -0,0 +1,153 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// buffer holds the data of a chunk read from disk. It is shared by the memory tier and the readers of the chunk, and
+// is returned to its pool once none of them hold it.
+type buffer struct {
+	data []byte
+	refs atomic.Int32
+	pool *bufferPool
+
+	// gen is incremented every time the buffer is taken from its pool, so that a reader that found it in the memory tier
+	// before it was recycled can tell.
+	gen atomic.Uint64
+}
+
+// acquire adds a reader of the buffer, unless it was already returned to its pool.
+func (b *buffer) acquire() bool {
+	for {
+		refs := b.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if b.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release removes a holder of the buffer, and returns it to its pool if it was the last.
+func (b *buffer) release() {
+	if b.refs.Add(-1) == 0 && b.pool != nil {
+		b.pool.pool.Put(b)
+	}
+}
+
+// bufferPool is a pool of buffers of the size of a chunk, so that reading a chunk from disk does not allocate.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool creates a new pool of buffers of the given size.
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		return &buffer{data: make([]byte, size), pool: p}
+	}
+	return p
+}
+
+// get returns a buffer of the given length held by the caller, which must release it.
+// Buffers larger than the size of the pool are allocated, and are not pooled.
+func (p *bufferPool) get(n int) *buffer {
+	b := &buffer{data: make([]byte, n)}
+	if n <= p.size {
+		b = p.pool.Get().(*buffer)
+		b.data = b.data[:n]
+	}
+
+	b.gen.Add(1)
+	b.refs.Store(1)
+	return b
+}
+
+// memoryEntry is a chunk held in the memory tier.
+type memoryEntry struct {
+	b   *buffer
+	gen uint64
+}
+
+// memoryCache is the hot tier of the cache, which holds chunks in memory in front of their files on disk.
+// A chunk is promoted to memory when it is read from disk, and admitted if it is read more often than the chunks it
+// would replace. A chunk is demoted by dropping it from memory, its file stays on disk.
+type memoryCache struct {
+	cache *ristretto.Cache
+}
+
+// newMemoryCache creates a new memory tier of the given capacity in bytes, or returns nil if it is not positive.
+func newMemoryCache(maxCost int64) (*memoryCache, error) {
+	if maxCost <= 0 {
+		return nil, nil
+	}
+
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        1e7,
+		MaxCost:            maxCost,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+
+		OnExit: func(val interface{}) {
+			val.(*memoryEntry).b.release()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryCache{cache: c}, nil
+}
+
+// get returns the buffer of the chunk with the given key and length, held by the caller, which must release it.
+func (m *memoryCache) get(key string, n int) (*buffer, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	val, found := m.cache.Get(key)
+	if !found {
+		return nil, false
+	}
+
+	e := val.(*memoryEntry)
+	b := e.b
+	if !b.acquire() {
+		return nil, false
+	}
+
+	if b.gen.Load() != e.gen || len(b.data) != n {
+		b.release()
+		return nil, false
+	}
+
+	return b, true
+}
+
+// put promotes the buffer of the chunk with the given key to memory.
+func (m *memoryCache) put(key string, b *buffer) {
+	if m == nil || !b.acquire() {
+		return
+	}
+
+	// The cost is computed here, because the buffer may be recycled by the time the cache would compute it.
+	if !m.cache.Set(key, &memoryEntry{b: b, gen: b.gen.Load()}, int64(len(b.data))) {
+		b.release()
+	}
+}
+
+// del drops the chunk with the given key from memory, such as when its file is removed.
+func (m *memoryCache) del(key string) {
+	if m == nil {
+		return
+	}
+
+	m.cache.Del(key)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMemoryTier(t *testing.T) {
+	c := New(ctxWithMetrics, cacheBlockSize, nil).(*fileCache)
+	name := newRandomStringN(10)
+	key := c.getKey(name, 0)
+
+	fetches := 0
+	fetch := func() ([]byte, error) {
+		fetches++
+		return []byte("0123456789"), nil
+	}
+
+	// A chunk is not promoted when it is filled.
+	if _, err := c.GetOrCreate(name, 0, 10, fetch); err != nil {
+		t.Fatal(err)
+	}
+	c.memory.cache.Wait()
+	if _, ok := c.memory.get(key, 10); ok {
+		t.Error("expected filled chunk to not be in memory")
+	}
+
+	// A chunk is promoted when it is read from disk.
+	if _, err := c.GetOrCreate(name, 0, 10, fetch); err != nil {
+		t.Fatal(err)
+	}
+	c.memory.cache.Wait()
+	b, ok := c.memory.get(key, 10)
+	if !ok {
+		t.Fatal("expected chunk read from disk to be in memory")
+	}
+	b.release()
+
+	var got string
+	if err := c.View(name, 0, 10, fetch, func(data []byte) { got = string(data) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "0123456789" {
+		t.Errorf("expected %v, got %v", "0123456789", got)
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %v", fetches)
+	}
+
+	// A chunk removed from disk is dropped from memory.
+	c.Delete(name, 0)
+	c.memory.cache.Wait()
+	if _, ok := c.memory.get(key, 10); ok {
+		t.Error("expected deleted chunk to not be in memory")
+	}
+
+	if err := c.View(name, 0, 10, func() ([]byte, error) {
+		return nil, errors.New("not cached")
+	}, func([]byte) {}); err == nil {
+		t.Error("expected deleted chunk to be fetched again")
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	p := newBufferPool(10)
+
+	b := p.get(5)
+	if len(b.data) != 5 || cap(b.data) != 10 {
+		t.Errorf("expected buffer of length 5 and capacity 10, got %v and %v", len(b.data), cap(b.data))
+	}
+
+	if !b.acquire() {
+		t.Fatal("expected held buffer to be acquired")
+	}
+	b.release()
+	b.release()
+
+	if b.acquire() {
+		t.Error("expected released buffer to not be acquired")
+	}
+
+	// A reader that found the buffer before it was recycled can tell.
+	e := memoryEntry{b: b, gen: b.gen.Load()}
+	b.gen.Add(1)
+	b.refs.Store(1)
+	if b.acquire() && b.gen.Load() == e.gen {
+		t.Error("expected recycled buffer to be of another generation")
+	}
+
+	if large := p.get(20); large.pool != nil || len(large.data) != 20 {
+		t.Error("expected large buffer to not be pooled")
+	}
+}
+
+func TestReadErrorKeepsPooledBuffer(t *testing.T) {
+	c := New(ctxWithMetrics, cacheBlockSize, nil).(*fileCache)
+	name := newRandomStringN(10)
+	key := c.getKey(name, 0)
+
+	fetch := func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	}
+
+	if _, err := c.GetOrCreate(name, 0, 10, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file of the chunk can no longer be read.
+	val, found := c.fileCache.Get(key)
+	if !found {
+		t.Fatal("expected chunk to be cached")
+	}
+	i := val.(*item)
+	i.lock.Lock()
+	i.file.Close()
+	f, err := os.OpenFile(key, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.file = f
+	i.lock.Unlock()
+
+	if _, err := c.GetOrCreate(name, 0, 10, fetch); err == nil {
+		t.Fatal("expected read of unreadable chunk to fail")
+	}
+
+	// The buffer of the failed read is returned to its pool with its data.
+	for n := 0; n < 10; n++ {
+		if b := c.buffers.get(10); len(b.data) != 10 {
+			t.Fatalf("expected buffer of length 10, got %v", len(b.data))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/azure/peerd/pkg/k8s/events"
+)
+
+// watchDisk checks the usage of the file system holding the cache every DiskPressureInterval, until ctx is done.
+func (c *fileCache) watchDisk(ctx context.Context) {
+	if DiskPressureInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(DiskPressureInterval)
+	defer ticker.Stop()
+
+	for {
+		c.checkDisk(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDisk refuses to cache more chunks while the usage of the file system holding the cache is above DiskFillLimit,
+// and evicts the least recently read chunks down to DiskLowWatermark once it is above DiskHighWatermark.
+func (c *fileCache) checkDisk(ctx context.Context) {
+	used, size, err := c.usage(c.path)
+	if err != nil || size == 0 {
+		c.log.Error().Err(err).Str("path", c.path).Msg("failed to get disk usage")
+		return
+	}
+
+	percent := int(used * 100 / size)
+	refusing := percent >= DiskFillLimit
+	if c.refusing.Swap(refusing) != refusing {
+		c.log.Warn().Int("usage", percent).Bool("refusing", refusing).Msg("disk fill limit crossed")
+	}
+
+	if percent < DiskHighWatermark {
+		c.pressured.Store(false)
+		return
+	}
+
+	// The event is only recorded once while the disk stays under pressure.
+	if !c.pressured.Swap(true) {
+		events.FromContext(ctx).DiskPressure(c.path, percent)
+	}
+
+	target := int64(used) - int64(size)*int64(DiskLowWatermark)/100
+	evicted, freed := c.evict(target)
+	c.log.Warn().Int("usage", percent).Int("evicted", evicted).Int64("freed", freed).Msg("disk pressure")
+}
+
+// evict removes the least recently read chunks from the cache until at least target bytes are freed, and returns the
+// number of chunks and bytes removed. Chunks can only free what they hold, so the target may not be reached.
+func (c *fileCache) evict(target int64) (int, int64) {
+	type candidate struct {
+		key  string
+		used int64
+	}
+
+	candidates := []candidate{}
+	c.items.Range(func(key, val any) bool {
+		candidates = append(candidates, candidate{key: key.(string), used: val.(*item).used.Load()})
+		return true
+	})
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(a.used, b.used)
+	})
+
+	evicted, freed := 0, int64(0)
+	for _, cand := range candidates {
+		if freed >= target {
+			break
+		}
+
+		if info, err := os.Stat(cand.key); err == nil {
+			freed += info.Size()
+		}
+		c.fileCache.Del(cand.key)
+		evicted++
+	}
+
+	c.fileCache.Wait()
+
+	for _, cand := range candidates[:evicted] {
+		c.evicted(cand.key)
+	}
+
+	return evicted, freed
+}
+
+// diskUsage returns the bytes in use and the size of the file system holding path. Blocks reserved for the root user
+// are counted as in use, as kubelet does.
+func diskUsage(path string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+
+	size := st.Blocks * uint64(st.Bsize)
+	return size - st.Bavail*uint64(st.Bsize), size, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/azure/peerd/pkg/k8s/events"
+)
+
+func TestDiskPressure(t *testing.T) {
+	ctx, err := events.WithContext(ctxWithMetrics, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(ctx, cacheBlockSize, nil).(*fileCache)
+	name := newRandomStringN(10)
+	content := []byte(newRandomStringN(100))
+	fetch := func() ([]byte, error) {
+		return content, nil
+	}
+
+	// Chunks are read in order of their offsets, then the first one again.
+	for _, offset := range []int64{0, 1, 2, 3, 4, 0} {
+		if _, err := c.GetOrCreate(name, offset, len(content), fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	used := uint64(900)
+	c.usage = func(string) (uint64, uint64, error) {
+		return used, 1000, nil
+	}
+
+	// Above the high watermark, 250 bytes are freed to get to the low watermark.
+	c.checkDisk(ctx)
+
+	if !c.refusing.Load() || !c.pressured.Load() {
+		t.Fatal("expected disk to be under pressure")
+	}
+
+	for offset, exists := range []bool{true, false, false, false, true} {
+		if got := c.Exists(name, int64(offset)); got != exists {
+			t.Errorf("chunk %v: expected exists to be %v, got %v", offset, exists, got)
+		}
+	}
+
+	// Above the fill limit, chunks are still read but not filled.
+	if _, err := c.GetOrCreate(name, 0, len(content), fetch); err != nil {
+		t.Errorf("expected cached chunk to be read, got %v", err)
+	}
+
+	if _, err := c.GetOrCreate(name, 1, len(content), func() ([]byte, error) {
+		return nil, fmt.Errorf("unexpected fetch")
+	}); !errors.Is(err, ErrFillRefused) {
+		t.Errorf("expected %v, got %v", ErrFillRefused, err)
+	}
+
+	used = 500
+	c.checkDisk(ctx)
+
+	if c.refusing.Load() || c.pressured.Load() {
+		t.Fatal("expected disk to not be under pressure")
+	}
+
+	if _, err := c.GetOrCreate(name, 1, len(content), fetch); err != nil {
+		t.Errorf("expected chunk to be filled, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ package containerd
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -19,6 +20,7 @@ const (
 	dockerContentDigestHeader = "Docker-Content-Digest"
 	contentLengthHeader       = "Content-Length"
 	contentTypeHeader         = "Content-Type"
+	acceptRangesHeader        = "Accept-Ranges"
 )
 
 // Registry is a handler that handles requests to this registry.
@@ -122,18 +124,24 @@ func (r *Registry) handleBlob(c pcontext.Context, dgst digest.Digest) {
 		return
 	}
 
+	c.Header(contentTypeHeader, "application/octet-stream")
 	c.Header(contentLengthHeader, strconv.FormatInt(size, 10))
 	c.Header(dockerContentDigestHeader, dgst.String())
+	// Blobs are served in ranges, so that the mirror of a peer can fetch parts of a blob from several peers at once.
+	c.Header(acceptRangesHeader, "bytes")
 	if c.Request.Method == http.MethodHead {
 		return
 	}
 
-	err = r.containerdStore.Write(c, c.Writer, dgst)
+	ra, err := r.containerdStore.ReaderAt(c, dgst)
 	if err != nil {
 		//nolint
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	defer ra.Close()
+
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, io.NewSectionReader(ra, 0, size))
 }
 
 // NewRegistry creates a new registry handler.
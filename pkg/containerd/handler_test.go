@@ -105,6 +105,44 @@ func TestHandleBlob(t *testing.T) {
 	}
 }
 
+func TestHandleBlobRange(t *testing.T) {
+	img, err := ParseReference("library/alpine:3.18.0", "sha256:blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry(NewMockContainerdStore([]Reference{img}))
+
+	mr := httptest.NewRecorder()
+	mc, _ := gin.CreateTestContext(mr)
+
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/v2/library/alpine/blobs/sha256:blob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=1-2")
+
+	mc.Request = req
+
+	r.handleBlob(pcontext.Context{Context: mc}, "sha256:blob")
+
+	if mr.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", mr.Code)
+	}
+
+	if mr.Body.String() != "es" {
+		t.Fatalf("expected es, got %s", mr.Body.String())
+	}
+
+	if mr.Header().Get(contentLengthHeader) != "2" {
+		t.Fatalf("expected 2, got %s", mr.Header().Get(contentLengthHeader))
+	}
+
+	if mr.Header().Get("Content-Range") != "bytes 1-2/4" {
+		t.Fatalf("expected bytes 1-2/4, got %s", mr.Header().Get("Content-Range"))
+	}
+}
+
 func TestHandle(t *testing.T) {
 	img, err := ParseReference("library/alpine:3.18.0", "sha256:bb863d6b95453b6b10dfaa1a52cb53f453d9a97ee775808ebaf6533bb4c9bb30")
 	if err != nil {
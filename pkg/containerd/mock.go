@@ -3,9 +3,11 @@
 package containerd
 
 import (
+	"bytes"
 	"context"
 	"io"
 
+	"github.com/containerd/containerd/content"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -25,8 +27,8 @@ func (m *MockContainerdStore) Verify(ctx context.Context) error {
 	return nil
 }
 
-func (m *MockContainerdStore) Subscribe(ctx context.Context) (<-chan Reference, <-chan error) {
-	return nil, nil
+func (m *MockContainerdStore) Subscribe(ctx context.Context) (<-chan Reference, <-chan string, <-chan error) {
+	return nil, nil, nil
 }
 
 func (m *MockContainerdStore) List(ctx context.Context) ([]Reference, error) {
@@ -57,6 +59,18 @@ func (m *MockContainerdStore) Write(ctx context.Context, dst io.Writer, dgst dig
 	return err
 }
 
+func (m *MockContainerdStore) ReaderAt(ctx context.Context, dgst digest.Digest) (content.ReaderAt, error) {
+	return &mockReaderAt{bytes.NewReader([]byte("test"))}, nil
+}
+
+type mockReaderAt struct {
+	*bytes.Reader
+}
+
+func (r *mockReaderAt) Close() error {
+	return nil
+}
+
 func (m *MockContainerdStore) Bytes(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
 	for _, r := range m.refs {
 		if r.Digest() == dgst {
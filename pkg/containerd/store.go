@@ -32,9 +32,9 @@ const (
 
 // Store is the interface for all containerd content store artifacts.
 type Store interface {
-	// Subscribe returns a channel of artifacts and a channel of errors.
-	// Artifacts are sent on the channel as they are discovered.
-	Subscribe(ctx context.Context) (<-chan Reference, <-chan error)
+	// Subscribe returns a channel of artifacts, a channel of deleted image names and a channel of errors.
+	// Artifacts are sent on the channel as they are discovered, and names as images are deleted.
+	Subscribe(ctx context.Context) (<-chan Reference, <-chan string, <-chan error)
 
 	// List returns a list of artifacts.
 	List(ctx context.Context) ([]Reference, error)
@@ -51,6 +51,9 @@ type Store interface {
 	// Write writes the artifact bytes to the writer.
 	Write(ctx context.Context, dst io.Writer, dgst digest.Digest) error
 
+	// ReaderAt returns a reader of the artifact bytes at any offset, which must be closed.
+	ReaderAt(ctx context.Context, dgst digest.Digest) (content.ReaderAt, error)
+
 	// Verify will verify that the client status is healthy.
 	Verify(ctx context.Context) error
 
@@ -123,18 +126,23 @@ func (c *store) Verify(ctx context.Context) error {
 }
 
 // Subscribe provides a subscription to containerd events on the configured hosts artifacts.
-// It also returns a channel of errors.
-func (c *store) Subscribe(ctx context.Context) (<-chan Reference, <-chan error) {
+// It also returns a channel of deleted image names and a channel of errors.
+func (c *store) Subscribe(ctx context.Context) (<-chan Reference, <-chan string, <-chan error) {
 	refChan := make(chan Reference)
+	deleteChan := make(chan string)
 	errChan := make(chan error)
 
 	eventsChan, eventsErrChan := c.client.EventService().Subscribe(ctx, c.eventFilter)
 	go func() {
 		for event := range eventsChan {
-			name, err := getEventImageName(event.Event)
+			name, deleted, err := getEventImageName(event.Event)
 			if err != nil {
 				errChan <- err
 				continue
+			} else if deleted {
+				// The image no longer exists, so only its name is known.
+				deleteChan <- name
+				continue
 			}
 
 			image, err := c.client.GetImage(ctx, name)
@@ -158,7 +166,7 @@ func (c *store) Subscribe(ctx context.Context) (<-chan Reference, <-chan error)
 		}
 	}()
 
-	return refChan, errChan
+	return refChan, deleteChan, errChan
 }
 
 // List returns the list of locally found images.
@@ -308,20 +316,27 @@ func (c *store) Write(ctx context.Context, dst io.Writer, dgst digest.Digest) er
 	return nil
 }
 
-// getEventImageName will get the image name from an event.
-func getEventImageName(e typeurl.Any) (string, error) {
+// ReaderAt returns a reader of the blob bytes at any offset.
+func (c *store) ReaderAt(ctx context.Context, dgst digest.Digest) (content.ReaderAt, error) {
+	return c.client.ContentStore().ReaderAt(ctx, ocispec.Descriptor{Digest: dgst})
+}
+
+// getEventImageName will get the image name from an event, and whether the image was deleted.
+func getEventImageName(e typeurl.Any) (string, bool, error) {
 	evt, err := typeurl.UnmarshalAny(e)
 	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal any: %w", err)
+		return "", false, fmt.Errorf("failed to unmarshal any: %w", err)
 	}
 
 	switch e := evt.(type) {
 	case *eventtypes.ImageCreate:
-		return e.Name, nil
+		return e.Name, false, nil
 	case *eventtypes.ImageUpdate:
-		return e.Name, nil
+		return e.Name, false, nil
+	case *eventtypes.ImageDelete:
+		return e.Name, true, nil
 	default:
-		return "", fmt.Errorf("unsupported event: %v", e)
+		return "", false, fmt.Errorf("unsupported event: %v", e)
 	}
 }
 
@@ -330,7 +345,7 @@ func getListFilter(hosts []string) string {
 }
 
 func getEventFilter(hosts []string) string {
-	return fmt.Sprintf(`topic~="/images/create|/images/update",event.name~="%s"`, strings.Join(getHostNames(hosts), "|"))
+	return fmt.Sprintf(`topic~="/images/create|/images/update|/images/delete",event.name~="%s"`, strings.Join(getHostNames(hosts), "|"))
 }
 
 func getHostNames(hosts []string) []string {
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,7 +34,7 @@ func TestCreateFilter(t *testing.T) {
 			name:                "only registries",
 			hosts:               []string{"https://docker.io", "https://gcr.io"},
 			expectedListFilter:  `name~="docker.io|gcr.io"`,
-			expectedEventFilter: `topic~="/images/create|/images/update",event.name~="docker.io|gcr.io"`,
+			expectedEventFilter: `topic~="/images/create|/images/update|/images/delete",event.name~="docker.io|gcr.io"`,
 		},
 	}
 
@@ -571,8 +572,9 @@ func TestSubscribe(t *testing.T) {
 	s, err := newStore([]string{"ghcr.io"}, client)
 	require.NoError(t, err)
 
-	gotEnvCh, gotErrCh := s.Subscribe(context.Background())
+	gotEnvCh, gotDelCh, gotErrCh := s.Subscribe(context.Background())
 	require.NotNil(t, gotEnvCh)
+	require.NotNil(t, gotDelCh)
 	require.NotNil(t, gotErrCh)
 
 	testDone := make(chan struct{})
@@ -580,28 +582,31 @@ func TestSubscribe(t *testing.T) {
 		testDone <- struct{}{}
 		close(testDone)
 	}()
-	errorCount := 0
-	eventsCount := 0
-	totalCount := 0
+	var errorCount, eventsCount, deleteCount, totalCount atomic.Int32
 
 	go func() {
 		for {
 			select {
 			case <-gotEnvCh:
-				totalCount++
-				eventsCount++
+				totalCount.Add(1)
+				if n := eventsCount.Add(1); n > 1 {
+					t.Errorf("got %d events, want 1", n)
+				}
+
+			case name := <-gotDelCh:
+				totalCount.Add(1)
+				deleteCount.Add(1)
 
-				if eventsCount > 1 {
-					t.Errorf("got %d events, want 1", eventsCount)
+				if name != "ghcr.io/distribution/distribution:v0.0.8" {
+					t.Errorf("got deleted image %s, want ghcr.io/distribution/distribution:v0.0.8", name)
 				}
 
 			case e := <-gotErrCh:
-				totalCount++
-				errorCount++
+				totalCount.Add(1)
 
 				// Expect one error for the unexpected event.
-				if errorCount > 1 {
-					t.Errorf("got %d errors, want 1: %v", errorCount, e)
+				if n := errorCount.Add(1); n > 1 {
+					t.Errorf("got %d errors, want 1: %v", n, e)
 				}
 
 			case <-testDone:
@@ -611,28 +616,23 @@ func TestSubscribe(t *testing.T) {
 	}()
 
 	// Send an unexpected event.
-	delEvent := eventtypes.ImageDelete{Name: "ghcr.io/distribution/distribution:v0.0.8"}
-	delAny, err := typeurl.MarshalAny(&delEvent)
+	unexpectedEvent := eventtypes.ContentDelete{Digest: "sha256:44cb2cf712c060f69df7310e99339c1eb51a085446f1bb6d44469acff35b4355"}
+	unexpectedAny, err := typeurl.MarshalAny(&unexpectedEvent)
 	require.NoError(t, err)
 	go func() {
 		es.EnvelopeChan <- &events.Envelope{
 			Timestamp: time.Time{},
 			Namespace: DefaultNamespace,
 			Topic:     "unexpected",
-			Event:     delAny,
+			Event:     unexpectedAny,
 		}
 	}()
 
-	for {
-		if totalCount >= 1 {
-			break
-		}
-		time.Sleep(10 * time.Millisecond)
-	}
+	require.Eventually(t, func() bool { return totalCount.Load() >= 1 }, 5*time.Second, 10*time.Millisecond)
 
-	require.Equal(t, 1, errorCount)
-	require.Equal(t, 0, eventsCount)
-	require.Equal(t, 1, totalCount)
+	require.Equal(t, int32(1), errorCount.Load())
+	require.Equal(t, int32(0), eventsCount.Load())
+	require.Equal(t, int32(1), totalCount.Load())
 
 	// Send an image create event.
 	createEvent := eventtypes.ImageCreate{Name: "ghcr.io/distribution/distribution:v0.0.8"}
@@ -647,14 +647,29 @@ func TestSubscribe(t *testing.T) {
 		}
 	}()
 
-	for {
-		if totalCount >= 2 {
-			break
+	require.Eventually(t, func() bool { return totalCount.Load() >= 2 }, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int32(1), errorCount.Load()) // no new error
+	require.Equal(t, int32(1), eventsCount.Load())
+	require.Equal(t, int32(2), totalCount.Load())
+
+	// Send an image delete event.
+	delEvent := eventtypes.ImageDelete{Name: "ghcr.io/distribution/distribution:v0.0.8"}
+	delAny, err := typeurl.MarshalAny(&delEvent)
+	require.NoError(t, err)
+	go func() {
+		es.EnvelopeChan <- &events.Envelope{
+			Timestamp: time.Time{},
+			Namespace: DefaultNamespace,
+			Topic:     "image-delete",
+			Event:     delAny,
 		}
-		time.Sleep(10 * time.Millisecond)
-	}
+	}()
+
+	require.Eventually(t, func() bool { return totalCount.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
 
-	require.Equal(t, 1, errorCount) // no new error
-	require.Equal(t, 1, eventsCount)
-	require.Equal(t, 2, totalCount)
+	require.Equal(t, int32(1), errorCount.Load()) // no new error
+	require.Equal(t, int32(1), eventsCount.Load())
+	require.Equal(t, int32(1), deleteCount.Load())
+	require.Equal(t, int32(3), totalCount.Load())
 }
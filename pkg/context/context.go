@@ -5,6 +5,7 @@ package context
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -31,6 +32,7 @@ const (
 	ReferenceCtxKey     = "reference"
 	RefTypeCtxKey       = "ref_type"
 	LoggerCtxKey        = "logger"
+	PeerIdCtxKey        = "peer_id"
 )
 
 // Request headers.
@@ -70,7 +72,12 @@ func (c Context) Copy() Context {
 }
 
 // IsRequestFromAPeer indicates if the current request is from a peer.
+// If the peer ID was filled, only requests with a verified peer ID are from a peer, and the header is ignored.
 func IsRequestFromAPeer(c Context) bool {
+	if pid, ok := c.Get(PeerIdCtxKey); ok {
+		return pid.(string) != ""
+	}
+
 	return c.Request.Header.Get(P2PHeaderKey) == "true"
 }
 
@@ -90,6 +97,24 @@ func SetOutboundHeaders(r *http.Request, c Context) {
 	r.Header.Set(NodeHeaderKey, NodeName)
 }
 
+// peerHeaders are the headers of a client request that are forwarded to peers. Other headers, such as the credentials
+// of the client for origin, are never sent to peers.
+var peerHeaders = []string{"Accept", "Range", "User-Agent"}
+
+// SetPeerHeaders replaces the headers of a request to a peer with the headers of the client request that can be shared
+// with peers, and the mandatory outbound headers.
+func SetPeerHeaders(r *http.Request, c Context) {
+	h := http.Header{}
+	for _, key := range peerHeaders {
+		if vals := c.Request.Header.Values(key); len(vals) > 0 {
+			h[key] = append([]string(nil), vals...)
+		}
+	}
+
+	r.Header = h
+	SetOutboundHeaders(r, c)
+}
+
 // Logger gets the logger with request specific fields.
 func Logger(c Context) zerolog.Logger {
 	var l zerolog.Logger
@@ -110,6 +135,23 @@ func BlobUrl(c Context) string {
 	return strings.TrimPrefix(c.Param("url"), "/") + "?" + c.Request.URL.RawQuery
 }
 
+// BodyWriter returns the writer that the body of a response written with w can be copied to, so that files copied with
+// io.Copy are sent with sendfile. Writers that implement io.ReaderFrom themselves are returned as is. gin's writer does
+// not, so its headers are written and the writer of net/http under it is returned.
+func BodyWriter(w gin.ResponseWriter) io.Writer {
+	if _, ok := w.(io.ReaderFrom); ok {
+		return w
+	}
+
+	u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+	if !ok {
+		return w
+	}
+
+	w.WriteHeaderNow()
+	return u.Unwrap()
+}
+
 // RangeStartIndex returns the start index of a byte range specified in the given range header value.
 // It expects the range value to be in the format "bytes=startIndex-endIndex".
 func RangeStartIndex(rangeValue string) (int64, error) {
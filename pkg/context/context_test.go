@@ -73,6 +73,49 @@ func TestSetOutboundHeaders(t *testing.T) {
 	}
 }
 
+func TestSetPeerHeaders(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Accept", "application/octet-stream")
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+
+	pc := FromContext(ctx)
+	FillCorrelationId(pc)
+
+	peerReq, err := http.NewRequest("GET", "http://peer/p2p/v1/chunks/sha256:1234/0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerReq.Header.Set("Authorization", "Bearer secret")
+
+	SetPeerHeaders(peerReq, pc)
+
+	for _, key := range []string{"Authorization", "Cookie"} {
+		if v := peerReq.Header.Get(key); v != "" {
+			t.Errorf("expected %v to not be sent to peers, got: %v", key, v)
+		}
+	}
+
+	if peerReq.Header.Get("Accept") != "application/octet-stream" {
+		t.Errorf("expected: %v, got: %v", "application/octet-stream", peerReq.Header.Get("Accept"))
+	}
+
+	if peerReq.Header.Get(P2PHeaderKey) != "true" {
+		t.Errorf("expected: %v, got: %v", "true", peerReq.Header.Get(P2PHeaderKey))
+	}
+
+	// The client request is not changed.
+	if req.Header.Get("Authorization") == "" {
+		t.Error("expected client request to keep its credentials")
+	}
+}
+
 func TestBlobUrl(t *testing.T) {
 	// Create a new request with a URL that has a query string.
 	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
@@ -153,6 +196,30 @@ func TestIsRequestFromPeer(t *testing.T) {
 	}
 }
 
+func TestIsRequestFromAPeerWithPeerId(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://127.0.0.1:5001/blobs/fdsfsdsd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(P2PHeaderKey, "true")
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+
+	pc := FromContext(ctx)
+
+	// Once the peer ID is filled, the header is ignored.
+	pc.Set(PeerIdCtxKey, "")
+	if IsRequestFromAPeer(pc) {
+		t.Fatal("expected request without a peer ID to not be from a peer")
+	}
+
+	pc.Set(PeerIdCtxKey, "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo")
+	if !IsRequestFromAPeer(pc) {
+		t.Error("expected request with a peer ID to be from a peer")
+	}
+}
+
 func TestRangeStartIndex(t *testing.T) {
 	for _, tc := range []struct {
 		name          string
@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package manifest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/opencontainers/go-digest"
+)
+
+// HeaderKey is the response header that carries the manifest entry of the served chunk.
+const HeaderKey = "X-MS-Peerd-Chunk-Manifest"
+
+var (
+	// ErrInvalidEntry indicates that a manifest entry is malformed, not signed by its author, or for other content.
+	ErrInvalidEntry = errors.New("invalid chunk manifest entry")
+
+	// ErrDigestMismatch indicates that the data of a chunk does not match its manifest entry.
+	ErrDigestMismatch = errors.New("chunk digest mismatch")
+)
+
+// Entry is a signed digest of a chunk of a blob.
+// It is created by the node that fetched the chunk from origin, and relayed by every peer that serves the chunk, so that
+// the data can be verified no matter which peer it comes from. A node that verified the whole blob signs its own entries
+// for the chunks it got from peers. Any peer can sign an entry, so a valid entry does not make a chunk trusted.
+type Entry struct {
+	// Blob is the digest of the blob.
+	Blob digest.Digest `json:"blob"`
+
+	// Offset is the offset of the chunk in the blob.
+	Offset int64 `json:"offset"`
+
+	// Size is the size of the chunk.
+	Size int64 `json:"size"`
+
+	// Digest is the digest of the chunk.
+	Digest digest.Digest `json:"digest"`
+
+	// Author is the peer that fetched the chunk from origin, or verified it against the digest of the blob.
+	Author peer.ID `json:"author"`
+
+	// Signature is the signature of the entry by the author.
+	Signature []byte `json:"signature"`
+
+	// Trusted is set by this host when it accepts the entry from a peer that is not its author and that it trusts.
+	// It is not signed, sent or persisted.
+	Trusted bool `json:"-"`
+}
+
+// NewEntry creates the entry of the given chunk of a blob, signed with the given key.
+func NewEntry(key crypto.PrivKey, blob digest.Digest, offset int64, data []byte) (*Entry, error) {
+	author, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		Blob:   blob,
+		Offset: offset,
+		Size:   int64(len(data)),
+		Digest: digest.FromBytes(data),
+		Author: author,
+	}
+
+	if e.Signature, err = key.Sign(e.payload()); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Verify checks that the entry is signed by its author and describes the given chunk of a blob.
+// It returns ErrInvalidEntry if the entry cannot be trusted, or ErrDigestMismatch if the data is corrupt.
+func (e *Entry) Verify(blob digest.Digest, offset int64, data []byte) error {
+	pub, err := e.Author.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+
+	if ok, err := pub.Verify(e.payload(), e.Signature); err != nil || !ok {
+		return fmt.Errorf("%w: bad signature by %s", ErrInvalidEntry, e.Author)
+	}
+
+	if e.Blob != blob || e.Offset != offset || e.Size != int64(len(data)) {
+		return fmt.Errorf("%w: entry is for %s at %d of size %d", ErrInvalidEntry, e.Blob, e.Offset, e.Size)
+	}
+
+	if err := e.Digest.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+
+	if e.Digest.Algorithm().FromBytes(data) != e.Digest {
+		return ErrDigestMismatch
+	}
+
+	return nil
+}
+
+// Encode encodes the entry as the value of HeaderKey.
+func (e *Entry) Encode() (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode decodes an entry from the value of HeaderKey.
+func Decode(s string) (*Entry, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+
+	e := &Entry{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+
+	return e, nil
+}
+
+// payload returns the signed content of the entry.
+func (e *Entry) payload() []byte {
+	return []byte(fmt.Sprintf("peerd-chunk-manifest\n%s\n%d\n%d\n%s", e.Blob, e.Offset, e.Size, e.Digest))
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/opencontainers/go-digest"
+)
+
+var blob = digest.FromString("blob")
+
+func newKey(t *testing.T) crypto.PrivKey {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEntryVerify(t *testing.T) {
+	data := []byte("chunk data")
+	e, err := NewEntry(newKey(t), blob, 1024, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Verify(blob, 1024, data); err != nil {
+		t.Errorf("expected entry to verify, got %v", err)
+	}
+
+	if err := e.Verify(blob, 1024, []byte("chunk dat4")); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("expected %v, got %v", ErrDigestMismatch, err)
+	}
+
+	if err := e.Verify(blob, 0, data); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected %v for another offset, got %v", ErrInvalidEntry, err)
+	}
+
+	if err := e.Verify(digest.FromString("other"), 1024, data); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected %v for another blob, got %v", ErrInvalidEntry, err)
+	}
+
+	// An entry changed by someone other than its author is rejected, even if it matches the data.
+	forged := *e
+	forged.Digest = digest.FromBytes([]byte("forged"))
+	if err := forged.Verify(blob, 1024, []byte("forged")); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected %v for a forged entry, got %v", ErrInvalidEntry, err)
+	}
+
+	other, err := NewEntry(newKey(t), blob, 1024, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged = *e
+	forged.Author = other.Author
+	if err := forged.Verify(blob, 1024, data); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected %v for a forged author, got %v", ErrInvalidEntry, err)
+	}
+}
+
+func TestEntryEncode(t *testing.T) {
+	data := []byte("chunk data")
+	e, err := NewEntry(newKey(t), blob, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := e.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := got.Verify(blob, 0, data); err != nil {
+		t.Errorf("expected decoded entry to verify, got %v", err)
+	}
+
+	if _, err := Decode("not an entry"); !errors.Is(err, ErrInvalidEntry) {
+		t.Errorf("expected %v, got %v", ErrInvalidEntry, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	key := newKey(t)
+	s := NewStore()
+
+	for _, offset := range []int64{0, 10} {
+		e, err := NewEntry(key, blob, offset, []byte("chunk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Put(e)
+
+		if got, ok := s.Get(blob, offset); !ok || got != e {
+			t.Errorf("expected entry at %d", offset)
+		}
+	}
+
+	if s.Assembled(blob, 3) {
+		t.Error("expected blob with missing chunks to not be assembled")
+	}
+
+	if !s.Assembled(blob, 2) {
+		t.Error("expected blob to be assembled")
+	}
+
+	if s.Assembled(blob, 2) {
+		t.Error("expected blob to be reported as assembled only once")
+	}
+
+	if got := len(s.Entries(blob)); got != 2 {
+		t.Errorf("expected 2 entries, got %d", got)
+	}
+
+	s.Delete(blob, 10)
+	if _, ok := s.Get(blob, 10); ok {
+		t.Error("expected entry to be deleted")
+	}
+
+	e, err := NewEntry(key, blob, 10, []byte("chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(e)
+	if !s.Assembled(blob, 2) {
+		t.Error("expected blob to be assembled again")
+	}
+
+	s.Delete(blob, 0)
+	s.Delete(blob, 10)
+	if len(s.blobs) != 0 {
+		t.Errorf("expected blob without entries to be removed")
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	key := newKey(t)
+	path := t.TempDir()
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int64{0, 10, 20} {
+		e, err := NewEntry(key, blob, offset, []byte("chunk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Delete(blob, 10)
+	s.DeleteFunc(func(e *Entry) bool { return e.Offset == 20 })
+
+	// A file that does not hold the entry it is named after.
+	if err := os.WriteFile(filepath.Join(path, blob.String(), "30"), []byte("not an entry"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store is opened again, as after a restart.
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := s.Get(blob, 0)
+	if !ok {
+		t.Fatal("expected entry to be loaded")
+	}
+	if err := e.Verify(blob, 0, []byte("chunk")); err != nil {
+		t.Errorf("expected loaded entry to verify, got %v", err)
+	}
+
+	if got := len(s.Entries(blob)); got != 1 {
+		t.Errorf("expected 1 entry, got %d", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, blob.String(), "30")); !os.IsNotExist(err) {
+		t.Errorf("expected invalid entry file to be removed, got %v", err)
+	}
+}
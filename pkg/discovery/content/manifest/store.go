@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package manifest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Store holds the chunk manifests of blobs, which are the verified entries of their cached chunks.
+// A store opened from a directory also writes its entries there, one file per chunk, so that they survive restarts.
+type Store struct {
+	mx    sync.RWMutex
+	blobs map[digest.Digest]*blobManifest
+	path  string
+}
+
+// blobManifest is the chunk manifest of a blob.
+type blobManifest struct {
+	entries map[int64]*Entry
+
+	// assembled is set once all chunks of the blob have entries.
+	assembled bool
+}
+
+// NewStore creates a new store of chunk manifests.
+func NewStore() *Store {
+	return &Store{blobs: map[digest.Digest]*blobManifest{}}
+}
+
+// OpenStore opens the store of chunk manifests persisted in the given directory, and loads its entries.
+// Files that do not hold the entry of the chunk they are named after are removed.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	s := NewStore()
+	s.path = path
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if e, err := readEntry(p); err == nil && s.entryPath(e) == p {
+			s.put(e)
+			return nil
+		}
+
+		return os.Remove(p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Put adds the given verified entry to the manifest of its blob.
+// If the store was opened from a directory, the entry is also written there, and an error is returned if that fails.
+func (s *Store) Put(e *Entry) error {
+	s.mx.Lock()
+	s.put(e)
+	s.mx.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+
+	if err := e.Blob.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+
+	return writeEntry(s.entryPath(e), e)
+}
+
+// put adds the entry to the manifest of its blob.
+func (s *Store) put(e *Entry) {
+	m, ok := s.blobs[e.Blob]
+	if !ok {
+		m = &blobManifest{entries: map[int64]*Entry{}}
+		s.blobs[e.Blob] = m
+	}
+	m.entries[e.Offset] = e
+}
+
+// Get returns the entry of the chunk of the blob at the given offset.
+func (s *Store) Get(blob digest.Digest, offset int64) (*Entry, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	m, ok := s.blobs[blob]
+	if !ok {
+		return nil, false
+	}
+
+	e, ok := m.entries[offset]
+	return e, ok
+}
+
+// Entries returns the entries of the blob.
+func (s *Store) Entries(blob digest.Digest) []*Entry {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	m, ok := s.blobs[blob]
+	if !ok {
+		return nil
+	}
+
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Delete removes the entry of the chunk of the blob at the given offset, such as when the chunk is evicted.
+// An entry whose file cannot be removed is left behind on disk, until DeleteFunc removes it after a restart.
+func (s *Store) Delete(blob digest.Digest, offset int64) {
+	s.mx.Lock()
+	e := s.delete(blob, offset)
+	s.mx.Unlock()
+
+	if e != nil && s.path != "" {
+		p := s.entryPath(e)
+		_ = os.Remove(p)
+		// The directory of the blob is only removed once it is empty.
+		_ = os.Remove(filepath.Dir(p))
+	}
+}
+
+// DeleteFunc removes the entries for which del returns true, such as the entries of chunks that are no longer cached.
+func (s *Store) DeleteFunc(del func(e *Entry) bool) {
+	s.mx.RLock()
+	deleted := []*Entry{}
+	for _, m := range s.blobs {
+		for _, e := range m.entries {
+			if del(e) {
+				deleted = append(deleted, e)
+			}
+		}
+	}
+	s.mx.RUnlock()
+
+	for _, e := range deleted {
+		s.Delete(e.Blob, e.Offset)
+	}
+}
+
+// delete removes the entry of the chunk of the blob at the given offset and returns it, if any.
+func (s *Store) delete(blob digest.Digest, offset int64) *Entry {
+	m, ok := s.blobs[blob]
+	if !ok {
+		return nil
+	}
+
+	e := m.entries[offset]
+	delete(m.entries, offset)
+	m.assembled = false
+	if len(m.entries) == 0 {
+		delete(s.blobs, blob)
+	}
+	return e
+}
+
+// Assembled returns true the first time all the given number of chunks of the blob have entries.
+// It is used to verify a blob once, and is reset when an entry of the blob is deleted.
+func (s *Store) Assembled(blob digest.Digest, chunks int) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	m, ok := s.blobs[blob]
+	if !ok || m.assembled || len(m.entries) < chunks {
+		return false
+	}
+
+	m.assembled = true
+	return true
+}
+
+// entryPath returns the path of the file of the entry.
+func (s *Store) entryPath(e *Entry) string {
+	return filepath.Join(s.path, e.Blob.String(), strconv.FormatInt(e.Offset, 10))
+}
+
+// readEntry reads an entry from its file.
+func readEntry(path string) (*Entry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(string(b))
+}
+
+// writeEntry writes an entry to its file, replacing the previous one at once.
+func writeEntry(path string, e *Entry) error {
+	v, err := e.Encode()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(v); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -14,8 +15,18 @@ import (
 	"github.com/rs/zerolog"
 )
 
+const (
+	// reprovideInterval is the interval at which all images are advertised again, before their records expire.
+	reprovideInterval = routing.MaxRecordAge - time.Minute
+
+	// reprovideJitter is the maximum random reduction of the reprovide interval.
+	// It spreads the reprovides of nodes that started at the same time, such as after a rollout.
+	reprovideJitter = 5 * time.Minute
+)
+
 // Provide provides content on this host to peers on the network.
 // It listens for events from the containerd.Store and filesChan channel to trigger the advertisement.
+// Advertisements are withdrawn when images are deleted from the containerd.Store or files are evicted (evictedChan).
 // The function runs until the context is done or an error occurs.
 //
 // Parameters:
@@ -23,9 +34,10 @@ import (
 // - r: The routing.Router used for advertising files.
 // - containerdStore: The containerd.Store used for subscribing to events and advertising images.
 // - filesChan: The channel that provides the files to be advertised.
+// - evictedChan: The channel that provides the files to be withdrawn.
 //
 // Returns: None.
-func Provide(ctx context.Context, r routing.Router, containerdStore containerd.Store, filesChan <-chan string) {
+func Provide(ctx context.Context, r routing.Router, containerdStore containerd.Store, filesChan <-chan string, evictedChan <-chan string) {
 	l := zerolog.Ctx(ctx).With().Str("component", "state").Logger()
 	l.Debug().Msg("advertising start")
 	s := time.Now()
@@ -33,15 +45,15 @@ func Provide(ctx context.Context, r routing.Router, containerdStore containerd.S
 		l.Debug().Dur("duration", time.Since(s)).Msg("advertising stop")
 	}()
 
-	eventCh, errCh := containerdStore.Subscribe(ctx)
+	eventCh, deleteCh, errCh := containerdStore.Subscribe(ctx)
+
+	// images tracks the keys advertised for each image name, to withdraw them when the image is deleted.
+	images := map[string][]string{}
 
 	immediate := make(chan time.Time, 1)
 	immediate <- time.Now()
 
-	expirationTicker := time.NewTicker(routing.MaxRecordAge - time.Minute)
-	defer expirationTicker.Stop()
-
-	ticker := merge(immediate, expirationTicker.C)
+	ticker := merge(immediate, jitteredTicker(ctx, reprovideInterval, reprovideJitter))
 
 	for {
 		select {
@@ -51,7 +63,7 @@ func Provide(ctx context.Context, r routing.Router, containerdStore containerd.S
 
 		case <-ticker:
 			l.Info().Msg("scheduled advertisement")
-			err := provideAll(ctx, l, containerdStore, r)
+			err := provideAll(ctx, l, containerdStore, r, images)
 			if err != nil {
 				l.Error().Err(err).Msg("schedule: error advertising")
 				continue
@@ -59,11 +71,22 @@ func Provide(ctx context.Context, r routing.Router, containerdStore containerd.S
 
 		case ref := <-eventCh:
 			l.Debug().Str("image", ref.Name()).Str("digest", ref.Digest().String()).Msg("advertising image")
-			_, err := provideRef(ctx, l, containerdStore, r, ref)
+			keys, err := provideRef(ctx, l, containerdStore, r, ref)
 			if err != nil {
 				l.Error().Err(err).Msg("image: advertising error")
 				continue
 			}
+			images[ref.Name()] = keys
+
+		case name := <-deleteCh:
+			keys := orphanedKeys(images, name)
+			delete(images, name)
+			l.Debug().Str("image", name).Int("keys", len(keys)).Msg("withdrawing image")
+			err := r.Withdraw(ctx, keys)
+			if err != nil {
+				l.Error().Err(err).Str("image", name).Msg("image: withdrawing error")
+				continue
+			}
 
 		case blob := <-filesChan:
 			l.Debug().Str("blob", blob).Msg("advertising file")
@@ -73,6 +96,14 @@ func Provide(ctx context.Context, r routing.Router, containerdStore containerd.S
 				continue
 			}
 
+		case blob := <-evictedChan:
+			l.Debug().Str("blob", blob).Msg("withdrawing file")
+			err := r.Withdraw(ctx, []string{blob})
+			if err != nil {
+				l.Error().Err(err).Str("blob", blob).Msg("file: withdrawing error")
+				continue
+			}
+
 		case err := <-errCh:
 			l.Error().Err(err).Msg("channel error")
 			continue
@@ -81,8 +112,9 @@ func Provide(ctx context.Context, r routing.Router, containerdStore containerd.S
 }
 
 // provideAll provides all references in the containerd store using the provided logger and router.
+// The advertised keys of each image are recorded in images.
 // It returns an error if any error occurs during the advertisement process.
-func provideAll(ctx context.Context, l zerolog.Logger, containerdStore containerd.Store, router routing.Router) error {
+func provideAll(ctx context.Context, l zerolog.Logger, containerdStore containerd.Store, router routing.Router, images map[string][]string) error {
 	refs, err := containerdStore.List(ctx)
 	if err != nil {
 		return err
@@ -90,11 +122,12 @@ func provideAll(ctx context.Context, l zerolog.Logger, containerdStore container
 
 	errs := []error{}
 	for _, ref := range refs {
-		_, err := provideRef(ctx, l, containerdStore, router, ref)
+		keys, err := provideRef(ctx, l, containerdStore, router, ref)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
+		images[ref.Name()] = keys
 	}
 
 	return errors.Join(errs...)
@@ -102,8 +135,8 @@ func provideAll(ctx context.Context, l zerolog.Logger, containerdStore container
 
 // provideRef provides the given containerd reference by extracting its digest and tags,
 // retrieving additional digests from the containerd store, and advertising all the keys to the router.
-// It returns the number of keys advertised and any error encountered.
-func provideRef(ctx context.Context, l zerolog.Logger, containerdStore containerd.Store, router routing.Router, ref containerd.Reference) (int, error) {
+// It returns the keys advertised and any error encountered.
+func provideRef(ctx context.Context, l zerolog.Logger, containerdStore containerd.Store, router routing.Router, ref containerd.Reference) ([]string, error) {
 	keys := []string{}
 	keys = append(keys, ref.Digest().String())
 	if ref.Tag() != "" {
@@ -119,10 +152,61 @@ func provideRef(ctx context.Context, l zerolog.Logger, containerdStore container
 
 	err = router.Provide(ctx, keys)
 	if err != nil {
-		return 0, fmt.Errorf("could not advertise image %v: %w", ref, err)
+		return nil, fmt.Errorf("could not advertise image %v: %w", ref, err)
+	}
+
+	return keys, nil
+}
+
+// orphanedKeys returns the keys advertised for the given image that are not advertised for any other image.
+// Layers are often shared between images, and must remain advertised while any image still references them.
+func orphanedKeys(images map[string][]string, name string) []string {
+	inUse := map[string]struct{}{}
+	for n, keys := range images {
+		if n == name {
+			continue
+		}
+		for _, k := range keys {
+			inUse[k] = struct{}{}
+		}
+	}
+
+	orphaned := []string{}
+	for _, k := range images[name] {
+		if _, ok := inUse[k]; !ok {
+			orphaned = append(orphaned, k)
+		}
 	}
 
-	return len(keys), nil
+	return orphaned
+}
+
+// jitteredTicker returns a channel that receives the time after every interval, each reduced by a random jitter.
+// The channel is closed when the context is done.
+func jitteredTicker(ctx context.Context, interval, jitter time.Duration) <-chan time.Time {
+	c := make(chan time.Time)
+	go func() {
+		defer close(c)
+
+		t := time.NewTimer(interval - time.Duration(rand.Int63n(int64(jitter))))
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				select {
+				case c <- now:
+				case <-ctx.Done():
+					return
+				}
+				t.Reset(interval - time.Duration(rand.Int63n(int64(jitter))))
+			}
+		}
+	}()
+
+	return c
 }
 
 // Merge merges multiple input channels into a single output channel.
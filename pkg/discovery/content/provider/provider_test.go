@@ -41,7 +41,7 @@ func TestContainerdStoreAds(t *testing.T) {
 		cancel()
 	}()
 
-	Provide(ctx, router, containerdStore, make(<-chan string)) // TODO avtakkar: add tests for file chan
+	Provide(ctx, router, containerdStore, make(<-chan string), make(<-chan string)) // TODO avtakkar: add tests for file chan
 
 	for _, ref := range refs {
 		peers, ok := router.LookupKey(ref.Digest().String())
@@ -56,6 +56,62 @@ func TestContainerdStoreAds(t *testing.T) {
 	}
 }
 
+func TestEvictedFileWithdrawn(t *testing.T) {
+	containerdStore := containerd.NewMockContainerdStore(nil)
+	router := mocks.NewMockRouter(map[string][]string{})
+
+	filesChan := make(chan string)
+	evictedChan := make(chan string)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		Provide(ctx, router, containerdStore, filesChan, evictedChan)
+		close(done)
+	}()
+
+	filesChan <- "some-blob"
+	evictedChan <- "some-blob"
+	cancel()
+	<-done
+
+	_, ok := router.LookupKey("some-blob")
+	require.False(t, ok)
+	require.True(t, router.Withdrawn("some-blob"))
+}
+
+func TestOrphanedKeys(t *testing.T) {
+	images := map[string][]string{
+		"docker.io/library/ubuntu:latest": {"sha256:index", "sha256:shared", "sha256:ubuntu"},
+		"docker.io/library/ubuntu:22.04":  {"sha256:index", "sha256:shared"},
+		"docker.io/library/alpine:latest": {"sha256:alpine"},
+	}
+
+	require.Equal(t, []string{"sha256:ubuntu"}, orphanedKeys(images, "docker.io/library/ubuntu:latest"))
+	require.Equal(t, []string{"sha256:alpine"}, orphanedKeys(images, "docker.io/library/alpine:latest"))
+	require.Empty(t, orphanedKeys(images, "docker.io/library/unknown:latest"))
+}
+
+func TestJitteredTicker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	interval := 50 * time.Millisecond
+	jitter := 20 * time.Millisecond
+	ticker := jitteredTicker(ctx, interval, jitter)
+
+	last := time.Now()
+	for i := 0; i < 3; i++ {
+		now := <-ticker
+		elapsed := now.Sub(last)
+		require.GreaterOrEqual(t, elapsed, interval-jitter)
+		last = now
+	}
+
+	cancel()
+	for range ticker {
+	}
+}
+
 func TestMerge(t *testing.T) {
 
 	ch1 := make(chan string, 10)
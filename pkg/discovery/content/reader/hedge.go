@@ -0,0 +1,194 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// hedgeQuantile is the quantile of recent times to first byte after which a request is hedged.
+	hedgeQuantile = 0.95
+
+	// minHedgeDelay and maxHedgeDelay bound the time after which a request is hedged.
+	minHedgeDelay = 10 * time.Millisecond
+	maxHedgeDelay = 5 * time.Second
+
+	// defaultHedgeDelay is the time after which a request is hedged, until enough responses have been observed.
+	defaultHedgeDelay = 500 * time.Millisecond
+
+	// latencyWindow is the number of recent times to first byte used to compute the hedge delay.
+	latencyWindow = 256
+
+	// minLatencySamples is the number of responses needed to compute the hedge delay.
+	minLatencySamples = 16
+
+	// maxHedgedRequests is the maximum number of requests for the same range in flight at once.
+	maxHedgedRequests = 2
+)
+
+var (
+	// peerLatencies are the recent times to first byte of peers, shared by all readers.
+	peerLatencies = newLatencies()
+
+	// originLatencies are the recent times to first byte of origin, shared by all readers.
+	originLatencies = newLatencies()
+)
+
+// latencies tracks recent times to first byte, to hedge requests that take longer than most.
+type latencies struct {
+	mx      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// newLatencies creates a new latency tracker.
+func newLatencies() *latencies {
+	return &latencies{samples: make([]time.Duration, 0, latencyWindow)}
+}
+
+// record adds the time to first byte of a response.
+func (l *latencies) record(d time.Duration) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencyWindow
+}
+
+// hedgeDelay returns the time after which a request without a response should be hedged.
+// It is the hedgeQuantile of the recent times to first byte, or defaultHedgeDelay if there are too few.
+func (l *latencies) hedgeDelay() time.Duration {
+	l.mx.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mx.Unlock()
+
+	if len(sorted) < minLatencySamples {
+		return defaultHedgeDelay
+	}
+
+	slices.Sort(sorted)
+	d := sorted[int(float64(len(sorted)-1)*hedgeQuantile)]
+	return min(max(d, minHedgeDelay), maxHedgeDelay)
+}
+
+// response is the result of a request sent by a hedged read.
+type response struct {
+	// id identifies the request among the requests for the same range.
+	id int
+
+	// peer is the peer the request was sent to, empty for origin.
+	peer routing.PeerInfo
+
+	resp  *http.Response
+	err   error
+	start time.Time
+}
+
+// send sends the request in the background with a context that is canceled by the returned function.
+// The response is delivered to responses as soon as its headers arrive, and only if its status is successful.
+// The context of a failed request is canceled before its error is delivered.
+func (r *reader) send(log zerolog.Logger, id int, peer routing.PeerInfo, req *http.Request, client *http.Client, responses chan<- response) context.CancelFunc {
+	ctx, cancel := context.WithCancel(r.context)
+	start := time.Now()
+
+	go func() {
+		log.Debug().Str("url", req.URL.String()).Str("range", req.Header.Get("Range")).Int("attempt", id).Msg("reader send start")
+
+		resp, err := client.Do(req.Clone(ctx))
+		if err != nil {
+			cancel()
+			err = Error{resp, err}
+		} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			cancel()
+			err = Error{resp, fmt.Errorf("unexpected response code: %d", resp.StatusCode)}
+		} else {
+			// The body is read after the other requests are canceled, so the context ends with it.
+			resp.Body = &cancelOnClose{resp.Body, cancel}
+		}
+
+		responses <- response{id: id, peer: peer, resp: resp, err: err, start: start}
+	}()
+
+	return cancel
+}
+
+// discard closes the responses of the given number of canceled requests as they arrive.
+func discard(responses <-chan response, count int) {
+	if count == 0 {
+		return
+	}
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if res := <-responses; res.err == nil {
+				res.resp.Body.Close()
+			}
+		}
+	}()
+}
+
+// doOrigin sends the request to origin, and if hedge is true, sends it again if origin does not respond within the hedge
+// delay. The first successful response is returned and the other request is canceled.
+func (r *reader) doOrigin(log zerolog.Logger, req *http.Request, hedge bool) (*http.Response, error) {
+	responses := make(chan response, maxHedgedRequests)
+	cancels := map[int]context.CancelFunc{0: r.send(log, 0, routing.PeerInfo{}, req, r.defaultHttpClient, responses)}
+
+	var hedgeDue <-chan time.Time
+	if hedge {
+		hedgeDue = time.After(r.originLatencies.hedgeDelay())
+	}
+	var err error
+	for len(cancels) > 0 {
+		select {
+
+		case <-hedgeDue:
+			log.Debug().Msg("origin is slow, hedging request")
+			cancels[1] = r.send(log, 1, routing.PeerInfo{}, req, r.defaultHttpClient, responses)
+
+		case res := <-responses:
+			delete(cancels, res.id)
+			if res.err != nil {
+				log.Error().Err(res.err).Int("attempt", res.id).Msg("reader origin error")
+				err = res.err
+				break
+			}
+
+			r.originLatencies.record(time.Since(res.start))
+			for _, cancel := range cancels {
+				cancel()
+			}
+			discard(responses, len(cancels))
+			return res.resp, nil
+		}
+	}
+
+	return nil, err
+}
+
+// cancelOnClose is a response body that cancels the context of its request when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/discovery/routing/mocks"
+	"github.com/rs/zerolog"
+)
+
+// newSlowServer creates a server that never responds, and records whether the request was canceled.
+func newSlowServer(canceled *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled.Store(true)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+}
+
+// waitFor waits for the condition to hold, or fails the test.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func TestLatenciesHedgeDelay(t *testing.T) {
+	l := newLatencies()
+	for i := 0; i < minLatencySamples-1; i++ {
+		l.record(time.Millisecond)
+	}
+
+	if d := l.hedgeDelay(); d != defaultHedgeDelay {
+		t.Errorf("expected default delay %v with too few samples, got %v", defaultHedgeDelay, d)
+	}
+
+	l = newLatencies()
+	for i := 1; i <= 100; i++ {
+		l.record(time.Duration(i) * 10 * time.Millisecond)
+	}
+
+	if d := l.hedgeDelay(); d != 950*time.Millisecond {
+		t.Errorf("expected p95 delay %v, got %v", 950*time.Millisecond, d)
+	}
+
+	// Old samples are replaced by new ones.
+	for i := 0; i < latencyWindow; i++ {
+		l.record(time.Hour)
+	}
+
+	if d := l.hedgeDelay(); d != maxHedgeDelay {
+		t.Errorf("expected max delay %v, got %v", maxHedgeDelay, d)
+	}
+
+	for i := 0; i < latencyWindow; i++ {
+		l.record(time.Microsecond)
+	}
+
+	if d := l.hedgeDelay(); d != minHedgeDelay {
+		t.Errorf("expected min delay %v, got %v", minHedgeDelay, d)
+	}
+}
+
+func TestP2pHedgesSlowPeer(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+	entry := signedEntry(t, 0, []byte(expected))
+
+	var canceled atomic.Bool
+	slow := newSlowServer(&canceled)
+	defer slow.Close()
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(manifest.HeaderKey, entry)
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer svr.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {slow.URL, svr.URL}})
+	r := newOriginReader(t, router, key, "http://127.0.0.1:1")
+	r.peerLatencies = newLatencies()
+	for i := 0; i < minLatencySamples; i++ {
+		r.peerLatencies.record(20 * time.Millisecond)
+	}
+
+	b := make([]byte, 10)
+	s := time.Now()
+	if _, err := r.doP2p(r.context, zerolog.Nop(), key, 0, 9, operationPreadRemote, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(s); d > time.Second {
+		t.Errorf("expected slow peer to be hedged, took %v", d)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	waitFor(t, canceled.Load)
+
+	// The slow peer lost the race but did not fail.
+	if invalidated := router.Invalidated(key); len(invalidated) != 0 {
+		t.Errorf("expected slow peer to not be invalidated, got %v", invalidated)
+	}
+}
+
+func TestPreadRemoteHedgesSlowPart(t *testing.T) {
+	key := "somekey"
+	data := make([]byte, 1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	entry := signedEntry(t, 0, data)
+
+	// The first request for the first part is not answered, whichever peer it is sent to.
+	var firstPart atomic.Int32
+	var canceled atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == fmt.Sprintf("bytes=0-%d", defaultPartSize-1) && firstPart.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				canceled.Store(true)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		w.Header().Set(manifest.HeaderKey, entry)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+
+	peers := []string{}
+	for i := 0; i < 2; i++ {
+		svr := httptest.NewServer(handler)
+		defer svr.Close()
+		peers = append(peers, svr.URL)
+	}
+
+	router := mocks.NewMockRouter(map[string][]string{key: peers})
+	r := newOriginReader(t, router, key, "http://127.0.0.1:1")
+	r.peerLatencies = newLatencies()
+	for i := 0; i < minLatencySamples; i++ {
+		r.peerLatencies.record(20 * time.Millisecond)
+	}
+
+	// A full chunk is read in parts, and the slow part is requested from the other peer.
+	b := make([]byte, len(data))
+	s := time.Now()
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(s); d > 2*time.Second {
+		t.Errorf("expected slow part to be hedged, took %v", d)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Error("expected parts to be reassembled in order")
+	}
+
+	if n := firstPart.Load(); n != 2 {
+		t.Errorf("expected 2 requests for the slow part, got %v", n)
+	}
+
+	waitFor(t, canceled.Load)
+
+	// The slow peer lost the race but did not fail.
+	if invalidated := router.Invalidated(key); len(invalidated) != 0 {
+		t.Errorf("expected slow peer to not be invalidated, got %v", invalidated)
+	}
+}
+
+func TestPreadRemoteHedgesSlowOrigin(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+
+	var requests atomic.Int32
+	var canceled atomic.Bool
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				canceled.Store(true)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer origin.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {}})
+	r := newOriginReader(t, router, key, origin.URL)
+	r.originLatencies = newLatencies()
+	for i := 0; i < minLatencySamples; i++ {
+		r.originLatencies.record(20 * time.Millisecond)
+	}
+
+	b := make([]byte, 10)
+	s := time.Now()
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(s); d > 2*time.Second {
+		t.Errorf("expected slow origin request to be hedged, took %v", d)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 requests to origin, got %v", n)
+	}
+
+	waitFor(t, canceled.Load)
+}
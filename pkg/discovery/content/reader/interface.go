@@ -13,8 +13,9 @@ type Reader interface {
 	// PreadRemote is like pread but to a remote file.
 	PreadRemote(buf []byte, offset int64) (int, error)
 
-	// FstatRemote stats a remote file.
-	FstatRemote() (int64, error)
+	// FstatRemote stats a remote file. It returns true if the size can be trusted, which is not the case for a size sent
+	// by a peer until the blob is verified.
+	FstatRemote() (int64, bool, error)
 
 	// Log returns the logger with context for this reader.
 	Log() *zerolog.Logger
@@ -25,3 +26,8 @@ type Error struct {
 	*http.Response
 	error
 }
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error {
+	return e.error
+}
@@ -16,8 +16,8 @@ type mockReader struct {
 var _ reader.Reader = &mockReader{}
 
 // FstatRemote implements remote.Reader.
-func (m *mockReader) FstatRemote() (int64, error) {
-	return int64(len(m.data)), nil
+func (m *mockReader) FstatRemote() (int64, bool, error) {
+	return int64(len(m.data)), true, nil
 }
 
 // Log implements remote.Reader.
@@ -0,0 +1,291 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+// unhealthyOriginResolveTimeout is the timeout for resolving a key while the origin is unhealthy, so that peers are
+// given more time before giving up on them.
+const unhealthyOriginResolveTimeout = 1 * time.Second
+
+// ErrOriginUnavailable indicates that requests to origin are not sent, because it failed too often recently.
+var ErrOriginUnavailable = errors.New("origin unavailable, circuit breaker open")
+
+// OriginConfig is the configuration of requests to origin.
+type OriginConfig struct {
+	// Retries is the number of times a request that failed transiently is retried.
+	Retries int
+
+	// Backoff is the delay before the first retry, doubled for every further retry.
+	Backoff time.Duration
+
+	// MaxBackoff is the longest delay before a retry. A request is not retried if origin asks to wait longer.
+	MaxBackoff time.Duration
+
+	// BreakerThreshold is the number of failures in a row after which requests to an origin host are stopped.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long requests to an origin host are stopped before one is allowed through again.
+	BreakerCooldown time.Duration
+}
+
+// DefaultOriginConfig is the default configuration of requests to origin.
+var DefaultOriginConfig = OriginConfig{
+	Retries:          3,
+	Backoff:          100 * time.Millisecond,
+	MaxBackoff:       5 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// breakerState is the state of the circuit breaker of an origin host, reported in metrics.
+type breakerState int
+
+const (
+	// breakerClosed means that requests are sent.
+	breakerClosed = breakerState(iota)
+
+	// breakerHalfOpen means that a single request is sent to probe whether the origin has recovered.
+	breakerHalfOpen
+
+	// breakerOpen means that requests are not sent.
+	breakerOpen
+)
+
+// breaker is the circuit breaker of an origin host.
+type breaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Origins tracks the health of origin hosts, and is shared by the readers of a store.
+type Origins struct {
+	config          OriginConfig
+	metricsRecorder metrics.Metrics
+
+	mx       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewOrigins creates a new tracker of origin hosts.
+func NewOrigins(config OriginConfig, metricsRecorder metrics.Metrics) *Origins {
+	return &Origins{
+		config:          config,
+		metricsRecorder: metricsRecorder,
+		breakers:        map[string]*breaker{},
+	}
+}
+
+// allow returns true if a request may be sent to the host.
+// Once the cooldown of an open breaker has passed, a single request is allowed through to probe the host.
+func (o *Origins) allow(host string) bool {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	b, ok := o.breakers[host]
+	if !ok {
+		return true
+	}
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < o.config.BreakerCooldown {
+			return false
+		}
+		o.set(host, b, breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// healthy returns true if the breaker of the host is closed.
+func (o *Origins) healthy(host string) bool {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	b, ok := o.breakers[host]
+	return !ok || b.state == breakerClosed
+}
+
+// success records that the host responded, which closes its breaker.
+func (o *Origins) success(host string) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	b, ok := o.breakers[host]
+	if !ok {
+		return
+	}
+
+	b.failures, b.probing = 0, false
+	o.set(host, b, breakerClosed)
+}
+
+// failure records that a request to the host failed transiently, and opens its breaker after too many in a row or if
+// the request was a probe.
+func (o *Origins) failure(host string) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	b, ok := o.breakers[host]
+	if !ok {
+		b = &breaker{}
+		o.breakers[host] = b
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= o.config.BreakerThreshold {
+		b.openedAt, b.probing = time.Now(), false
+		o.set(host, b, breakerOpen)
+	}
+}
+
+// release records that a request to the host was abandoned, so that another may probe it.
+func (o *Origins) release(host string) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	if b, ok := o.breakers[host]; ok {
+		b.probing = false
+	}
+}
+
+// set changes the state of the breaker of the host and reports it.
+func (o *Origins) set(host string, b *breaker, state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	o.metricsRecorder.RecordUpstreamBreakerState(host, int(state))
+}
+
+// fromOrigin sends the request to origin and reads the response with read.
+// Transient failures are retried with exponential backoff, waiting as long as origin asks with Retry-After, and are
+// counted by the circuit breaker of the origin host. ErrOriginUnavailable is returned while the breaker is open.
+func (r *reader) fromOrigin(log zerolog.Logger, req *http.Request, read func(*http.Response) error) error {
+	host := req.URL.Host
+	if !r.origins.allow(host) {
+		log.Warn().Str("host", host).Msg("origin unhealthy, not sending request")
+		return ErrOriginUnavailable
+	}
+
+	backoff := r.origins.config.Backoff
+	for attempt := 0; ; attempt++ {
+		// Slow requests are only hedged while origin is healthy, and not when retrying, so that a failing or throttling
+		// origin is not sent twice the requests.
+		resp, err := r.doOrigin(log, req, attempt == 0 && r.origins.healthy(host))
+		if err == nil {
+			err = read(resp)
+			resp.Body.Close()
+		}
+
+		if errors.Is(err, context.Canceled) {
+			r.origins.release(host)
+			return err
+		}
+
+		if !isTransient(err) {
+			// The origin responded, even if the content is not available.
+			r.origins.success(host)
+			return err
+		}
+
+		r.origins.failure(host)
+		if attempt >= r.origins.config.Retries {
+			return err
+		}
+
+		delay := backoff
+		if d, ok := retryAfter(err); ok {
+			delay = d
+		}
+		if delay > r.origins.config.MaxBackoff {
+			log.Warn().Err(err).Dur("delay", delay).Msg("origin asked to retry too late, giving up")
+			return err
+		}
+
+		if !r.origins.allow(host) {
+			return err
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt+1).Dur("delay", delay).Msg("origin request failed, retrying")
+		select {
+		case <-time.After(delay):
+		case <-r.context.Done():
+			return err
+		}
+
+		backoff = min(2*backoff, r.origins.config.MaxBackoff)
+	}
+}
+
+// peerResolveTimeout returns the timeout for resolving a key, which is longer while the origin is unhealthy.
+func (r *reader) peerResolveTimeout() time.Duration {
+	u, err := url.Parse(r.context.GetString(pcontext.BlobUrlCtxKey))
+	if err != nil || r.origins.healthy(u.Host) {
+		return r.resolveTimeout
+	}
+
+	return max(r.resolveTimeout, unhealthyOriginResolveTimeout)
+}
+
+// isTransient returns true if the error is a failure of origin that may not happen again.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var e Error
+	if !errors.As(err, &e) || e.Response == nil {
+		// The request failed without a response, such as when the connection is reset.
+		return true
+	}
+
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the response in the error, if any.
+func retryAfter(err error) (time.Duration, bool) {
+	var e Error
+	if !errors.As(err, &e) || e.Response == nil {
+		return 0, false
+	}
+
+	v := e.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
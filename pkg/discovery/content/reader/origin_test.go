@@ -0,0 +1,217 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/pkg/discovery/routing/mocks"
+)
+
+// newRetryingReader creates a reader of the chunk with the given key from origin, which retries quickly.
+func newRetryingReader(t *testing.T, key, origin string, config OriginConfig) *reader {
+	router := mocks.NewMockRouter(map[string][]string{key: {}})
+	r := newOriginReader(t, router, key, origin)
+	r.origins = NewOrigins(config, mr)
+	r.originLatencies = newLatencies()
+	return r
+}
+
+func TestPreadRemoteRetriesOrigin(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+
+	var requests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			// nolint:errcheck
+			w.Write([]byte(expected))
+		}
+	}))
+	defer origin.Close()
+
+	r := newRetryingReader(t, key, origin.URL, OriginConfig{Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Second, BreakerThreshold: 5, BreakerCooldown: time.Minute})
+	b := make([]byte, 10)
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("expected 3 requests to origin, got %v", n)
+	}
+
+	if !r.origins.healthy(strings.TrimPrefix(origin.URL, "http://")) {
+		t.Error("expected origin to be healthy after a success")
+	}
+}
+
+func TestPreadRemoteDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header string
+	}{
+		{name: "not found", status: http.StatusNotFound},
+		{name: "retry after too long", status: http.StatusServiceUnavailable, header: "120"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer origin.Close()
+
+			r := newRetryingReader(t, "somekey", origin.URL, OriginConfig{Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Second, BreakerThreshold: 5, BreakerCooldown: time.Minute})
+			if _, err := r.PreadRemote(make([]byte, 10), 0); err == nil {
+				t.Fatal("expected error")
+			}
+
+			if n := requests.Load(); n != 1 {
+				t.Errorf("expected 1 request to origin, got %v", n)
+			}
+		})
+	}
+}
+
+func TestPreadRemoteBreaker(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer origin.Close()
+
+	r := newRetryingReader(t, key, origin.URL, OriginConfig{Retries: 5, Backoff: time.Millisecond, MaxBackoff: time.Second, BreakerThreshold: 2, BreakerCooldown: 100 * time.Millisecond})
+	b := make([]byte, 10)
+
+	// The breaker opens after 2 failures, which stops the retries.
+	if _, err := r.PreadRemote(b, 0); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 requests to origin, got %v", n)
+	}
+
+	if d := r.peerResolveTimeout(); d != unhealthyOriginResolveTimeout {
+		t.Errorf("expected resolve timeout %v while origin is unhealthy, got %v", unhealthyOriginResolveTimeout, d)
+	}
+
+	if _, err := r.PreadRemote(b, 0); !errors.Is(err, ErrOriginUnavailable) {
+		t.Errorf("expected %v, got %v", ErrOriginUnavailable, err)
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected no request to origin while the breaker is open, got %v", n)
+	}
+
+	// Once the cooldown has passed, a probe closes the breaker.
+	time.Sleep(100 * time.Millisecond)
+	healthy.Store(true)
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	if d := r.peerResolveTimeout(); d != r.resolveTimeout {
+		t.Errorf("expected resolve timeout %v once origin is healthy, got %v", r.resolveTimeout, d)
+	}
+}
+
+func TestOriginsHalfOpen(t *testing.T) {
+	o := NewOrigins(OriginConfig{BreakerThreshold: 1, BreakerCooldown: 0}, mr)
+
+	o.failure("host")
+	if o.healthy("host") {
+		t.Fatal("expected breaker to be open")
+	}
+
+	// Only one probe is allowed at a time.
+	if !o.allow("host") {
+		t.Fatal("expected a probe to be allowed")
+	}
+
+	if o.allow("host") {
+		t.Error("expected a second probe to not be allowed")
+	}
+
+	// A failed probe opens the breaker again.
+	o.failure("host")
+	if !o.allow("host") {
+		t.Fatal("expected a probe to be allowed after the cooldown")
+	}
+
+	o.success("host")
+	if !o.healthy("host") || !o.allow("host") || !o.allow("host") {
+		t.Error("expected breaker to be closed")
+	}
+}
+
+func TestPreadRemoteDoesNotHedgeUnhealthyOrigin(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+
+	var requests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer origin.Close()
+
+	r := newRetryingReader(t, key, origin.URL, OriginConfig{Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Second, BreakerThreshold: 1, BreakerCooldown: 0})
+	for i := 0; i < minLatencySamples; i++ {
+		r.originLatencies.record(time.Millisecond)
+	}
+
+	// The breaker is open, and the cooldown has passed, so a single probe is sent.
+	r.origins.failure(strings.TrimPrefix(origin.URL, "http://"))
+
+	b := make([]byte, 10)
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected the probe to origin to not be hedged, got %v requests", n)
+	}
+}
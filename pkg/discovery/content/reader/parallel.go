@@ -0,0 +1,392 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// defaultPartSize is the size of the sub-ranges of a read that are fetched from peers in parallel.
+	defaultPartSize = 256 * 1024
+
+	// defaultStallTimeout is how long a peer may send no data for a sub-range before the sub-range is reassigned.
+	defaultStallTimeout = 5 * time.Second
+)
+
+// parts tracks the sub-ranges of a read that are fetched from peers in parallel.
+// Each worker fetches parts from one peer, failed parts are requeued for other workers. Once no parts are pending, idle
+// workers also fetch the parts whose peers have not responded within the hedge delay, and the first to finish wins.
+type parts struct {
+	mx   sync.Mutex
+	cond *sync.Cond
+
+	pending   []int
+	remaining int
+	aborted   bool
+
+	// hedgeDelay is how long a peer may not respond with a part before the part is also requested from another peer.
+	hedgeDelay time.Duration
+
+	// fetches are the requests in flight for each part.
+	fetches []fetches
+
+	// owners are the peers that served each part, and headers are their response headers.
+	// They are written when a part is completed, and read once all parts are done.
+	owners  []routing.PeerInfo
+	headers []http.Header
+}
+
+// fetches are the requests in flight for a part.
+type fetches struct {
+	count     int
+	since     time.Time
+	responded bool
+	done      bool
+	cancels   []context.CancelFunc
+}
+
+// newParts creates the parts of a read of size bytes, split into sub-ranges of partSize.
+func newParts(size, partSize int64, hedgeDelay time.Duration) (*parts, int) {
+	count := int((size + partSize - 1) / partSize)
+	p := &parts{
+		remaining:  count,
+		hedgeDelay: hedgeDelay,
+		fetches:    make([]fetches, count),
+		owners:     make([]routing.PeerInfo, count),
+		headers:    make([]http.Header, count),
+	}
+	p.cond = sync.NewCond(&p.mx)
+	for i := 0; i < count; i++ {
+		p.pending = append(p.pending, i)
+	}
+	return p, count
+}
+
+// next returns the next part to fetch, and registers cancel to stop the fetch if another worker completes the part.
+// It waits while parts are being fetched by other workers, since they may be requeued or become due for hedging.
+// It returns false when all parts are done or the read was aborted.
+func (p *parts) next(cancel context.CancelFunc) (int, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for !p.aborted && p.remaining > 0 {
+		if len(p.pending) > 0 {
+			i := p.pending[0]
+			p.pending = p.pending[1:]
+			p.fetches[i] = fetches{count: 1, since: time.Now(), cancels: []context.CancelFunc{cancel}}
+			return i, true
+		}
+
+		// Hedge the part that has been waiting for a response the longest, if it is due.
+		hedge, wait := -1, time.Duration(-1)
+		for i := range p.fetches {
+			f := &p.fetches[i]
+			if f.done || f.responded || f.count == 0 || f.count >= maxHedgedRequests {
+				continue
+			}
+
+			if d := p.hedgeDelay - time.Since(f.since); wait < 0 || d < wait {
+				hedge, wait = i, d
+			}
+		}
+
+		if hedge >= 0 && wait <= 0 {
+			f := &p.fetches[hedge]
+			f.count++
+			f.cancels = append(f.cancels, cancel)
+			return hedge, true
+		}
+
+		if hedge >= 0 {
+			t := time.AfterFunc(wait, func() {
+				p.mx.Lock()
+				defer p.mx.Unlock()
+				p.cond.Broadcast()
+			})
+			p.cond.Wait()
+			t.Stop()
+		} else {
+			p.cond.Wait()
+		}
+	}
+
+	return -1, false
+}
+
+// respond records that a peer responded with the given part, so that it is not hedged.
+func (p *parts) respond(i int) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.fetches[i].responded = true
+}
+
+// complete copies the data of a part fetched from the given peer into buf, unless another worker completed it first.
+// The other fetches of the part are canceled. It returns true if it was the last part.
+func (p *parts) complete(i int, buf, data []byte, peer routing.PeerInfo, header http.Header) bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	f := &p.fetches[i]
+	f.count--
+	if f.done {
+		return false
+	}
+
+	copy(buf, data)
+	p.owners[i], p.headers[i] = peer, header
+	f.done = true
+	for _, cancel := range f.cancels {
+		cancel()
+	}
+	f.cancels = nil
+
+	p.remaining--
+	p.cond.Broadcast()
+	return p.remaining == 0
+}
+
+// fail records that a fetch of the given part failed, and makes the part available to other workers if no other fetch
+// of it is in flight. It returns true if the part was completed by another worker, so the fetch lost rather than failed.
+func (p *parts) fail(i int) bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	f := &p.fetches[i]
+	f.count--
+	if f.done {
+		return true
+	}
+
+	if f.count == 0 {
+		f.responded = false
+		p.pending = append(p.pending, i)
+	}
+	p.cond.Broadcast()
+	return false
+}
+
+// abort stops all waiting workers.
+func (p *parts) abort() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.aborted = true
+	p.cond.Broadcast()
+}
+
+// doP2pParallel reads the given range into buf from several peers at once.
+// The range is split into sub-ranges, which are fetched by one worker per resolved peer and copied to buf.
+// If a peer fails or stalls, its sub-range is reassigned to the other peers, and if it is slow to respond, its sub-range
+// is also requested from an idle peer.
+// The assembled range is verified against the chunk manifest entries served with the sub-ranges, and a corruptError is
+// returned if it fails.
+func (r *reader) doP2pParallel(log zerolog.Logger, fileChunkKey string, start int64, buf []byte) (int64, error) {
+	if pcontext.IsRequestFromAPeer(r.context) {
+		log.Warn().Msg("refusing to propagate request from one peer to another")
+		return -1, errPeerNotFound
+	}
+
+	log.Debug().Msg(pcontext.PeerResolutionStartLog)
+	defer log.Debug().Msg(pcontext.PeerResolutionStopLog)
+
+	resolveCtx, cancel := context.WithTimeout(log.WithContext(r.context), r.peerResolveTimeout())
+	defer cancel()
+
+	startTime := time.Now()
+	peersCh, negCacheCallback, err := r.router.ResolveWithNegativeCacheCallback(resolveCtx, fileChunkKey, false, r.resolveRetries)
+	if err != nil {
+		log.Error().Err(err).Msg(pcontext.PeerRequestErrorLog)
+		return -1, err
+	}
+
+	p, count := newParts(int64(len(buf)), r.partSize, r.peerLatencies.hedgeDelay())
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+
+	resolveDone := resolveCtx.Done()
+	workers, peers := 0, 0
+readLoop:
+	for {
+		select {
+
+		case <-done:
+			break readLoop
+
+		case <-resolveDone:
+			// Keep reading from the peers found so far.
+			resolveDone, peersCh = nil, nil
+			if workers == 0 {
+				break readLoop
+			}
+
+		case peer, ok := <-peersCh:
+			if !ok {
+				resolveDone, peersCh = nil, nil
+				if workers == 0 {
+					break readLoop
+				}
+				break
+			}
+
+			if peers == 0 {
+				// Only report the time it took to discover the first peer.
+				r.metricsRecorder.RecordPeerDiscovery(peer.HttpHost, time.Since(startTime).Seconds())
+			}
+
+			peers++
+			workers++
+			go func() {
+				if r.fetchParts(log, fileChunkKey, peer, p, start, buf) {
+					close(done)
+				}
+				select {
+				case exited <- struct{}{}:
+				case <-finished:
+				}
+			}()
+
+		case <-exited:
+			workers--
+			if workers == 0 && peersCh == nil {
+				break readLoop
+			}
+		}
+	}
+
+	select {
+	case <-done:
+		log.Debug().Int("parts", count).Int("peers", peers).Msg("parallel read complete")
+		if err := r.verifyParts(log, start, buf, p); err != nil {
+			return -1, err
+		}
+		return int64(len(buf)), nil
+	default:
+	}
+
+	p.abort()
+	if peers == 0 {
+		negCacheCallback()
+		log.Info().Msg(pcontext.PeerNotFoundLog)
+	} else {
+		log.Info().Msg(pcontext.PeerResolutionExhaustedLog)
+	}
+	return -1, errPeerNotFound
+}
+
+// fetchParts fetches parts from the given peer until none are left or the peer fails.
+// It returns true if it completed the last part.
+func (r *reader) fetchParts(log zerolog.Logger, fileChunkKey string, peer routing.PeerInfo, p *parts, start int64, buf []byte) bool {
+	client := r.router.Net().HTTPClientFor(peer.ID)
+	data := make([]byte, r.partSize)
+	for {
+		ctx, cancel := context.WithCancel(r.context)
+		i, ok := p.next(cancel)
+		if !ok {
+			cancel()
+			return false
+		}
+
+		partStart := int64(i) * r.partSize
+		partEnd := min(partStart+r.partSize, int64(len(buf)))
+		part := data[:partEnd-partStart]
+
+		startTime := time.Now()
+		header, err := r.fetchPart(ctx, log, peer, client, start+partStart, part, func() { p.respond(i) })
+		cancel()
+		if err == nil {
+			r.router.RecordPeerResponse(peer.ID, time.Since(startTime), int64(len(part)), nil)
+			r.metricsRecorder.RecordPeerResponse(peer.HttpHost, fileChunkKey, "pread", time.Since(startTime).Seconds(), int64(len(part)))
+			if p.complete(i, buf[partStart:partEnd], part, peer, header) {
+				return true
+			}
+			continue
+		}
+
+		if p.fail(i) {
+			// Another peer completed the part first.
+			continue
+		}
+
+		if isPeerBusy(err) {
+			// The peer has no free upload slots, leave the part to other peers.
+			log.Debug().Str("peer", peer.HttpHost).Msg("peer busy, reassigning part")
+			return false
+		}
+
+		r.router.RecordPeerResponse(peer.ID, time.Since(startTime), int64(len(part)), err)
+		log.Error().Err(err).Str("peer", peer.HttpHost).Int("part", i).Msg(pcontext.PeerRequestErrorLog)
+		if isContentUnavailable(err) {
+			// The provider record of this peer is stale.
+			r.router.Forget(fileChunkKey, peer.ID)
+		} else {
+			r.router.Invalidate(fileChunkKey, peer.ID)
+		}
+		return false
+	}
+}
+
+// fetchPart reads the range starting at offset into buf from the given peer, and returns the response headers.
+// responded is called once the peer responds. The request is canceled if the peer sends no data for the stall timeout.
+func (r *reader) fetchPart(ctx context.Context, log zerolog.Logger, peer routing.PeerInfo, client *http.Client, offset int64, buf []byte, responded func()) (http.Header, error) {
+	req, err := r.peerRequest(peer.HttpHost, offset, offset+int64(len(buf))-1)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stall := time.AfterFunc(r.stallTimeout, cancel)
+	defer stall.Stop()
+
+	startTime := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, Error{resp, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, Error{resp, fmt.Errorf("unexpected response code: %d", resp.StatusCode)}
+	}
+
+	r.peerLatencies.record(time.Since(startTime))
+	responded()
+
+	_, err = io.ReadFull(&stallReader{resp.Body, stall, r.stallTimeout}, buf)
+	if err != nil {
+		log.Debug().Err(err).Str("peer", peer.HttpHost).Msg("peer stalled or failed")
+		return nil, Error{resp, err}
+	}
+
+	return resp.Header, nil
+}
+
+// stallReader is a reader that resets the stall timer whenever data is read.
+type stallReader struct {
+	io.Reader
+	stall   *time.Timer
+	timeout time.Duration
+}
+
+// Read implements io.Reader.
+func (s *stallReader) Read(b []byte) (int, error) {
+	n, err := s.Reader.Read(b)
+	if n > 0 {
+		s.stall.Reset(s.timeout)
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/discovery/routing/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// newPartsReader creates a reader for the given peers that fetches sub-ranges of partSize.
+func newPartsReader(t *testing.T, router *mocks.MockRouter, partSize int64) *reader {
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	c.Set(pcontext.DigestCtxKey, blob.String())
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
+	r.partSize = partSize
+	r.stallTimeout = 200 * time.Millisecond
+	r.peerLatencies = newLatencies()
+	return r
+}
+
+// newContentServer serves ranges of the given data with the given manifest entry, and counts the requests.
+func newContentServer(data []byte, entry string, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set(manifest.HeaderKey, entry)
+		time.Sleep(5 * time.Millisecond)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func TestParallelRead(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read a range that does not start at the beginning of the blob.
+	buf := make([]byte, 512*1024+100)
+	entry := signedEntry(t, 1000, data[1000:1000+len(buf)])
+
+	requests := make([]atomic.Int32, 3)
+	peers := []string{}
+	for i := range requests {
+		svr := newContentServer(data, entry, &requests[i])
+		defer svr.Close()
+		peers = append(peers, svr.URL)
+	}
+
+	key := "somekey"
+	r := newPartsReader(t, mocks.NewMockRouter(map[string][]string{key: peers}), 64*1024)
+
+	got, err := r.doP2pParallel(zerolog.Nop(), key, 1000, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != int64(len(buf)) {
+		t.Fatalf("expected %d bytes, got %d", len(buf), got)
+	}
+
+	if !bytes.Equal(buf, data[1000:1000+len(buf)]) {
+		t.Fatal("expected sub-ranges to be reassembled in order")
+	}
+
+	total := int32(0)
+	for i := range requests {
+		if requests[i].Load() == 0 {
+			t.Errorf("expected peer %d to serve a part", i)
+		}
+		total += requests[i].Load()
+	}
+
+	if total != 9 {
+		t.Errorf("expected 9 parts to be requested, got %d", total)
+	}
+}
+
+func TestParallelReadReassignsStalledPart(t *testing.T) {
+	data := make([]byte, 256*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-1/2")
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer stalled.Close()
+
+	var requests atomic.Int32
+	svr := newContentServer(data, signedEntry(t, 0, data), &requests)
+	defer svr.Close()
+
+	key := "somekey"
+	router := mocks.NewMockRouter(map[string][]string{key: {stalled.URL, svr.URL}})
+	r := newPartsReader(t, router, 64*1024)
+
+	buf := make([]byte, len(data))
+	if _, err := r.doP2pParallel(zerolog.Nop(), key, 0, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, data) {
+		t.Fatal("expected stalled part to be fetched from another peer")
+	}
+
+	if invalidated := router.Invalidated(key); len(invalidated) != 1 || string(invalidated[0]) != stalled.URL {
+		t.Errorf("expected stalled peer to be invalidated, got %v", invalidated)
+	}
+}
+
+func TestParallelReadBusyPeer(t *testing.T) {
+	data := make([]byte, 256*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+
+	var requests atomic.Int32
+	svr := newContentServer(data, signedEntry(t, 0, data), &requests)
+	defer svr.Close()
+
+	key := "somekey"
+	router := mocks.NewMockRouter(map[string][]string{key: {busy.URL, svr.URL}})
+	r := newPartsReader(t, router, 64*1024)
+
+	buf := make([]byte, len(data))
+	if _, err := r.doP2pParallel(zerolog.Nop(), key, 0, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, data) {
+		t.Fatal("expected parts to be fetched from the other peer")
+	}
+
+	if invalidated := router.Invalidated(key); len(invalidated) != 0 {
+		t.Errorf("expected busy peer to not be invalidated, got %v", invalidated)
+	}
+}
+
+func TestParallelReadFails(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	key := "somekey"
+	router := mocks.NewMockRouter(map[string][]string{key: {bad.URL, bad.URL}})
+	r := newPartsReader(t, router, 64*1024)
+
+	if _, err := r.doP2pParallel(zerolog.Nop(), key, 0, make([]byte, 256*1024)); err != errPeerNotFound {
+		t.Errorf("expected %v, got %v", errPeerNotFound, err)
+	}
+
+	if invalidated := router.Invalidated(key); len(invalidated) != 2 {
+		t.Errorf("expected failed peers to be invalidated, got %v", invalidated)
+	}
+
+	// Without any provider, the read fails once resolution times out.
+	r = newPartsReader(t, mocks.NewMockRouter(map[string][]string{}), 64*1024)
+	if _, err := r.doP2pParallel(zerolog.Nop(), key, 0, make([]byte, 256*1024)); err != errPeerNotFound {
+		t.Errorf("expected %v, got %v", errPeerNotFound, err)
+	}
+}
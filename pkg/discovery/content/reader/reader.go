@@ -13,6 +13,7 @@ import (
 	"time"
 
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
 	"github.com/azure/peerd/pkg/discovery/routing"
 	"github.com/azure/peerd/pkg/metrics"
 	"github.com/rs/zerolog"
@@ -33,9 +34,21 @@ type reader struct {
 	resolveTimeout time.Duration
 
 	router            routing.Router
+	manifests         *manifest.Store
+	origins           *Origins
 	resolveRetries    int
 	defaultHttpClient *http.Client
 
+	// partSize is the size of the sub-ranges of a read that are fetched from peers in parallel.
+	partSize int64
+
+	// stallTimeout is how long a peer may send no data before its sub-range is reassigned.
+	stallTimeout time.Duration
+
+	// peerLatencies and originLatencies are the recent times to first byte, used to hedge slow requests.
+	peerLatencies   *latencies
+	originLatencies *latencies
+
 	metricsRecorder metrics.Metrics
 }
 
@@ -55,11 +68,21 @@ func (r *reader) PreadRemote(buf []byte, offset int64) (int, error) {
 
 	log := r.Log().With().Str("operation", "preadremote").Str("key", key).Int64("start", start).Int64("end", end).Logger()
 
-	count, err := r.doP2p(log, key, start, end, operationPreadRemote, buf)
+	var count int64
+	var err error
+	if int64(len(buf)) > r.partSize {
+		count, err = r.doP2pParallel(log, key, start, buf)
+	} else {
+		count, err = r.doP2p(r.context, log, key, start, end, operationPreadRemote, buf)
+	}
 	if err == nil {
 		return int(count), nil
 	}
 
+	// If the data from peers failed verification, find out which peers served corrupt parts once origin responds.
+	var corrupt *corruptError
+	errors.As(err, &corrupt)
+
 	// Could not find a peer that has this file, request origin.
 	startTime := time.Now()
 	originReq, err := r.originRequest(start, end)
@@ -71,20 +94,97 @@ func (r *reader) PreadRemote(buf []byte, offset int64) (int, error) {
 	defer func() {
 		r.metricsRecorder.RecordUpstreamResponse(originReq.URL.Hostname(), key, "pread", time.Since(startTime).Seconds(), int64(count32))
 	}()
-	count32, err = r.preadRemote(log, originReq, r.defaultHttpClient, buf)
+
+	err = r.fromOrigin(log, originReq, func(resp *http.Response) (err error) {
+		count32, err = io.ReadFull(resp.Body, buf)
+		return err
+	})
+	if err == nil {
+		r.vouch(log, start, buf)
+		if corrupt != nil {
+			r.blame(log, key, corrupt, buf)
+		}
+	}
 	return count32, err
 }
 
 // FstatRemote stats a remote file.
-func (r *reader) FstatRemote() (int64, error) {
+// Peers that have the requested chunk are asked first, since they send the size of the file in Content-Range, so that
+// a file cached in the cluster can be opened without a request to origin. Peers get a head start of the usual time to
+// first byte of a peer, but at most half the resolve timeout, after which origin is asked too and its size is preferred.
+// The size is trusted only if it was sent by origin, since any peer can send any size.
+func (r *reader) FstatRemote() (int64, bool, error) {
 	key := r.context.GetString(pcontext.FileChunkCtxKey)
-	start := int64(0)
-	end := int64(0)
 
-	log := r.Log().With().Str("operation", "fstatremote").Int64("start", start).Int64("end", end).Str("key", key).Logger()
+	log := r.Log().With().Str("operation", "fstatremote").Str("key", key).Logger()
+
+	if pcontext.IsRequestFromAPeer(r.context) {
+		size, err := r.fstatOrigin(log, key)
+		return size, err == nil, err
+	}
+
+	// The requested range is in the chunk of the key, and requests without a range are for the first chunk.
+	offset, err := pcontext.RangeStartIndex(r.context.GetString(pcontext.BlobRangeCtxKey))
+	if err != nil {
+		offset = 0
+	}
+
+	type result struct {
+		size int64
+		err  error
+	}
+
+	// The peers still asked are canceled once the size is known, and not used after the request is done.
+	ctx, cancel := context.WithCancel(r.context)
+	fromPeers := make(chan result, 1)
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		size, err := r.doP2p(ctx, log, key, offset, offset, operationFstatRemote, nil)
+		if err == nil && size <= offset {
+			err = errPeerNotFound
+		}
+		fromPeers <- result{size, err}
+	}()
+
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	headStart := time.NewTimer(min(r.peerLatencies.hedgeDelay(), r.peerResolveTimeout()/2))
+	defer headStart.Stop()
+
+	select {
+
+	case res := <-fromPeers:
+		if res.err == nil {
+			return res.size, false, nil
+		}
+		log.Debug().Err(res.err).Int64("size", res.size).Msg("size not found from peers, requesting origin")
+
+		size, err := r.fstatOrigin(log, key)
+		return size, err == nil, err
 
+	case <-headStart.C:
+		log.Debug().Msg("peers are slow, requesting origin for size")
+
+		size, err := r.fstatOrigin(log, key)
+		if err == nil {
+			return size, true, nil
+		}
+
+		if res := <-fromPeers; res.err == nil {
+			return res.size, false, nil
+		}
+		return -1, false, err
+	}
+}
+
+// fstatOrigin requests the size of the file from origin.
+func (r *reader) fstatOrigin(log zerolog.Logger, key string) (int64, error) {
 	startTime := time.Now()
-	originReq, err := r.originRequest(start, end)
+	originReq, err := r.originRequest(0, 0)
 	if err != nil {
 		return -1, err
 	}
@@ -93,21 +193,40 @@ func (r *reader) FstatRemote() (int64, error) {
 	defer func() {
 		r.metricsRecorder.RecordUpstreamResponse(originReq.URL.Hostname(), key, "fstat", time.Since(startTime).Seconds(), count)
 	}()
-	count, err = r.fstatRemote(log, originReq, r.defaultHttpClient)
+
+	err = r.fromOrigin(log, originReq, func(resp *http.Response) error {
+		count = fstatResponse(resp)
+		return nil
+	})
 	return count, err
 }
 
+// attempt is a request for a range sent to a peer by doP2p.
+type attempt struct {
+	peer   routing.PeerInfo
+	cancel context.CancelFunc
+
+	// canceled is true if another request won the race, and the response of this one is to be discarded.
+	canceled bool
+}
+
 // doP2p tries to resolve the key in the p2p network and if successful, it will perform the operation on the peer, and return the result.
-func (r *reader) doP2p(log zerolog.Logger, fileChunkKey string, start, end int64, o operation, buf []byte) (int64, error) {
+// If a peer does not respond within the hedge delay, the request is also sent to the next peer, and the first to respond wins.
+// It gives up as soon as ctx is done.
+func (r *reader) doP2p(ctx context.Context, log zerolog.Logger, fileChunkKey string, start, end int64, o operation, buf []byte) (int64, error) {
 	if pcontext.IsRequestFromAPeer(r.context) {
 		log.Warn().Msg("refusing to propagate request from one peer to another")
 		return -1, errPeerNotFound
 	}
 
+	if o != operationFstatRemote && o != operationPreadRemote {
+		return -1, fmt.Errorf("unknown operation: %v", o)
+	}
+
 	log.Debug().Msg(pcontext.PeerResolutionStartLog)
 	defer log.Debug().Msg(pcontext.PeerResolutionStopLog)
 
-	resolveCtx, cancel := context.WithTimeout(log.WithContext(r.context), r.resolveTimeout)
+	resolveCtx, cancel := context.WithTimeout(log.WithContext(ctx), r.peerResolveTimeout())
 	defer cancel()
 
 	startTime := time.Now()
@@ -119,23 +238,71 @@ func (r *reader) doP2p(log zerolog.Logger, fileChunkKey string, start, end int64
 		return -1, err
 	}
 
+	responses := make(chan response)
+	attempts := map[int]*attempt{}
+	defer func() {
+		for _, a := range attempts {
+			a.cancel()
+		}
+		discard(responses, len(attempts))
+	}()
+
+	var pending []routing.PeerInfo
+	inflight, nextId := 0, 0
+
+	hedge := time.NewTimer(r.peerLatencies.hedgeDelay())
+	defer hedge.Stop()
+	hedgeDue := false
+
+	resolveDone := resolveCtx.Done()
+	timedOut := false
+
 	// Request a peer for this file.
 peerLoop:
 	for {
-		select {
+		// Send the request to the next peer if none is in flight, or if the ones in flight are slow.
+		for len(pending) > 0 && (inflight == 0 || (hedgeDue && inflight < maxHedgedRequests)) {
+			peer := pending[0]
+			pending = pending[1:]
+
+			peerReq, err := r.peerRequest(peer.HttpHost, start, end)
+			if err != nil {
+				log.Error().Err(err).Msg(pcontext.PeerRequestErrorLog)
+				// try next peer
+				continue
+			}
 
-		case <-resolveCtx.Done():
-			// Resolving mirror has timed out.
-			negCacheCallback()
-			log.Info().Msg(pcontext.PeerNotFoundLog)
+			if inflight > 0 {
+				log.Debug().Str("peer", peer.HttpHost).Msg("peer is slow, hedging request")
+			}
+
+			attempts[nextId] = &attempt{peer: peer, cancel: r.send(log, nextId, peer, peerReq, r.router.Net().HTTPClientFor(peer.ID), responses)}
+			nextId++
+			inflight++
+
+			hedgeDue = false
+			hedge.Reset(r.peerLatencies.hedgeDelay())
+		}
+
+		if inflight == 0 && len(pending) == 0 && peersCh == nil {
 			break peerLoop
+		}
+
+		select {
+
+		case <-ctx.Done():
+			return -1, ctx.Err()
+
+		case <-resolveDone:
+			// Resolving mirror has timed out, keep waiting for the peers found so far.
+			resolveDone, peersCh = nil, nil
+			timedOut = true
 
 		case peer, ok := <-peersCh:
 			// Channel closed means no more mirrors will be received and max retries has been reached.
 			if !ok {
-				negCacheCallback()
-				log.Info().Msg(pcontext.PeerResolutionExhaustedLog)
-				break peerLoop
+				resolveDone, peersCh = nil, nil
+				break
 			}
 
 			if peerCount == 0 {
@@ -144,121 +311,155 @@ peerLoop:
 				peerCount++
 			}
 
-			peerReq, err := r.peerRequest(peer.HttpHost, start, end)
-			if err != nil {
-				log.Error().Err(err).Msg(pcontext.PeerRequestErrorLog)
-				// try next peer
+			pending = append(pending, peer)
+
+		case <-hedge.C:
+			hedgeDue = true
+
+		case res := <-responses:
+			a := attempts[res.id]
+			delete(attempts, res.id)
+			if a.canceled {
+				if res.err == nil {
+					res.resp.Body.Close()
+				}
 				break
 			}
+			inflight--
 
-			client := r.router.Net().HTTPClientFor(peer.ID)
+			peer := res.peer
+			err := res.err
+			if isPeerBusy(err) {
+				// The peer has no free upload slots, try next peer right away.
+				log.Debug().Str("peer", peer.HttpHost).Msg("peer busy, attempting next")
+				break
+			}
 
 			var count int64
-			startTime = time.Now()
-			if o == operationFstatRemote {
-				count, err = r.fstatRemote(log, peerReq, client)
-			} else if o == operationPreadRemote {
-				var c int
-				c, err = r.preadRemote(log, peerReq, client, buf)
-				count = int64(c)
-			} else {
-				err = fmt.Errorf("unknown operation: %v", o)
+			if err == nil {
+				r.peerLatencies.record(time.Since(res.start))
+
+				// The first peer to respond wins, the others are canceled but may be asked again if it fails.
+				var losers []routing.PeerInfo
+				for _, other := range attempts {
+					if !other.canceled {
+						other.canceled = true
+						other.cancel()
+						losers = append(losers, other.peer)
+					}
+				}
+				pending = append(losers, pending...)
+				inflight = 0
+
+				count, err = r.readResponse(log, res.resp, peer, o, start, buf)
+			}
+
+			r.router.RecordPeerResponse(peer.ID, time.Since(res.start), count, err)
+			if errors.Is(err, manifest.ErrDigestMismatch) {
+				r.block(log, fileChunkKey, peer)
+				break
 			}
 
 			if err != nil {
 				// try next peer
-				log.Error().Err(err).Msg(pcontext.PeerRequestErrorLog)
+				log.Error().Err(err).Str("peer", peer.HttpHost).Msg(pcontext.PeerRequestErrorLog)
+				if isContentUnavailable(err) {
+					// The provider record of this peer is stale.
+					r.router.Forget(fileChunkKey, peer.ID)
+				} else {
+					r.router.Invalidate(fileChunkKey, peer.ID)
+				}
 			} else {
 				op := "fstat"
 				if o == operationPreadRemote {
 					op = "pread"
 				}
-				r.metricsRecorder.RecordPeerResponse(peer.HttpHost, fileChunkKey, op, time.Since(startTime).Seconds(), count)
+				r.metricsRecorder.RecordPeerResponse(peer.HttpHost, fileChunkKey, op, time.Since(res.start).Seconds(), count)
 				return count, nil
 			}
 		}
 	}
 
+	negCacheCallback()
+	if timedOut {
+		log.Info().Msg(pcontext.PeerNotFoundLog)
+	} else {
+		log.Info().Msg(pcontext.PeerResolutionExhaustedLog)
+	}
 	return -1, errPeerNotFound
 }
 
-// fstatRemote stats the file.
-func (r *reader) fstatRemote(log zerolog.Logger, req *http.Request, client *http.Client) (int64, error) {
-	log.Debug().Str("url", req.URL.String()).Str("range", req.Header.Get("Range")).Msg("reader fstatRemote start")
-	defer log.Debug().Msg("reader fstatRemote stop")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Error().Err(err).Msg("reader fstatRemote error")
-		return 0, Error{resp, err}
-	}
+// readResponse performs the operation with the successful response of the given peer, and closes its body.
+// Data read into buf is verified against the chunk manifest entry in the response headers.
+func (r *reader) readResponse(log zerolog.Logger, resp *http.Response, peer routing.PeerInfo, o operation, start int64, buf []byte) (int64, error) {
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
-		return resp.ContentLength, nil
+	if o == operationFstatRemote {
+		return fstatResponse(resp), nil
 	}
 
-	if resp.StatusCode == 206 {
-		l := resp.ContentLength
-		rs := resp.Header.Get("Content-Range")
-		if rs == "" {
-			return l, nil
-		}
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil {
+		return int64(n), err
+	}
 
-		pos := strings.LastIndexByte(rs, '/')
-		if pos < 0 {
-			return l, nil
-		}
+	return int64(n), r.verify(log, start, buf, resp.Header, []routing.PeerInfo{peer})
+}
 
-		l, _ = strconv.ParseInt(rs[pos+1:], 10, 64)
-		return l, nil
+// isContentUnavailable returns true if the error indicates that the remote does not have the content.
+func isContentUnavailable(err error) bool {
+	var e Error
+	if !errors.As(err, &e) || e.Response == nil {
+		return false
 	}
 
-	log.Error().Err(err).Int("status", resp.StatusCode).Msg("reader fstatRemote error")
-	return 0, Error{resp, fmt.Errorf("unexpected response code: %d", resp.StatusCode)}
+	return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
 }
 
-// preadRemote reads the file.
-func (r *reader) preadRemote(log zerolog.Logger, req *http.Request, client *http.Client, buf []byte) (int, error) {
-	log.Debug().Str("url", req.URL.String()).Str("range", req.Header.Get("Range")).Msg("reader preadRemote start")
-	statusCode := -1
-	s := time.Now()
-	defer func() {
-		log.Debug().Int("status", statusCode).Dur("duration", time.Since(s)).Msg("reader preadRemote stop")
-	}()
-
-	resp, err := client.Do(req)
-	if resp != nil {
-		statusCode = resp.StatusCode
-	}
-	if err != nil {
-		detailedErr := Error{resp, err}
-		log.Error().Err(detailedErr).Str("url", req.URL.String()).Str("range", req.Header.Get("Range")).Msg("reader preadRemote error")
-		return 0, detailedErr
+// isPeerBusy returns true if the error indicates that the remote has no capacity to serve the request.
+func isPeerBusy(err error) bool {
+	var e Error
+	if !errors.As(err, &e) || e.Response == nil {
+		return false
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 206 {
-		log.Error().Err(err).Int("status", resp.StatusCode).Msg("reader preadRemote error")
-		return 0, Error{resp, fmt.Errorf("unexpected response code: %d", resp.StatusCode)}
+	return e.StatusCode == http.StatusServiceUnavailable
+}
+
+// fstatResponse returns the size of the file from a successful response to a range request.
+func fstatResponse(resp *http.Response) int64 {
+	l := resp.ContentLength
+	if resp.StatusCode != http.StatusPartialContent {
+		return l
 	}
 
-	return io.ReadFull(resp.Body, buf)
-}
+	rs := resp.Header.Get("Content-Range")
+	pos := strings.LastIndexByte(rs, '/')
+	if pos < 0 {
+		return l
+	}
 
-// originRequest will create a new request to origin.
-func (r *reader) originRequest(start, end int64) (*http.Request, error) {
-	return r.remoteRequest(r.context.GetString(pcontext.BlobUrlCtxKey), start, end)
+	l, _ = strconv.ParseInt(rs[pos+1:], 10, 64)
+	return l
 }
 
-// perRequest will create a new request to a peer.
+// peerRequest will create a new request to a peer.
+// Peers are asked for the chunk by the digest of the blob only, and no credentials of the client are sent with it.
 func (r *reader) peerRequest(peer string, start, end int64) (*http.Request, error) {
-	return r.remoteRequest(fmt.Sprintf("%v/blobs/%v", peer, r.context.GetString(pcontext.BlobUrlCtxKey)), start, end)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/p2p/v1/chunks/%v/%d", peer, r.blob(), start), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pcontext.SetPeerHeaders(req, r.context)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	return req, nil
 }
 
-// remoteRequest creates a new HTTP request to a remote server.
-func (r *reader) remoteRequest(u string, start, end int64) (*http.Request, error) {
-	req, err := http.NewRequest("GET", u, nil)
+// originRequest will create a new request to origin, with the headers of the client.
+func (r *reader) originRequest(start, end int64) (*http.Request, error) {
+	req, err := http.NewRequest("GET", r.context.GetString(pcontext.BlobUrlCtxKey), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -276,13 +477,22 @@ func (r *reader) remoteRequest(u string, start, end int64) (*http.Request, error
 }
 
 // NewReader creates a new remote reader.
-func NewReader(c pcontext.Context, router routing.Router, resolveRetries int, resolveTimeout time.Duration, metricsRecorder metrics.Metrics) Reader {
+// Data read from peers is verified against the chunk manifest entry it is served with, and the entries of verified
+// data and data read from origin are added to the given manifests. Requests to origin are retried and stopped according
+// to the health of origin hosts tracked by origins.
+func NewReader(c pcontext.Context, router routing.Router, manifests *manifest.Store, origins *Origins, resolveRetries int, resolveTimeout time.Duration, metricsRecorder metrics.Metrics) Reader {
 	return &reader{
 		context:           c.Copy(),
 		resolveTimeout:    resolveTimeout,
 		router:            router,
+		manifests:         manifests,
+		origins:           origins,
 		resolveRetries:    resolveRetries,
 		defaultHttpClient: router.Net().HTTPClientFor(""),
+		partSize:          defaultPartSize,
+		stallTimeout:      defaultStallTimeout,
+		peerLatencies:     peerLatencies,
+		originLatencies:   originLatencies,
 		metricsRecorder:   metricsRecorder,
 	}
 }
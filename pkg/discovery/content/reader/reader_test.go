@@ -3,15 +3,19 @@
 package reader
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/metrics"
 	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
@@ -21,8 +25,28 @@ var (
 	query       = "?se=2023-09-20T01%3A14%3A49Z&sig=m4Cr%2BYTZHZQlN5LznY7nrTQ4LCIx2OqnDDM3Dpedbhs%3D&sp=r&spr=https&sr=b&sv=2018-03-28&regid=01031d61e1024861afee5d512651eb9f"
 	u           = hostAndPath + query
 	mr          = metrics.NewPromMetrics(prometheus.DefaultRegisterer, "test", "test")
+	blob        = digest.Digest("sha256:d18c7a64c5158179bdee531a663c5b487de57ff17cff3af29a51c7e70b491d9d")
 )
 
+// signedEntry returns the encoded manifest entry of the given chunk of the blob, signed by a new identity.
+func signedEntry(t *testing.T, offset int64, data []byte) string {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := manifest.NewEntry(key, blob, offset, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := e.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
 func TestPreadRemoteUpstream(t *testing.T) {
 	// Setup
 	m := map[string][]string{}
@@ -77,7 +101,7 @@ func TestPreadRemoteUpstream(t *testing.T) {
 	pc.Set(pcontext.BlobRangeCtxKey, "bytes=0-10")
 	pc.Set(pcontext.FileChunkCtxKey, key)
 
-	r := NewReader(pc, router, 3, 500*time.Millisecond, mr).(*reader)
+	r := NewReader(pc, router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 	b := make([]byte, 10)
 
 	// Test
@@ -92,6 +116,10 @@ func TestPreadRemoteUpstream(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected[:10], string(b))
 	} else if peersTried != 3 {
 		t.Fatalf("expected %v, got %v", 3, peersTried)
+	} else if invalidated := router.Invalidated(key); len(invalidated) != 2 {
+		t.Fatalf("expected %v failed peers to be invalidated, got %v", 2, len(invalidated))
+	} else if forgotten := router.Forgotten(key); len(forgotten) != 1 {
+		t.Fatalf("expected %v peer without content to be forgotten, got %v", 1, len(forgotten))
 	}
 }
 
@@ -128,13 +156,21 @@ func TestFstatRemote(t *testing.T) {
 	pc.Set(pcontext.BlobUrlCtxKey, pcontext.BlobUrl(pc))
 	pc.Set(pcontext.BlobRangeCtxKey, "bytes=0-0")
 
-	r := NewReader(pc, router, 3, 500*time.Millisecond, mr).(*reader)
+	r := NewReader(pc, router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 
-	got, err := r.FstatRemote()
+	// No peer has the file, so origin is asked before the peer lookup times out.
+	startTime := time.Now()
+	got, trusted, err := r.FstatRemote()
 	if err != nil {
 		t.Fatal(err)
 	} else if got != int64(len(expected)) {
 		t.Fatalf("expected %v, got %v", len(expected), got)
+	} else if !trusted {
+		t.Errorf("expected size from origin to be trusted")
+	}
+
+	if elapsed := time.Since(startTime); elapsed >= 500*time.Millisecond {
+		t.Errorf("expected origin to be asked before the resolve timeout, took %v", elapsed)
 	}
 }
 
@@ -174,13 +210,15 @@ func TestFstatRemotePartialContent(t *testing.T) {
 	pc.Set(pcontext.BlobUrlCtxKey, pcontext.BlobUrl(pc))
 	pc.Set(pcontext.BlobRangeCtxKey, "bytes=0-0")
 
-	r := NewReader(pc, router, 3, 500*time.Millisecond, mr).(*reader)
+	r := NewReader(pc, router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 
-	got, err := r.FstatRemote()
+	got, trusted, err := r.FstatRemote()
 	if err != nil {
 		t.Fatal(err)
 	} else if got != int64(len(expected)) {
 		t.Fatalf("expected %v, got %v", len(expected), got)
+	} else if !trusted {
+		t.Errorf("expected size from origin to be trusted")
 	}
 }
 
@@ -189,8 +227,10 @@ func TestP2pRetries(t *testing.T) {
 	m := map[string][]string{}
 	key := "somekey"
 	expected := "expected-result"
+	entry := signedEntry(t, 0, []byte(expected[:10]))
 	svr3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(manifest.HeaderKey, entry)
 		// nolint:errcheck
 		w.Write([]byte(expected))
 	}))
@@ -214,10 +254,11 @@ func TestP2pRetries(t *testing.T) {
 	router := mocks.NewMockRouter(m)
 	c, _ := gin.CreateTestContext(httptest.NewRecorder())
 	c.Request = req
-	r := NewReader(pcontext.FromContext(c), router, 3, 500*time.Millisecond, mr).(*reader)
+	c.Set(pcontext.DigestCtxKey, blob.String())
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 	b := make([]byte, 10)
 
-	got, err := r.doP2p(l, key, 0, 10, operationPreadRemote, b)
+	got, err := r.doP2p(r.context, l, key, 0, 10, operationPreadRemote, b)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -229,13 +270,57 @@ func TestP2pRetries(t *testing.T) {
 	}
 }
 
+func TestP2pBusyPeer(t *testing.T) {
+	l := zerolog.Nop()
+	key := "somekey"
+	expected := "expected-result"
+	entry := signedEntry(t, 0, []byte(expected[:10]))
+	busySvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busySvr.Close()
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(manifest.HeaderKey, entry)
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer svr.Close()
+
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mocks.NewMockRouter(map[string][]string{key: {busySvr.URL, svr.URL}})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	c.Set(pcontext.DigestCtxKey, blob.String())
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
+	b := make([]byte, 10)
+
+	got, err := r.doP2p(r.context, l, key, 0, 10, operationPreadRemote, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != 10 {
+		t.Fatalf("expected %v, got %v", 10, got)
+	} else if invalidated := router.Invalidated(key); len(invalidated) != 0 {
+		t.Fatalf("expected busy peer to not be invalidated, got %v", invalidated)
+	}
+}
+
 func TestP2pSuccess(t *testing.T) {
 	l := zerolog.Nop()
 	m := map[string][]string{}
 	key := "somekey"
 	expected := "expected-result"
+	entry := signedEntry(t, 0, []byte(expected[:10]))
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(manifest.HeaderKey, entry)
 		// nolint:errcheck
 		w.Write([]byte(expected))
 	}))
@@ -251,10 +336,11 @@ func TestP2pSuccess(t *testing.T) {
 	router := mocks.NewMockRouter(m)
 	c, _ := gin.CreateTestContext(httptest.NewRecorder())
 	c.Request = req
-	r := NewReader(pcontext.FromContext(c), router, 3, 500*time.Millisecond, mr).(*reader)
+	c.Set(pcontext.DigestCtxKey, blob.String())
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 	b := make([]byte, 10)
 
-	got, err := r.doP2p(l, key, 0, 10, operationPreadRemote, b)
+	got, err := r.doP2p(r.context, l, key, 0, 10, operationPreadRemote, b)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -279,10 +365,10 @@ func TestP2pPeerNotFound(t *testing.T) {
 	c, _ := gin.CreateTestContext(httptest.NewRecorder())
 	c.Request = req
 
-	r := NewReader(pcontext.FromContext(c), router, 3, 500*time.Millisecond, mr).(*reader)
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 
 	b := make([]byte, 10)
-	_, err = r.doP2p(l, "key", 0, 10, operationPreadRemote, b)
+	_, err = r.doP2p(r.context, l, "key", 0, 10, operationPreadRemote, b)
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -309,10 +395,10 @@ func TestP2pNoInfiniteLoops(t *testing.T) {
 	c.Request = req
 	c.Request.Header.Add(pcontext.P2PHeaderKey, "true")
 
-	r := NewReader(pcontext.FromContext(c), router, 3, 500*time.Millisecond, mr).(*reader)
+	r := NewReader(pcontext.FromContext(c), router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
 
 	b := make([]byte, 10)
-	_, err = r.doP2p(l, key, 0, 10, operationPreadRemote, b)
+	_, err = r.doP2p(r.context, l, key, 0, 10, operationPreadRemote, b)
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -321,3 +407,67 @@ func TestP2pNoInfiniteLoops(t *testing.T) {
 		t.Fatalf("expected %v, got %v", errPeerNotFound, err)
 	}
 }
+
+func TestFstatRemoteFromPeer(t *testing.T) {
+	key := "somekey"
+	data := []byte("expected-result")
+
+	var ranges []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer svr.Close()
+
+	// Origin is not reachable.
+	router := mocks.NewMockRouter(map[string][]string{key: {svr.URL}})
+	r := newOriginReader(t, router, key, "http://127.0.0.1:1")
+	r.context.Set(pcontext.BlobRangeCtxKey, "bytes=5-9")
+
+	got, trusted, err := r.FstatRemote()
+	if err != nil {
+		t.Fatal(err)
+	} else if got != int64(len(data)) {
+		t.Fatalf("expected %v, got %v", len(data), got)
+	} else if trusted {
+		t.Errorf("expected size from a peer not to be trusted")
+	}
+
+	if len(ranges) != 1 || ranges[0] != "bytes=5-5" {
+		t.Errorf("expected a single request for the requested chunk, got %v", ranges)
+	}
+}
+
+func TestPeerRequest(t *testing.T) {
+	router := mocks.NewMockRouter(map[string][]string{})
+	r := newOriginReader(t, router, "somekey", "https://origin")
+	r.context.Request.Header.Set("Authorization", "Bearer secret")
+
+	req, err := r.peerRequest("https://peer:5001", 1048586, 1048595)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Peers are asked by digest only, without the URL or credentials of origin.
+	if expected := "https://peer:5001/p2p/v1/chunks/" + blob.String() + "/1048586"; req.URL.String() != expected {
+		t.Errorf("expected %v, got %v", expected, req.URL.String())
+	}
+
+	if v := req.Header.Get("Authorization"); v != "" {
+		t.Errorf("expected no credentials to be sent to peers, got %v", v)
+	}
+
+	if v := req.Header.Get("Range"); v != "bytes=1048586-1048595" {
+		t.Errorf("expected range %v, got %v", "bytes=1048586-1048595", v)
+	}
+
+	// Origin still gets the credentials of the client.
+	req, err = r.originRequest(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := req.Header.Get("Authorization"); v != "Bearer secret" {
+		t.Errorf("expected credentials to be sent to origin, got %v", v)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog"
+)
+
+// Results of the verification of a chunk, reported in metrics.
+const (
+	verificationKindChunk = "chunk"
+	verificationSuccess   = "success"
+	verificationCorrupt   = "corrupt"
+	verificationInvalid   = "invalid"
+)
+
+// corruptError indicates that data assembled from the parts served by several peers failed verification.
+type corruptError struct {
+	// data is the assembled data.
+	data []byte
+
+	// partSize is the size of the parts of the data.
+	partSize int64
+
+	// owners are the peers that served each part.
+	owners []routing.PeerInfo
+}
+
+// Error implements error.
+func (e *corruptError) Error() string {
+	return "data from peers failed verification"
+}
+
+// blob returns the digest of the blob read by this reader.
+func (r *reader) blob() digest.Digest {
+	return digest.Digest(r.context.GetString(pcontext.DigestCtxKey))
+}
+
+// verify checks the chunk at offset read from the given peers against the manifest entry in the response headers.
+// If the chunk is verified, the entry is added to the manifests, marked as trusted if it can be used right away. It
+// returns manifest.ErrDigestMismatch if the data is corrupt, or manifest.ErrInvalidEntry if the entry is missing or
+// malformed.
+func (r *reader) verify(log zerolog.Logger, offset int64, buf []byte, header http.Header, servers []routing.PeerInfo) error {
+	e, err := manifest.Decode(header.Get(manifest.HeaderKey))
+	if err == nil {
+		err = e.Verify(r.blob(), offset, buf)
+	}
+
+	switch {
+	case err == nil:
+		r.metricsRecorder.RecordVerification(verificationKindChunk, verificationSuccess)
+		e.Trusted = r.trusts(e, servers)
+		if !e.Trusted {
+			log.Debug().Str("author", e.Author.String()).Msg("chunk signed by the peer serving it, held until its blob is verified")
+		}
+		if err := r.manifests.Put(e); err != nil {
+			log.Warn().Err(err).Msg("failed to persist chunk manifest entry")
+		}
+	case errors.Is(err, manifest.ErrDigestMismatch):
+		r.metricsRecorder.RecordVerification(verificationKindChunk, verificationCorrupt)
+		log.Error().Err(err).Str("author", e.Author.String()).Msg("chunk failed verification")
+	default:
+		r.metricsRecorder.RecordVerification(verificationKindChunk, verificationInvalid)
+		log.Warn().Err(err).Msg("chunk cannot be verified")
+	}
+
+	return err
+}
+
+// trusts returns true if the chunk of the given entry, served by the given peers, can be used right away: it is vouched
+// for by this host, or by a trusted peer that did not serve it. The peer serving a chunk can sign an entry for any data,
+// so other chunks from peers are only used once their blob is verified.
+func (r *reader) trusts(e *manifest.Entry, servers []routing.PeerInfo) bool {
+	self, err := peer.IDFromPrivateKey(r.router.Identity())
+	if err == nil && e.Author == self {
+		return true
+	}
+
+	for _, s := range servers {
+		if s.ID == e.Author {
+			return false
+		}
+	}
+
+	return r.router.Trusted(e.Author)
+}
+
+// verifyParts checks the chunk at offset assembled from the given parts against the manifest entries served with them.
+// If none of the entries verifies the chunk, it returns a corruptError.
+func (r *reader) verifyParts(log zerolog.Logger, offset int64, buf []byte, p *parts) error {
+	tried := map[string]struct{}{}
+	for _, h := range p.headers {
+		if _, ok := tried[h.Get(manifest.HeaderKey)]; ok {
+			continue
+		}
+		tried[h.Get(manifest.HeaderKey)] = struct{}{}
+
+		if r.verify(log, offset, buf, h, p.owners) == nil {
+			return nil
+		}
+	}
+
+	return &corruptError{data: bytes.Clone(buf), partSize: r.partSize, owners: p.owners}
+}
+
+// vouch adds an entry signed by this host for the chunk at offset read from origin to the manifests.
+func (r *reader) vouch(log zerolog.Logger, offset int64, buf []byte) {
+	e, err := manifest.NewEntry(r.router.Identity(), r.blob(), offset, buf)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create chunk manifest entry")
+		return
+	}
+
+	if err := r.manifests.Put(e); err != nil {
+		log.Warn().Err(err).Msg("failed to persist chunk manifest entry")
+	}
+}
+
+// blame blocks the peers that served corrupt parts, found by comparing the parts with the data read from origin.
+func (r *reader) blame(log zerolog.Logger, fileChunkKey string, corrupt *corruptError, buf []byte) {
+	blocked := map[peer.ID]struct{}{}
+	for i, owner := range corrupt.owners {
+		partStart := int64(i) * corrupt.partSize
+		partEnd := min(partStart+corrupt.partSize, int64(len(buf)))
+
+		if _, ok := blocked[owner.ID]; ok || bytes.Equal(corrupt.data[partStart:partEnd], buf[partStart:partEnd]) {
+			continue
+		}
+
+		blocked[owner.ID] = struct{}{}
+		r.block(log, fileChunkKey, owner)
+	}
+}
+
+// block stops using a peer that served corrupt data.
+func (r *reader) block(log zerolog.Logger, fileChunkKey string, peer routing.PeerInfo) {
+	log.Error().Str("peer", peer.HttpHost).Msg("peer served corrupt data, blocking")
+	r.router.Invalidate(fileChunkKey, peer.ID)
+	r.router.Block(peer.ID)
+}
@@ -0,0 +1,248 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package reader
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/azure/peerd/pkg/discovery/routing/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog"
+)
+
+// newOriginReader creates a reader of the chunk with the given key, whose origin is the given server.
+func newOriginReader(t *testing.T, router *mocks.MockRouter, key, origin string) *reader {
+	p := origin + "/some-path"
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	c.Params = []gin.Param{{Key: "url", Value: p}}
+
+	pc := pcontext.FromContext(c)
+	pc.Set(pcontext.BlobUrlCtxKey, pcontext.BlobUrl(pc))
+	pc.Set(pcontext.FileChunkCtxKey, key)
+	pc.Set(pcontext.DigestCtxKey, blob.String())
+
+	return NewReader(pc, router, manifest.NewStore(), NewOrigins(DefaultOriginConfig, mr), 3, 500*time.Millisecond, mr).(*reader)
+}
+
+func TestP2pCorruptPeer(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+	entry := signedEntry(t, 0, []byte(expected))
+
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(manifest.HeaderKey, entry)
+		// nolint:errcheck
+		w.Write([]byte("corrupted!"))
+	}))
+	defer corrupt.Close()
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(manifest.HeaderKey, entry)
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer svr.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {corrupt.URL, svr.URL}})
+	r := newOriginReader(t, router, key, "http://127.0.0.1:1")
+	b := make([]byte, 10)
+
+	if _, err := r.doP2p(r.context, zerolog.Nop(), key, 0, 9, operationPreadRemote, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != expected {
+		t.Errorf("expected %v, got %v", expected, string(b))
+	}
+
+	if blocked := router.Blocked(); len(blocked) != 1 || string(blocked[0]) != corrupt.URL {
+		t.Errorf("expected corrupt peer to be blocked, got %v", blocked)
+	}
+
+	if _, ok := r.manifests.Get(blob, 0); !ok {
+		t.Error("expected verified entry to be added to the manifests")
+	}
+}
+
+func TestP2pUnverifiedPeer(t *testing.T) {
+	key := "somekey"
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// nolint:errcheck
+		w.Write([]byte("expected-r"))
+	}))
+	defer svr.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {svr.URL}})
+	r := newOriginReader(t, router, key, "http://127.0.0.1:1")
+
+	if _, err := r.doP2p(r.context, zerolog.Nop(), key, 0, 9, operationPreadRemote, make([]byte, 10)); err != errPeerNotFound {
+		t.Errorf("expected %v, got %v", errPeerNotFound, err)
+	}
+
+	// A peer without a manifest entry is not trusted, but not blocked either.
+	if invalidated := router.Invalidated(key); len(invalidated) != 1 {
+		t.Errorf("expected unverified peer to be invalidated, got %v", invalidated)
+	}
+
+	if blocked := router.Blocked(); len(blocked) != 0 {
+		t.Errorf("expected unverified peer to not be blocked, got %v", blocked)
+	}
+}
+
+func TestPreadRemoteVouchesForOrigin(t *testing.T) {
+	key := "somekey"
+	expected := "expected-r"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// nolint:errcheck
+		w.Write([]byte(expected))
+	}))
+	defer origin.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {}})
+	r := newOriginReader(t, router, key, origin.URL)
+	b := make([]byte, 10)
+
+	if _, err := r.PreadRemote(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := r.manifests.Get(blob, 0)
+	if !ok {
+		t.Fatal("expected chunk from origin to be added to the manifests")
+	}
+
+	self, err := peer.IDFromPrivateKey(router.Identity())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Author != self {
+		t.Errorf("expected entry to be authored by this host, got %v", e.Author)
+	}
+
+	if err := e.Verify(blob, 0, b); err != nil {
+		t.Errorf("expected entry to verify, got %v", err)
+	}
+}
+
+func TestParallelReadBlamesCorruptPeer(t *testing.T) {
+	key := "somekey"
+	data := make([]byte, 256*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	entry := signedEntry(t, 0, data)
+
+	corrupted := bytes.Clone(data)
+	for i := range corrupted {
+		corrupted[i] ^= 0xff
+	}
+
+	var requests atomic.Int32
+	corrupt := newContentServer(corrupted, entry, &requests)
+	defer corrupt.Close()
+
+	// The honest peer is slow, so that the corrupt peer serves some parts.
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set(manifest.HeaderKey, entry)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer svr.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer origin.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{key: {corrupt.URL, svr.URL}})
+	r := newOriginReader(t, router, key, origin.URL)
+	r.partSize = 64 * 1024
+
+	buf := make([]byte, len(data))
+	if _, err := r.PreadRemote(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, data) {
+		t.Fatal("expected data from origin")
+	}
+
+	if blocked := router.Blocked(); len(blocked) != 1 || string(blocked[0]) != corrupt.URL {
+		t.Errorf("expected only the corrupt peer to be blocked, got %v", blocked)
+	}
+}
+
+func TestVerifyTrustsRelayedChunks(t *testing.T) {
+	data := []byte("expected-r")
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	author, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mocks.NewMockRouter(map[string][]string{})
+	r := newOriginReader(t, router, "somekey", "http://127.0.0.1:1")
+
+	for _, tc := range []struct {
+		name     string
+		key      crypto.PrivKey
+		servers  []routing.PeerInfo
+		block    bool
+		expected bool
+	}{
+		{name: "signed by self", key: router.Identity(), servers: []routing.PeerInfo{{ID: author}}, expected: true},
+		{name: "signed by server", key: key, servers: []routing.PeerInfo{{ID: "other"}, {ID: author}}, expected: false},
+		{name: "relayed", key: key, servers: []routing.PeerInfo{{ID: "other"}}, expected: true},
+		{name: "relayed from blocked author", key: key, servers: []routing.PeerInfo{{ID: "other"}}, block: true, expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.block {
+				router.Block(author)
+			}
+
+			e, err := manifest.NewEntry(tc.key, blob, 0, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := e.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := http.Header{}
+			h.Set(manifest.HeaderKey, v)
+			if err := r.verify(zerolog.Nop(), 0, data, h, tc.servers); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := r.manifests.Get(blob, 0)
+			if !ok {
+				t.Fatal("expected entry to be added to the manifests")
+			}
+
+			if got.Trusted != tc.expected {
+				t.Errorf("expected trusted to be %v, got %v", tc.expected, got.Trusted)
+			}
+		})
+	}
+}
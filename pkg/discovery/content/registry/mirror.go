@@ -14,7 +14,9 @@ import (
 	pcontext "github.com/azure/peerd/pkg/context"
 	"github.com/azure/peerd/pkg/discovery/routing"
 	"github.com/azure/peerd/pkg/metrics"
+	"github.com/azure/peerd/pkg/oci/distribution"
 	"github.com/azure/peerd/pkg/peernet"
+	"github.com/rs/zerolog"
 )
 
 var (
@@ -25,17 +27,26 @@ var (
 	ResolveTimeout = 1 * time.Second
 )
 
+// errPeerRequestFailed indicates that a peer could not serve a proxied request.
+var errPeerRequestFailed = errors.New("peer request failed")
+
 // Mirror is a handler that handles requests to this registry proxy.
 type Mirror struct {
 	resolveTimeout time.Duration
 	router         routing.Router
 	resolveRetries int
 
+	partSize     int64
+	partsWindow  int
+	stallTimeout time.Duration
+
 	n               peernet.Network
 	metricsRecorder metrics.Metrics
 }
 
 // Handle handles a request to this registry mirror.
+// A blob larger than a part is fetched in parts from all resolved peers at once, if the first peer serves ranges of it.
+// Other requests are proxied to a single peer at a time.
 func (m *Mirror) Handle(c pcontext.Context) {
 	key := c.GetString(pcontext.DigestCtxKey)
 	if key == "" {
@@ -87,50 +98,94 @@ func (m *Mirror) Handle(c pcontext.Context) {
 				// Only report the time it took to discover the first peer.
 				m.metricsRecorder.RecordPeerDiscovery(peer.HttpHost, time.Since(startTime).Seconds())
 				peerCount++
+
+				if m.splittable(c) {
+					if size, ok := m.blobSize(c, peer); ok && size > m.partSize {
+						m.serveParts(c, l, key, size, peer, peersChan, resolveCtx.Done())
+						return
+					}
+				}
 			}
 
-			succeeded := false
-			u, err := url.Parse(peer.HttpHost)
-			if err != nil {
-				//nolint
-				c.AbortWithError(http.StatusInternalServerError, err)
+			if m.proxy(c, l, key, peer, startTime) {
 				return
 			}
+		}
+	}
+}
 
-			proxy := httputil.NewSingleHostReverseProxy(u)
-			proxy.Director = func(r *http.Request) {
-				r.URL = u
-				r.URL.Path = c.Request.URL.Path
-				r.URL.RawQuery = c.Request.URL.RawQuery
-				pcontext.SetOutboundHeaders(r, c)
-			}
+// splittable returns true if the request is for a whole blob, which can be fetched in parts.
+func (m *Mirror) splittable(c pcontext.Context) bool {
+	refType, _ := c.Get(pcontext.RefTypeCtxKey)
+	if t, ok := refType.(distribution.ReferenceType); !ok || t != distribution.ReferenceTypeBlob {
+		return false
+	}
 
-			count := int64(0)
+	return m.partSize > 0 && c.Request.Method == http.MethodGet && c.Request.Header.Get("Range") == ""
+}
 
-			proxy.ModifyResponse = func(resp *http.Response) error {
-				if resp.StatusCode != http.StatusOK {
-					return fmt.Errorf("expected peer to respond with 200, got: %s", resp.Status)
-				}
+// proxy proxies the request to the given peer. It returns true if the peer served the request.
+func (m *Mirror) proxy(c pcontext.Context, l zerolog.Logger, key string, peer routing.PeerInfo, startTime time.Time) bool {
+	succeeded := false
+	u, err := url.Parse(peer.HttpHost)
+	if err != nil {
+		//nolint
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return true
+	}
 
-				succeeded = true
-				count = resp.ContentLength
-				return nil
-			}
-			proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-				l.Error().Err(err).Msg("peer request failed, attempting next")
-			}
-			proxy.Transport = m.n.RoundTripperFor(peer.ID)
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Director = func(r *http.Request) {
+		r.URL = u
+		r.URL.Path = c.Request.URL.Path
+		r.URL.RawQuery = c.Request.URL.RawQuery
+		// The credentials of the client for the registry are not shared with peers.
+		pcontext.SetPeerHeaders(r, c)
+	}
 
-			proxy.ServeHTTP(c.Writer, c.Request)
-			if !succeeded {
-				break
-			}
+	count := int64(0)
+	unavailable := false
+	busy := false
 
-			m.metricsRecorder.RecordPeerResponse(peer.HttpHost, key, "pull", time.Since(startTime).Seconds(), count)
-			l.Info().Str("peer", u.Host).Int64("count", count).Msg("request served from peer")
-			return
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		unavailable = resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+		busy = resp.StatusCode == http.StatusServiceUnavailable
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("expected peer to respond with 200, got: %s", resp.Status)
 		}
+
+		succeeded = true
+		count = resp.ContentLength
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		l.Error().Err(err).Msg("peer request failed, attempting next")
 	}
+	proxy.Transport = m.n.RoundTripperFor(peer.ID)
+
+	peerStartTime := time.Now()
+	proxy.ServeHTTP(c.Writer, c.Request)
+	if busy {
+		// The peer has no free upload slots, try next peer right away.
+		l.Debug().Str("peer", u.Host).Msg("peer busy, attempting next")
+		return false
+	}
+
+	if !succeeded {
+		m.router.RecordPeerResponse(peer.ID, time.Since(peerStartTime), 0, errPeerRequestFailed)
+		if unavailable {
+			// The provider record of this peer is stale.
+			m.router.Forget(key, peer.ID)
+		} else {
+			m.router.Invalidate(key, peer.ID)
+		}
+		return false
+	}
+
+	m.router.RecordPeerResponse(peer.ID, time.Since(peerStartTime), count, nil)
+	m.metricsRecorder.RecordPeerResponse(peer.HttpHost, key, "pull", time.Since(startTime).Seconds(), count)
+	l.Info().Str("peer", u.Host).Int64("count", count).Msg("request served from peer")
+	return true
 }
 
 // New creates a new mirror handler.
@@ -140,6 +195,9 @@ func New(ctx context.Context, router routing.Router) *Mirror {
 		resolveTimeout:  ResolveTimeout,
 		router:          router,
 		resolveRetries:  ResolveRetries,
+		partSize:        PartSize,
+		partsWindow:     PartsWindow,
+		stallTimeout:    StallTimeout,
 		n:               router.Net(),
 	}
 }
@@ -3,16 +3,22 @@
 package registry
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	pcontext "github.com/azure/peerd/pkg/context"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/metrics"
+	"github.com/azure/peerd/pkg/oci/distribution"
 	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
@@ -58,7 +64,14 @@ func TestMirrorHandler(t *testing.T) {
 	}))
 	defer goodSvr.Close()
 
+	busySvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busySvr.Close()
+
 	resolver := map[string][]string{
+		"busy-peer":         {busySvr.URL, goodSvr.URL},
 		"no-working-peers":  {badSvr.URL, "foo", badSvr.URL},
 		"first-peer":        {goodSvr.URL, badSvr.URL, badSvr.URL},
 		"first-peer-error":  {"foo", goodSvr.URL},
@@ -108,6 +121,13 @@ func TestMirrorHandler(t *testing.T) {
 			expectedBody:    "hello world",
 			expectedHeaders: map[string][]string{"foo": {"bar"}},
 		},
+		{
+			name:            "next peer should respond when first is busy",
+			key:             "busy-peer",
+			expectedStatus:  http.StatusOK,
+			expectedBody:    "hello world",
+			expectedHeaders: map[string][]string{"foo": {"bar"}},
+		},
 		{
 			name:            "last peer should respond when two first fail",
 			key:             "last-peer-working",
@@ -148,4 +168,125 @@ func TestMirrorHandler(t *testing.T) {
 			})
 		}
 	}
+
+	// Busy peers are skipped but not invalidated.
+	require.Empty(t, router.Invalidated("busy-peer"))
+}
+
+func TestMirrorHandlerStripsCredentials(t *testing.T) {
+	var header http.Header
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	defer svr.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{"key": {svr.URL}})
+	m := &Mirror{
+		metricsRecorder: metrics.NewPromMetrics(prometheus.NewRegistry(), "test", "test"),
+		router:          router,
+		resolveRetries:  ResolveRetries,
+		resolveTimeout:  ResolveTimeout,
+		n:               router.Net(),
+	}
+
+	rw := CreateTestResponseRecorder()
+	c, _ := gin.CreateTestContext(rw)
+	c.Request = httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/alpine/blobs/key?ns=docker.io", nil)
+	c.Request.Header.Set("Authorization", "Bearer secret")
+	c.Request.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
+	c.Set(pcontext.DigestCtxKey, "key")
+	m.Handle(pcontext.FromContext(c))
+
+	require.Equal(t, http.StatusOK, rw.Result().StatusCode)
+	require.Empty(t, header.Get("Authorization"))
+	require.Equal(t, "application/vnd.oci.image.manifest.v1+json", header.Get("Accept"))
+	require.Equal(t, "true", header.Get(pcontext.P2PHeaderKey))
+}
+
+func TestMirrorHandlerParts(t *testing.T) {
+	data := make([]byte, 10*1024+123)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	var served [2]atomic.Int32
+	newPeer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				// Parts are slow enough for the other peer to serve some of them.
+				time.Sleep(time.Millisecond)
+				served[i].Add(1)
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}))
+	}
+	peer0, peer1 := newPeer(0), newPeer(1)
+	defer peer0.Close()
+	defer peer1.Close()
+
+	// The failing peer serves the size of the blob, but no part of it.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// The old peer serves the blob whole.
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write(data)
+	}))
+	defer old.Close()
+
+	router := mocks.NewMockRouter(map[string][]string{
+		"parts":        {peer0.URL, failing.URL, peer1.URL},
+		"no-ranges":    {old.URL},
+		"failing-only": {failing.URL},
+	})
+	m := &Mirror{
+		metricsRecorder: metrics.NewPromMetrics(prometheus.NewRegistry(), "test", "test"),
+		router:          router,
+		resolveRetries:  ResolveRetries,
+		resolveTimeout:  ResolveTimeout,
+		partSize:        1024,
+		partsWindow:     2,
+		stallTimeout:    StallTimeout,
+		n:               router.Net(),
+	}
+
+	for _, tt := range []struct {
+		key            string
+		expectedStatus int
+		expectedBody   []byte
+	}{
+		{key: "parts", expectedStatus: http.StatusOK, expectedBody: data},
+		{key: "no-ranges", expectedStatus: http.StatusOK, expectedBody: data},
+		{key: "failing-only", expectedStatus: http.StatusInternalServerError, expectedBody: []byte{}},
+	} {
+		t.Run(tt.key, func(t *testing.T) {
+			rw := CreateTestResponseRecorder()
+			c, _ := gin.CreateTestContext(rw)
+			c.Request = httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/alpine/blobs/"+tt.key, nil)
+			c.Set(pcontext.DigestCtxKey, tt.key)
+			c.Set(pcontext.RefTypeCtxKey, distribution.ReferenceType(distribution.ReferenceTypeBlob))
+			m.Handle(pcontext.FromContext(c))
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, tt.expectedBody, b)
+		})
+	}
+
+	// The parts are fetched from both working peers, and the failing peer is invalidated.
+	require.Positive(t, served[0].Load())
+	require.Positive(t, served[1].Load())
+	require.Equal(t, int32(11), served[0].Load()+served[1].Load())
+	require.Contains(t, router.Invalidated("parts"), peer.ID(failing.URL))
 }
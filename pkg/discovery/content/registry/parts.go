@@ -0,0 +1,361 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// PartSize is the size of the sub-ranges of a blob that are fetched from peers in parallel.
+	// Smaller blobs are proxied from a single peer.
+	PartSize int64 = 1024 * 1024
+
+	// PartsWindow is the number of parts that may be fetched ahead of the part being written to the client, which bounds
+	// the memory held by a request to PartsWindow * PartSize bytes.
+	PartsWindow = 8
+
+	// StallTimeout is how long a peer may send no data for a part before the part is reassigned.
+	StallTimeout = 5 * time.Second
+)
+
+// blobParts tracks the parts of a blob that are fetched from peers in parallel and written to the client in order.
+// Each worker fetches parts from one peer, failed parts are requeued for other workers. A part is only fetched once it
+// is within the window of parts after the one being written.
+type blobParts struct {
+	mx   sync.Mutex
+	cond *sync.Cond
+
+	size     int64
+	partSize int64
+	window   int
+
+	// pending are the parts left to fetch, in order, and data are the fetched parts not yet written.
+	pending []int
+	data    [][]byte
+	written int
+
+	workers  int
+	resolved bool
+	aborted  bool
+}
+
+// newBlobParts creates the parts of a blob of size bytes, split into sub-ranges of partSize.
+func newBlobParts(size, partSize int64, window int) *blobParts {
+	count := int((size + partSize - 1) / partSize)
+	p := &blobParts{
+		size:     size,
+		partSize: partSize,
+		window:   window,
+		data:     make([][]byte, count),
+	}
+	p.cond = sync.NewCond(&p.mx)
+	for i := 0; i < count; i++ {
+		p.pending = append(p.pending, i)
+	}
+	return p
+}
+
+// bounds returns the start and end offsets of the given part.
+func (p *blobParts) bounds(i int) (int64, int64) {
+	start := int64(i) * p.partSize
+	return start, min(start+p.partSize, p.size)
+}
+
+// next returns the next part to fetch. It waits while the next part is outside the window, or the remaining parts are
+// being fetched by other workers, since they may be requeued. It returns false when all parts are written or the request
+// was aborted.
+func (p *blobParts) next() (int, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for !p.aborted && p.written < len(p.data) {
+		if len(p.pending) > 0 && p.pending[0] < p.written+p.window {
+			i := p.pending[0]
+			p.pending = p.pending[1:]
+			return i, true
+		}
+		p.cond.Wait()
+	}
+
+	return -1, false
+}
+
+// complete records the data of a fetched part.
+func (p *blobParts) complete(i int, data []byte) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.data[i] = data
+	p.cond.Broadcast()
+}
+
+// fail makes a part that could not be fetched available to other workers.
+func (p *blobParts) fail(i int) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	j, _ := slices.BinarySearch(p.pending, i)
+	p.pending = slices.Insert(p.pending, j, i)
+	p.cond.Broadcast()
+}
+
+// add records a new worker.
+func (p *blobParts) add() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.workers++
+}
+
+// exit records that a worker stopped fetching parts.
+func (p *blobParts) exit() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.workers--
+	p.cond.Broadcast()
+}
+
+// resolve records that no more workers will be added.
+func (p *blobParts) resolve() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.resolved = true
+	p.cond.Broadcast()
+}
+
+// abort stops all waiting workers.
+func (p *blobParts) abort() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.aborted = true
+	p.cond.Broadcast()
+}
+
+// wait waits for the given part to be fetched, and returns its data so that it is written to the client.
+// It returns false if no worker is left to fetch it.
+func (p *blobParts) wait(i int) ([]byte, bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for p.data[i] == nil && !p.aborted && (!p.resolved || p.workers > 0) {
+		p.cond.Wait()
+	}
+
+	data := p.data[i]
+	if data == nil {
+		return nil, false
+	}
+
+	p.data[i] = nil
+	p.written = i + 1
+	p.cond.Broadcast()
+	return data, true
+}
+
+// blobSize asks the given peer for the size of the requested blob.
+// It returns false if the peer does not serve ranges of the blob, so that it cannot be fetched in parts.
+func (m *Mirror) blobSize(c pcontext.Context, peer routing.PeerInfo) (int64, bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), m.stallTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, peer.HttpHost+c.Request.URL.RequestURI(), nil)
+	if err != nil {
+		return -1, false
+	}
+	pcontext.SetPeerHeaders(req, c)
+
+	resp, err := m.n.HTTPClientFor(peer.ID).Do(req)
+	if err != nil {
+		return -1, false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength < 0 {
+		return -1, false
+	}
+
+	return resp.ContentLength, true
+}
+
+// serveParts writes the requested blob of the given size to the client. Its parts are fetched in parallel by one worker
+// for the given peer, and one for each peer resolved later, and written to the client in order.
+// If no part could be fetched, the request is aborted so that containerd falls back to the next host. If a later part
+// cannot be fetched, the response is cut short, and containerd retries the pull.
+func (m *Mirror) serveParts(c pcontext.Context, l zerolog.Logger, key string, size int64, first routing.PeerInfo, peersChan <-chan routing.PeerInfo, resolveDone <-chan struct{}) {
+	p := newBlobParts(size, m.partSize, m.partsWindow)
+
+	// The gin context must not be used by the workers, so they are given the headers to send to peers.
+	template := &http.Request{}
+	pcontext.SetPeerHeaders(template, c)
+	uri := c.Request.URL.RequestURI()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	var wg sync.WaitGroup
+	defer func() {
+		p.abort()
+		cancel()
+		wg.Wait()
+	}()
+
+	fetch := func(peer routing.PeerInfo) {
+		p.add()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.fetchParts(ctx, l, key, peer, template.Header, uri, p)
+		}()
+	}
+
+	fetch(first)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer p.resolve()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resolveDone:
+				return
+			case peer, ok := <-peersChan:
+				if !ok {
+					return
+				}
+				fetch(peer)
+			}
+		}
+	}()
+
+	startTime := time.Now()
+	for i := range p.data {
+		data, ok := p.wait(i)
+		if !ok {
+			if i == 0 {
+				//nolint
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf(pcontext.PeerResolutionExhaustedLog))
+				return
+			}
+			l.Error().Int("part", i).Msg("no peer left to serve part, response cut short")
+			return
+		}
+
+		if i == 0 {
+			c.Header("Content-Type", "application/octet-stream")
+			c.Header("Content-Length", strconv.FormatInt(size, 10))
+			c.Header("Docker-Content-Digest", key)
+			c.Status(http.StatusOK)
+		}
+
+		if _, err := c.Writer.Write(data); err != nil {
+			l.Error().Err(err).Int("part", i).Msg("failed to write part")
+			return
+		}
+	}
+
+	l.Info().Int64("count", size).Int("parts", len(p.data)).Dur("duration", time.Since(startTime)).Msg("request served from peers")
+}
+
+// fetchParts fetches parts from the given peer until none are left or the peer fails.
+func (m *Mirror) fetchParts(ctx context.Context, l zerolog.Logger, key string, peer routing.PeerInfo, header http.Header, uri string, p *blobParts) {
+	defer p.exit()
+
+	client := m.n.HTTPClientFor(peer.ID)
+	for {
+		i, ok := p.next()
+		if !ok {
+			return
+		}
+
+		start, end := p.bounds(i)
+		startTime := time.Now()
+		data, status, err := m.fetchPart(ctx, client, peer.HttpHost+uri, header, start, end)
+		if err == nil {
+			m.router.RecordPeerResponse(peer.ID, time.Since(startTime), int64(len(data)), nil)
+			m.metricsRecorder.RecordPeerResponse(peer.HttpHost, key, "pull", time.Since(startTime).Seconds(), int64(len(data)))
+			p.complete(i, data)
+			continue
+		}
+
+		p.fail(i)
+		if ctx.Err() != nil {
+			// The request is done.
+			return
+		}
+
+		if status == http.StatusServiceUnavailable {
+			// The peer has no free upload slots, leave the part to other peers.
+			l.Debug().Str("peer", peer.HttpHost).Msg("peer busy, reassigning part")
+			return
+		}
+
+		m.router.RecordPeerResponse(peer.ID, time.Since(startTime), 0, errPeerRequestFailed)
+		l.Error().Err(err).Str("peer", peer.HttpHost).Int("part", i).Msg("peer request failed, reassigning part")
+		if status == http.StatusNotFound || status == http.StatusGone {
+			// The provider record of this peer is stale.
+			m.router.Forget(key, peer.ID)
+		} else {
+			m.router.Invalidate(key, peer.ID)
+		}
+		return
+	}
+}
+
+// fetchPart reads the range from start to end of the blob at the given URL, and returns it with the response status.
+// The request is canceled if the peer sends no data for the stall timeout.
+func (m *Mirror) fetchPart(ctx context.Context, client *http.Client, url string, header http.Header, start, end int64) ([]byte, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+
+	stall := time.AfterFunc(m.stallTimeout, cancel)
+	defer stall.Stop()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, resp.StatusCode, fmt.Errorf("expected peer to respond with 206, got: %s", resp.Status)
+	}
+
+	data := make([]byte, end-start)
+	for n := 0; n < len(data); {
+		k, err := resp.Body.Read(data[n:])
+		if k > 0 {
+			n += k
+			stall.Reset(m.stallTimeout)
+		}
+
+		if err != nil && n < len(data) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, resp.StatusCode, err
+		}
+	}
+
+	return data, resp.StatusCode, nil
+}
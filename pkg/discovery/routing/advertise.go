@@ -0,0 +1,207 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/azure/peerd/pkg/metrics"
+	cid "github.com/ipfs/go-cid"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// advertiseWorkers is the number of keys announced to the network concurrently.
+	advertiseWorkers = 8
+
+	// advertiseRate is the maximum number of keys announced to the network per second, across all workers.
+	advertiseRate = 50
+
+	// advertiseBurst is the number of keys that may be announced at once before the rate limit applies.
+	advertiseBurst = 10
+
+	// advertiseQueueSize is the number of keys waiting to be announced, beyond which Provide blocks.
+	advertiseQueueSize = 10000
+
+	// recentlyAdvertisedTtl is how long an announced key is skipped for.
+	// It is shorter than the reprovide interval, so that reprovides are never skipped.
+	recentlyAdvertisedTtl = 5 * time.Minute
+)
+
+// advertisement is a key queued for announcement, shared by all callers providing the key at the same time.
+type advertisement struct {
+	key  string
+	done chan struct{}
+	err  error
+}
+
+// advertiser announces keys to the network with a pool of workers, at a limited rate.
+// Keys that are already queued or were announced recently are not announced again.
+type advertiser struct {
+	provide         func(ctx context.Context, c cid.Cid) error
+	limiter         *rate.Limiter
+	queue           chan *advertisement
+	metricsRecorder metrics.Metrics
+
+	mx         sync.Mutex
+	pending    map[string]*advertisement
+	advertised map[string]time.Time
+	now        func() time.Time
+	pruned     time.Time
+}
+
+// newAdvertiser creates a new advertiser that announces keys with the given provide function.
+// Its workers run until the context is done.
+func newAdvertiser(ctx context.Context, provide func(ctx context.Context, c cid.Cid) error, m metrics.Metrics) *advertiser {
+	a := &advertiser{
+		provide:         provide,
+		limiter:         rate.NewLimiter(rate.Limit(advertiseRate), advertiseBurst),
+		queue:           make(chan *advertisement, advertiseQueueSize),
+		metricsRecorder: m,
+		pending:         map[string]*advertisement{},
+		advertised:      map[string]time.Time{},
+		now:             time.Now,
+	}
+
+	for i := 0; i < advertiseWorkers; i++ {
+		go a.work(ctx)
+	}
+
+	return a
+}
+
+// advertise announces the given keys and waits until they are announced or the context is done.
+func (a *advertiser) advertise(ctx context.Context, keys []string) error {
+	waiting := []*advertisement{}
+	for _, key := range keys {
+		adv, queued := a.enqueue(key)
+		if adv == nil {
+			continue
+		}
+
+		if queued {
+			select {
+			case a.queue <- adv:
+				a.metricsRecorder.RecordProvideQueueDepth(len(a.queue))
+			case <-ctx.Done():
+				a.finish(adv, ctx.Err())
+				return ctx.Err()
+			}
+		}
+
+		waiting = append(waiting, adv)
+	}
+
+	errs := []error{}
+	for _, adv := range waiting {
+		select {
+		case <-adv.done:
+			if adv.err != nil {
+				errs = append(errs, adv.err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// enqueue returns the advertisement of the given key, and whether it must be queued by the caller.
+// It returns nil if the key was announced recently.
+func (a *advertiser) enqueue(key string) (*advertisement, bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	now := a.now()
+	a.prune(now)
+
+	if at, ok := a.advertised[key]; ok && now.Sub(at) < recentlyAdvertisedTtl {
+		return nil, false
+	}
+
+	if adv, ok := a.pending[key]; ok {
+		return adv, false
+	}
+
+	adv := &advertisement{key: key, done: make(chan struct{})}
+	a.pending[key] = adv
+	return adv, true
+}
+
+// finish completes the given advertisement with the given result and notifies its waiters.
+func (a *advertiser) finish(adv *advertisement, err error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	delete(a.pending, adv.key)
+	if err == nil {
+		a.advertised[adv.key] = a.now()
+	}
+
+	adv.err = err
+	close(adv.done)
+}
+
+// forget clears the given key, so that it is announced again the next time it is provided.
+func (a *advertiser) forget(key string) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	delete(a.advertised, key)
+}
+
+// work announces queued keys until the context is done.
+func (a *advertiser) work(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("component", "advertiser").Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case adv := <-a.queue:
+			a.metricsRecorder.RecordProvideQueueDepth(len(a.queue))
+
+			if err := a.limiter.Wait(ctx); err != nil {
+				a.finish(adv, err)
+				continue
+			}
+
+			start := time.Now()
+			err := a.announce(ctx, adv.key)
+			a.metricsRecorder.RecordProvideDuration(time.Since(start).Seconds())
+			if err != nil {
+				log.Debug().Err(err).Str("key", adv.key).Msg("could not announce key")
+			}
+
+			a.finish(adv, err)
+		}
+	}
+}
+
+// announce announces the given key to the network.
+func (a *advertiser) announce(ctx context.Context, key string) error {
+	contentId, err := createContentId(key)
+	if err != nil {
+		return err
+	}
+
+	return a.provide(ctx, contentId)
+}
+
+// prune removes keys that were announced too long ago to be skipped.
+func (a *advertiser) prune(now time.Time) {
+	if now.Sub(a.pruned) < recentlyAdvertisedTtl {
+		return
+	}
+	a.pruned = now
+
+	for key, at := range a.advertised {
+		if now.Sub(at) >= recentlyAdvertisedTtl {
+			delete(a.advertised, key)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// testAnnouncer records the announced content ids.
+type testAnnouncer struct {
+	mx        sync.Mutex
+	announced map[cid.Cid]int
+	inFlight  int
+	maxFlight int
+	delay     time.Duration
+	err       error
+}
+
+func (a *testAnnouncer) provide(ctx context.Context, c cid.Cid) error {
+	a.mx.Lock()
+	a.inFlight++
+	a.maxFlight = max(a.maxFlight, a.inFlight)
+	a.mx.Unlock()
+
+	time.Sleep(a.delay)
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.inFlight--
+	a.announced[c]++
+	return a.err
+}
+
+func TestAdvertiseParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ta := &testAnnouncer{announced: map[cid.Cid]int{}, delay: 10 * time.Millisecond}
+	a := newAdvertiser(ctx, ta.provide, mr)
+
+	keys := []string{}
+	for i := 0; i < advertiseBurst; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+
+	if err := a.advertise(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ta.announced) != len(keys) {
+		t.Errorf("expected %d keys to be announced, got %d", len(keys), len(ta.announced))
+	}
+
+	if ta.maxFlight < 2 || ta.maxFlight > advertiseWorkers {
+		t.Errorf("expected between 2 and %d concurrent announcements, got %d", advertiseWorkers, ta.maxFlight)
+	}
+}
+
+func TestAdvertiseDeduplicates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	ta := &testAnnouncer{announced: map[cid.Cid]int{}, delay: 10 * time.Millisecond}
+	a := newAdvertiser(ctx, ta.provide, mr)
+	a.now = func() time.Time { return now }
+
+	// Concurrent callers share the queued announcement.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.advertise(ctx, []string{"key", "key"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, err := createContentId("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ta.announced[c] != 1 {
+		t.Fatalf("expected key to be announced once, got %d", ta.announced[c])
+	}
+
+	// Recently announced keys are skipped.
+	if err := a.advertise(ctx, []string{"key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ta.announced[c] != 1 {
+		t.Errorf("expected recently announced key to be skipped, got %d announcements", ta.announced[c])
+	}
+
+	// Once the window has passed, the key is announced again.
+	now = now.Add(recentlyAdvertisedTtl)
+	if err := a.advertise(ctx, []string{"key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ta.announced[c] != 2 {
+		t.Errorf("expected key to be announced again, got %d announcements", ta.announced[c])
+	}
+
+	// Forgotten keys are announced again.
+	a.forget("key")
+	if err := a.advertise(ctx, []string{"key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ta.announced[c] != 3 {
+		t.Errorf("expected forgotten key to be announced again, got %d announcements", ta.announced[c])
+	}
+}
+
+func TestAdvertiseError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ta := &testAnnouncer{announced: map[cid.Cid]int{}, err: errors.New("failed")}
+	a := newAdvertiser(ctx, ta.provide, mr)
+
+	if err := a.advertise(ctx, []string{"key"}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Failed keys are retried.
+	ta.err = nil
+	if err := a.advertise(ctx, []string{"key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := createContentId("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ta.announced[c] != 2 {
+		t.Errorf("expected failed key to be announced again, got %d announcements", ta.announced[c])
+	}
+}
+
+func TestAdvertiseRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ta := &testAnnouncer{announced: map[cid.Cid]int{}}
+	a := newAdvertiser(ctx, ta.provide, mr)
+
+	keys := []string{}
+	for i := 0; i < advertiseBurst+advertiseRate/10; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+
+	start := time.Now()
+	if err := a.advertise(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys beyond the burst are announced at the rate limit.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected announcements to be rate limited, took %v", elapsed)
+	}
+}
+
+func TestAdvertiseCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ta := &testAnnouncer{announced: map[cid.Cid]int{}, delay: time.Second}
+	a := newAdvertiser(ctx, ta.provide, mr)
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer reqCancel()
+
+	if err := a.advertise(reqCtx, []string{"key"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+// Package bootstrap provides sources of peers used to bootstrap the p2p router into the network.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Bootstrapper provides the peers used to bootstrap a host into the p2p network.
+type Bootstrapper interface {
+	// Run starts the bootstrapper.
+	// self is the p2p multiaddress of this host, which some implementations advertise to others.
+	Run(ctx context.Context, self string) error
+
+	// Peers returns the current list of bootstrap peers.
+	// The list may contain this host, callers are expected to filter it out.
+	Peers(ctx context.Context) ([]peer.AddrInfo, error)
+}
+
+// Supported bootstrap modes.
+const (
+	// ModeK8s bootstraps using the leader of a Kubernetes lease.
+	ModeK8s = "k8s"
+
+	// ModeStatic bootstraps using a static list of p2p multiaddresses.
+	ModeStatic = "static"
+
+	// ModeDns bootstraps using the records of a DNS name, such as a headless service.
+	ModeDns = "dns"
+
+	// ModeFile bootstraps using a file of p2p multiaddresses that is re-read on every bootstrap.
+	ModeFile = "file"
+)
+
+// parseAddrInfos parses the given p2p multiaddresses and merges addresses that belong to the same peer.
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := []multiaddr.Multiaddr{}
+	for _, a := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer address %q: %w", a, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+
+	infos, err := peer.AddrInfosFromP2pAddrs(maddrs...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap peer addresses: %w", err)
+	}
+
+	return infos, nil
+}
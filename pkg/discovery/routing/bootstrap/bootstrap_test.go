@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestParseAddrInfos(t *testing.T) {
+	id1 := newTestPeerId(t)
+	id2 := newTestPeerId(t)
+
+	for _, tc := range []struct {
+		name        string
+		addrs       []string
+		expectedLen int
+		expectedErr bool
+	}{
+		{
+			name:        "single peer",
+			addrs:       []string{"/ip4/10.0.0.1/tcp/5003/p2p/" + id1.String()},
+			expectedLen: 1,
+		},
+		{
+			name:        "same peer with multiple addresses",
+			addrs:       []string{"/ip4/10.0.0.1/tcp/5003/p2p/" + id1.String(), "/ip6/fd00::1/tcp/5003/p2p/" + id1.String()},
+			expectedLen: 1,
+		},
+		{
+			name:        "multiple peers",
+			addrs:       []string{"/ip4/10.0.0.1/tcp/5003/p2p/" + id1.String(), "/ip4/10.0.0.2/tcp/5003/p2p/" + id2.String()},
+			expectedLen: 2,
+		},
+		{
+			name:        "invalid multiaddr",
+			addrs:       []string{"10.0.0.1:5003"},
+			expectedErr: true,
+		},
+		{
+			name:        "missing peer id",
+			addrs:       []string{"/ip4/10.0.0.1/tcp/5003"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAddrInfos(tc.addrs)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(got) != tc.expectedLen {
+				t.Fatalf("expected %d peers, got %d", tc.expectedLen, len(got))
+			}
+		})
+	}
+}
+
+// newTestPeerId creates a random peer ID.
+func newTestPeerId(t *testing.T) peer.ID {
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
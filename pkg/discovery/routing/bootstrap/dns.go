@@ -0,0 +1,180 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// identifyTimeout is the timeout for learning the identity of a peer resolved through DNS.
+	identifyTimeout = 2 * time.Second
+)
+
+// resolver describes the DNS lookups used by the dns bootstrapper.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// identifier learns the peer ID of the peer at the given IP address.
+type identifier func(ctx context.Context, ip string) (peer.ID, error)
+
+// dns is a Bootstrapper that resolves peers from DNS records, such as those of a Kubernetes headless service.
+// Names starting with '_' are resolved as SRV records, whose ports are the router ports of the peers.
+// All other names are resolved as A/AAAA records, and the configured router port is used.
+// DNS records do not carry peer IDs, so each peer is identified by its TLS certificate on the peer registry port.
+type dns struct {
+	name       string
+	routerPort int
+
+	resolver resolver
+	identify identifier
+}
+
+var _ Bootstrapper = &dns{}
+
+// Run is a no-op for DNS peers, the name is resolved on every call to Peers.
+func (d *dns) Run(ctx context.Context, self string) error {
+	return nil
+}
+
+// Peers resolves the DNS name and identifies the peers behind it.
+func (d *dns) Peers(ctx context.Context) ([]peer.AddrInfo, error) {
+	log := zerolog.Ctx(ctx).With().Str("component", "bootstrap").Str("name", d.name).Logger()
+
+	targets, err := d.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := []peer.AddrInfo{}
+	for _, t := range targets {
+		id, err := d.identify(ctx, t.IP.String())
+		if err != nil {
+			log.Debug().Err(err).Str("ip", t.IP.String()).Msg("could not identify bootstrap peer")
+			continue
+		}
+
+		maddr, err := manet.FromNetAddr(t)
+		if err != nil {
+			log.Debug().Err(err).Str("ip", t.IP.String()).Msg("could not create bootstrap peer address")
+			continue
+		}
+
+		peers = append(peers, peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{maddr}})
+	}
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no bootstrap peers could be identified for %s", d.name)
+	}
+
+	return peers, nil
+}
+
+// lookup resolves the DNS name to a list of router addresses.
+func (d *dns) lookup(ctx context.Context) ([]*net.TCPAddr, error) {
+	addrs := []*net.TCPAddr{}
+
+	if strings.HasPrefix(d.name, "_") {
+		_, srvs, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, srv := range srvs {
+			ips, err := d.resolver.LookupHost(ctx, srv.Target)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, toTCPAddrs(ips, int(srv.Port))...)
+		}
+	} else {
+		ips, err := d.resolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, toTCPAddrs(ips, d.routerPort)...)
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", d.name)
+	}
+
+	return addrs, nil
+}
+
+// toTCPAddrs converts the given IP addresses to TCP addresses with the given port.
+func toTCPAddrs(ips []string, port int) []*net.TCPAddr {
+	addrs := []*net.TCPAddr{}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+		addrs = append(addrs, &net.TCPAddr{IP: parsed, Port: port})
+	}
+	return addrs
+}
+
+// tlsIdentifier returns an identifier that learns the peer ID from the libp2p certificate served on the given port.
+func tlsIdentifier(port string) identifier {
+	return func(ctx context.Context, ip string) (peer.ID, error) {
+		d := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: identifyTimeout},
+			// The certificate is self-signed, it is verified as a libp2p certificate below.
+			Config: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+		}
+
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return "", errors.New("unexpected connection type")
+		}
+
+		pubKey, err := libp2ptls.PubKeyFromCertChain(tlsConn.ConnectionState().PeerCertificates)
+		if err != nil {
+			return "", err
+		}
+
+		return peer.IDFromPublicKey(pubKey)
+	}
+}
+
+// NewDns creates a Bootstrapper that resolves peers from the given DNS name.
+// routerPort is used for A/AAAA records, peerRegistryPort is used to identify the resolved peers.
+func NewDns(name, routerPort, peerRegistryPort string) (Bootstrapper, error) {
+	if name == "" {
+		return nil, fmt.Errorf("bootstrap DNS name cannot be empty")
+	}
+
+	p, err := strconv.Atoi(routerPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid router port %q: %w", routerPort, err)
+	}
+
+	return &dns{
+		name:       name,
+		routerPort: p,
+		resolver:   net.DefaultResolver,
+		identify:   tlsIdentifier(peerRegistryPort),
+	}, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+)
+
+func TestNewDns(t *testing.T) {
+	if _, err := NewDns("", "5003", "5001"); err == nil {
+		t.Fatal("expected error for empty name")
+	}
+
+	if _, err := NewDns("peerd.peerd-ns.svc.cluster.local", "port", "5001"); err == nil {
+		t.Fatal("expected error for invalid port")
+	}
+
+	if _, err := NewDns("peerd.peerd-ns.svc.cluster.local", "5003", "5001"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDnsPeers(t *testing.T) {
+	id1 := newTestPeerId(t)
+	id2 := newTestPeerId(t)
+	ids := map[string]peer.ID{"10.0.0.1": id1, "fd00::2": id2}
+
+	tr := &testResolver{
+		srvs: []*net.SRV{{Target: "node-1.peerd", Port: 6003}},
+		hosts: map[string][]string{
+			"peerd.peerd-ns.svc.cluster.local": {"10.0.0.1", "fd00::2", "10.0.0.3"},
+			"node-1.peerd":                     {"10.0.0.1"},
+		},
+	}
+	identify := func(ctx context.Context, ip string) (peer.ID, error) {
+		id, ok := ids[ip]
+		if !ok {
+			return "", errors.New("unreachable")
+		}
+		return id, nil
+	}
+
+	for _, tc := range []struct {
+		name          string
+		dnsName       string
+		expectedAddrs []string
+		expectedErr   bool
+	}{
+		{
+			name:          "a records",
+			dnsName:       "peerd.peerd-ns.svc.cluster.local",
+			expectedAddrs: []string{"/ip4/10.0.0.1/tcp/5003", "/ip6/fd00::2/tcp/5003"},
+		},
+		{
+			name:          "srv records",
+			dnsName:       "_router._tcp.peerd.peerd-ns.svc.cluster.local",
+			expectedAddrs: []string{"/ip4/10.0.0.1/tcp/6003"},
+		},
+		{
+			name:        "unknown name",
+			dnsName:     "unknown",
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &dns{name: tc.dnsName, routerPort: 5003, resolver: tr, identify: identify}
+
+			peers, err := d.Peers(context.Background())
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(peers) != len(tc.expectedAddrs) {
+				t.Fatalf("expected %d peers, got %d", len(tc.expectedAddrs), len(peers))
+			}
+
+			for i, p := range peers {
+				if p.Addrs[0].String() != tc.expectedAddrs[i] {
+					t.Errorf("expected address %s, got %s", tc.expectedAddrs[i], p.Addrs[0].String())
+				}
+
+				ip := strings.Split(tc.expectedAddrs[i], "/")[2]
+				if p.ID != ids[ip] {
+					t.Errorf("expected peer %s, got %s", ids[ip], p.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestTlsIdentifier(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := libp2ptls.NewIdentity(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := id.ConfigForPeer("")
+
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	svr.TLS = &tls.Config{Certificates: cfg.Certificates}
+	svr.StartTLS()
+	defer svr.Close()
+
+	host, port, err := net.SplitHostPort(svr.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tlsIdentifier(port)(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != expected {
+		t.Fatalf("expected peer %s, got %s", expected, got)
+	}
+}
+
+type testResolver struct {
+	srvs  []*net.SRV
+	hosts map[string][]string
+}
+
+// LookupHost implements resolver.
+func (r *testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+// LookupSRV implements resolver.
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return name, r.srvs, nil
+}
+
+var _ resolver = &testResolver{}
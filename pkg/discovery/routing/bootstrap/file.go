@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// file is a Bootstrapper that reads peers from a file.
+// The file contains one p2p multiaddress per line. Empty lines and lines starting with '#' are ignored.
+type file struct {
+	path string
+}
+
+var _ Bootstrapper = &file{}
+
+// Run is a no-op for file peers, the file is read on every call to Peers.
+func (f *file) Run(ctx context.Context, self string) error {
+	return nil
+}
+
+// Peers reads the file and returns the peers in it.
+func (f *file) Peers(ctx context.Context) ([]peer.AddrInfo, error) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return parseAddrInfos(addrs)
+}
+
+// NewFile creates a Bootstrapper that reads peers from the given file.
+func NewFile(path string) (Bootstrapper, error) {
+	if path == "" {
+		return nil, fmt.Errorf("bootstrap file path cannot be empty")
+	}
+
+	return &file{path: path}, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePeers(t *testing.T) {
+	_, err := NewFile("")
+	if err == nil {
+		t.Fatal("expected error for empty path")
+	}
+
+	path := filepath.Join(t.TempDir(), "peers")
+	b, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := b.Run(ctx, "self"); err != nil {
+		t.Fatal(err)
+	}
+
+	// File does not exist yet.
+	if _, err := b.Peers(ctx); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+
+	id1 := newTestPeerId(t)
+	content := "# bootstrap peers\n\n/ip4/10.0.0.1/tcp/5003/p2p/" + id1.String() + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := b.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 1 || peers[0].ID != id1 {
+		t.Fatalf("expected peer %s, got %v", id1, peers)
+	}
+
+	// The file is re-read on every call.
+	id2 := newTestPeerId(t)
+	content += "/ip4/10.0.0.2/tcp/5003/p2p/" + id2.String() + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err = b.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(peers))
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/azure/peerd/pkg/k8s"
+	"github.com/azure/peerd/pkg/k8s/election"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// leaderElectionName is the name of the lease used to elect the bootstrap peer.
+	leaderElectionName = "peerd-leader-election"
+)
+
+// leader is a Bootstrapper that uses the leader of a Kubernetes lease as the only bootstrap peer.
+type leader struct {
+	le election.LeaderElection
+}
+
+var _ Bootstrapper = &leader{}
+
+// Run runs the leader election with this host as a candidate.
+func (l *leader) Run(ctx context.Context, self string) error {
+	return l.le.RunOrDie(ctx, self)
+}
+
+// Peers returns the elected leader.
+func (l *leader) Peers(ctx context.Context) ([]peer.AddrInfo, error) {
+	addr, err := l.le.Leader()
+	if err != nil {
+		return nil, err
+	}
+
+	addrInfo, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []peer.AddrInfo{*addrInfo}, nil
+}
+
+// NewK8s creates a Bootstrapper that elects a leader in the Kubernetes cluster.
+func NewK8s(cs *k8s.ClientSet) Bootstrapper {
+	return &leader{le: election.New(leaderElectionName, cs)}
+}
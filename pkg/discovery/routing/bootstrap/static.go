@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// static is a Bootstrapper that always returns the same list of peers.
+type static struct {
+	peers []peer.AddrInfo
+}
+
+var _ Bootstrapper = &static{}
+
+// Run is a no-op for static peers.
+func (s *static) Run(ctx context.Context, self string) error {
+	return nil
+}
+
+// Peers returns the configured peers.
+func (s *static) Peers(ctx context.Context) ([]peer.AddrInfo, error) {
+	return s.peers, nil
+}
+
+// NewStatic creates a Bootstrapper from a list of p2p multiaddresses, such as /ip4/10.0.0.1/tcp/5003/p2p/<id>.
+func NewStatic(addrs []string) (Bootstrapper, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("at least one static bootstrap peer is required")
+	}
+
+	peers, err := parseAddrInfos(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &static{peers: peers}, nil
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package bootstrap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewStatic(t *testing.T) {
+	_, err := NewStatic(nil)
+	if err == nil {
+		t.Fatal("expected error for empty peers")
+	}
+
+	_, err = NewStatic([]string{"not-a-multiaddr"})
+	if err == nil {
+		t.Fatal("expected error for invalid peer")
+	}
+
+	id := newTestPeerId(t)
+	b, err := NewStatic([]string{"/ip4/10.0.0.1/tcp/5003/p2p/" + id.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := b.Run(ctx, "self"); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := b.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(peers))
+	} else if peers[0].ID != id {
+		t.Fatalf("expected peer %s, got %s", id, peers[0].ID)
+	}
+}
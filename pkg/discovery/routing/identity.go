@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rs/zerolog"
+)
+
+// previousKeySuffix is appended to the path of a rotated identity key to keep the previous key.
+const previousKeySuffix = ".previous"
+
+// LoadIdentity returns the private key of the p2p identity of this host, stored at the given path.
+// A new key is generated and stored if there is none, or if the stored key is older than maxAge, when maxAge is set.
+// The previous key of a rotation is kept next to the new key.
+// If path is empty, a new key is generated that only lasts until the host stops.
+func LoadIdentity(ctx context.Context, path string, maxAge time.Duration) (crypto.PrivKey, error) {
+	log := zerolog.Ctx(ctx).With().Str("component", "identity").Str("path", path).Logger()
+
+	if path == "" {
+		log.Warn().Msg("no identity key path, using an ephemeral identity")
+		return generateKey()
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Info().Msg("creating identity key")
+		return createKey(path)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not stat identity key: %w", err)
+	}
+
+	if maxAge > 0 && time.Since(info.ModTime()) > maxAge {
+		log.Info().Time("created", info.ModTime()).Msg("rotating identity key")
+		if err := os.Rename(path, path+previousKeySuffix); err != nil {
+			return nil, fmt.Errorf("could not keep previous identity key: %w", err)
+		}
+		return createKey(path)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read identity key: %w", err)
+	}
+
+	key, err := crypto.UnmarshalPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse identity key: %w", err)
+	}
+
+	return key, nil
+}
+
+// generateKey generates a new identity key.
+func generateKey() (crypto.PrivKey, error) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	return key, err
+}
+
+// createKey generates a new identity key and stores it at the given path.
+// The key is written to a temporary file first, so that a partially written key is never used.
+func createKey(path string) (crypto.PrivKey, error) {
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("could not create identity key directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return nil, fmt.Errorf("could not write identity key: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("could not write identity key: %w", err)
+	}
+
+	return key, nil
+}
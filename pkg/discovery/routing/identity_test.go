@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestLoadIdentity(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "peerd", "identity.key")
+
+	key, err := LoadIdentity(ctx, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected identity key permissions %v, got %v", os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// The stored key is reused.
+	reloaded, err := LoadIdentity(ctx, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.Equals(reloaded) {
+		t.Fatal("expected identity key to be reused")
+	}
+
+	// The host and its peer ID are stable.
+	h, err := newHost(ctx, HostOptions{Addrs: []string{"127.0.0.1:0"}, Key: reloaded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.ID() != id {
+		t.Errorf("expected host id %s, got %s", id, h.ID())
+	}
+}
+
+func TestLoadIdentityRotation(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identity.key")
+
+	key, err := LoadIdentity(ctx, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A recent key is not rotated.
+	reloaded, err := LoadIdentity(ctx, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.Equals(reloaded) {
+		t.Fatal("expected recent identity key to be reused")
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := LoadIdentity(ctx, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.Equals(rotated) {
+		t.Fatal("expected old identity key to be rotated")
+	}
+
+	if _, err := os.Stat(path + previousKeySuffix); err != nil {
+		t.Errorf("expected previous identity key to be kept: %v", err)
+	}
+}
+
+func TestLoadIdentityInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := LoadIdentity(ctx, "", 0)
+	if err != nil || key == nil {
+		t.Fatalf("expected ephemeral identity key, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "identity.key")
+	if err := os.WriteFile(path, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadIdentity(ctx, path, 0); err == nil {
+		t.Error("expected error for invalid identity key")
+	}
+}
@@ -4,8 +4,10 @@ package routing
 
 import (
 	"context"
+	"time"
 
 	"github.com/azure/peerd/pkg/peernet"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -20,10 +22,40 @@ type Router interface {
 	// ResolveWithNegativeCacheCallback is like Resolve but it also returns a function callback that can be used to cache that a key could not be resolved.
 	ResolveWithNegativeCacheCallback(ctx context.Context, key string, allowSelf bool, count int) (<-chan PeerInfo, func(), error)
 
+	// RecordPeerResponse records the outcome of a request to a peer, used to rank and quarantine peers.
+	// A nil err records a success that transferred count bytes in the given duration.
+	RecordPeerResponse(id peer.ID, duration time.Duration, count int64, err error)
+
+	// Block stops returning the given peer from any resolution for a while.
+	// It should be called when the peer serves corrupt content.
+	Block(id peer.ID)
+
+	// Trusted returns true if the given peer is a known member of the network that is not blocked or quarantined, so
+	// that the content it vouches for can be accepted when relayed by another peer.
+	Trusted(id peer.ID) bool
+
+	// Identity returns the private key of the identity of this host, used to sign the content it vouches for.
+	Identity() crypto.PrivKey
+
+	// Invalidate removes the given peer from the cached providers of the key.
+	// It should be called when the peer fails a request for the key.
+	Invalidate(key string, id peer.ID)
+
 	// Provide provides the given keys to the network.
 	// This lets the k-closest peers to the key know that we are providing it.
 	Provide(ctx context.Context, keys []string) error
 
+	// Withdraw stops advertising the given keys from this host, such as when the content is evicted or deleted.
+	// Keys provided again are advertised again.
+	Withdraw(ctx context.Context, keys []string) error
+
+	// Withdrawn returns true if the given key was withdrawn by this host and has not been provided since.
+	Withdrawn(key string) bool
+
+	// Forget ignores the provider record of the given peer for the key until the record expires.
+	// It should be called when the peer no longer has the content.
+	Forget(key string, id peer.ID)
+
 	// Close closes the router.
 	Close() error
 }
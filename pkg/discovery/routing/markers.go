@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"sync"
+	"time"
+)
+
+// markersPruneInterval is how often expired markers are removed.
+const markersPruneInterval = 1 * time.Minute
+
+// markers is a set of keys that expire, such as keys withdrawn by this host.
+// Unlike the lookup cache, a marker is never dropped or evicted before it expires, so a marker that was just set is
+// always seen.
+type markers struct {
+	mx      sync.Mutex
+	expires map[string]time.Time
+	now     func() time.Time
+	pruned  time.Time
+}
+
+// newMarkers creates a new set of markers.
+func newMarkers() *markers {
+	return &markers{
+		expires: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// set marks the given key for the given duration.
+func (m *markers) set(key string, ttl time.Duration) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	now := m.now()
+	m.prune(now)
+	m.expires[key] = now.Add(ttl)
+}
+
+// del removes the marker of the given key.
+func (m *markers) del(key string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	delete(m.expires, key)
+}
+
+// has returns true if the given key is marked and its marker has not expired.
+func (m *markers) has(key string) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	expires, ok := m.expires[key]
+	return ok && m.now().Before(expires)
+}
+
+// prune removes expired markers, at most once per markersPruneInterval.
+// The caller must hold the lock.
+func (m *markers) prune(now time.Time) {
+	if now.Sub(m.pruned) < markersPruneInterval {
+		return
+	}
+	m.pruned = now
+
+	for key, expires := range m.expires {
+		if !now.Before(expires) {
+			delete(m.expires, key)
+		}
+	}
+}
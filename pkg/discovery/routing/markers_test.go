@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMarkers(t *testing.T) {
+	now := time.Now()
+	m := newMarkers()
+	m.now = func() time.Time { return now }
+
+	m.set("withdrawn/a", time.Minute)
+	m.set("withdrawn/b", time.Hour)
+
+	if !m.has("withdrawn/a") || !m.has("withdrawn/b") {
+		t.Fatal("expected keys to be marked")
+	}
+
+	if m.has("withdrawn/c") {
+		t.Error("expected key to not be marked")
+	}
+
+	m.del("withdrawn/b")
+	if m.has("withdrawn/b") {
+		t.Error("expected deleted marker to be removed")
+	}
+
+	// Markers expire, and expired markers are pruned.
+	now = now.Add(2 * time.Minute)
+	if m.has("withdrawn/a") {
+		t.Error("expected marker to expire")
+	}
+
+	m.set("withdrawn/c", time.Minute)
+	if _, ok := m.expires["withdrawn/a"]; ok {
+		t.Error("expected expired marker to be pruned")
+	}
+}
+
+func TestMarkersAreNotDropped(t *testing.T) {
+	m := newMarkers()
+
+	// Unlike entries of the lookup cache, markers are never dropped or evicted before they expire.
+	for i := 0; i < 10000; i++ {
+		m.set(withdrawnKey(strconv.Itoa(i)), MaxRecordAge)
+	}
+
+	for i := 0; i < 10000; i++ {
+		if !m.has(withdrawnKey(strconv.Itoa(i))) {
+			t.Fatalf("expected key %v to be marked", i)
+		}
+	}
+}
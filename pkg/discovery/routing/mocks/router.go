@@ -4,12 +4,17 @@ package mocks
 
 import (
 	"context"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/azure/peerd/pkg/discovery/routing"
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/azure/peerd/pkg/peernet"
 	"github.com/azure/peerd/pkg/peernet/mocks"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type MockRouter struct {
@@ -17,7 +22,12 @@ type MockRouter struct {
 	mx       sync.RWMutex
 	resolver map[string][]string
 
-	negCache map[string]struct{}
+	negCache    map[string]struct{}
+	invalidated map[string][]peer.ID
+	withdrawn   map[string]struct{}
+	forgotten   map[string][]peer.ID
+	blocked     []peer.ID
+	key         crypto.PrivKey
 }
 
 // Net implements routing.Router.
@@ -38,15 +48,24 @@ func (m *MockRouter) ResolveWithNegativeCacheCallback(ctx context.Context, key s
 var _ routing.Router = &MockRouter{}
 
 func NewMockRouter(resolver map[string][]string) *MockRouter {
-	n, err := peernet.New(&mocks.MockHost{PeerStore: &mocks.MockPeerstore{}})
+	n, err := peernet.New(&mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}, metrics.NewPromMetrics(prometheus.NewRegistry(), "mock", "peerd"))
+	if err != nil {
+		panic(err)
+	}
+
+	key, _, err := crypto.GenerateEd25519Key(nil)
 	if err != nil {
 		panic(err)
 	}
 
 	return &MockRouter{
-		p2pNet:   n,
-		resolver: resolver,
-		negCache: map[string]struct{}{},
+		p2pNet:      n,
+		resolver:    resolver,
+		negCache:    map[string]struct{}{},
+		invalidated: map[string][]peer.ID{},
+		withdrawn:   map[string]struct{}{},
+		forgotten:   map[string][]peer.ID{},
+		key:         key,
 	}
 }
 
@@ -74,15 +93,94 @@ func (m *MockRouter) Resolve(ctx context.Context, key string, allowSelf bool, co
 	return peerCh, nil
 }
 
+// RecordPeerResponse implements routing.Router.
+func (m *MockRouter) RecordPeerResponse(id peer.ID, duration time.Duration, count int64, err error) {
+}
+
+// Block implements routing.Router.
+func (m *MockRouter) Block(id peer.ID) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.blocked = append(m.blocked, id)
+}
+
+// Blocked returns the blocked peers.
+func (m *MockRouter) Blocked() []peer.ID {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return m.blocked
+}
+
+// Trusted implements routing.Router.
+// Every peer that is not blocked is trusted.
+func (m *MockRouter) Trusted(id peer.ID) bool {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return !slices.Contains(m.blocked, id)
+}
+
+// Identity implements routing.Router.
+func (m *MockRouter) Identity() crypto.PrivKey {
+	return m.key
+}
+
+// Invalidate implements routing.Router.
+func (m *MockRouter) Invalidate(key string, id peer.ID) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.invalidated[key] = append(m.invalidated[key], id)
+}
+
+// Invalidated returns the peers invalidated for the given key.
+func (m *MockRouter) Invalidated(key string) []peer.ID {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return m.invalidated[key]
+}
+
 func (m *MockRouter) Provide(ctx context.Context, keys []string) error {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	for _, key := range keys {
 		m.resolver[key] = []string{"localhost"}
+		delete(m.withdrawn, key)
+	}
+	return nil
+}
+
+// Withdraw implements routing.Router.
+func (m *MockRouter) Withdraw(ctx context.Context, keys []string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for _, key := range keys {
+		delete(m.resolver, key)
+		m.withdrawn[key] = struct{}{}
 	}
 	return nil
 }
 
+// Withdrawn implements routing.Router.
+func (m *MockRouter) Withdrawn(key string) bool {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	_, ok := m.withdrawn[key]
+	return ok
+}
+
+// Forget implements routing.Router.
+func (m *MockRouter) Forget(key string, id peer.ID) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.forgotten[key] = append(m.forgotten[key], id)
+}
+
+// Forgotten returns the peers forgotten for the given key.
+func (m *MockRouter) Forgotten(key string) []peer.ID {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return m.forgotten[key]
+}
+
 func (m *MockRouter) LookupKey(key string) ([]string, bool) {
 	m.mx.RLock()
 	defer m.mx.RUnlock()
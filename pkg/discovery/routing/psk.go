@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/azure/peerd/pkg/k8s"
+	"github.com/azure/peerd/pkg/k8s/events"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// PSKSecretKey is the key of the pre-shared key in its Kubernetes secret.
+	PSKSecretKey = "swarm.key"
+
+	// handshakeTimeout is how long an accepted connection may take to be secured before it is reported.
+	handshakeTimeout = 10 * time.Second
+)
+
+// LoadPSK returns the pre-shared key of the private network, read from the given file or Kubernetes secret.
+// The key uses the libp2p swarm key format, such as generated by ipfs-swarm-key-gen.
+// If neither is given, nil is returned and the network is public.
+func LoadPSK(ctx context.Context, clientset *k8s.ClientSet, path, secret string) (pnet.PSK, error) {
+	var b []byte
+	var err error
+
+	switch {
+	case path != "" && secret != "":
+		return nil, fmt.Errorf("only one of a pre-shared key file or secret may be set")
+	case path != "":
+		b, err = os.ReadFile(path)
+	case secret != "":
+		if clientset == nil {
+			return nil, fmt.Errorf("kubernetes is required to read the pre-shared key secret %s", secret)
+		}
+		b, err = clientset.SecretData(ctx, secret, PSKSecretKey)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read pre-shared key: %w", err)
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode pre-shared key: %w", err)
+	}
+
+	return psk, nil
+}
+
+// handshakeGater reports inbound connections that are not secured in time.
+// In a private network, this is the case for peers that use a different pre-shared key, but libp2p does not tell such
+// failures apart from port scans, slow peers and dropped connections, so all of them are reported as timeouts.
+// It does not block any connection.
+type handshakeGater struct {
+	timeout   time.Duration
+	onTimeout func(addr multiaddr.Multiaddr)
+
+	mx      sync.Mutex
+	pending map[string]*time.Timer
+}
+
+var _ connmgr.ConnectionGater = &handshakeGater{}
+
+// newHandshakeGater creates a new gater that logs and records an event for every handshake that timed out.
+func newHandshakeGater(ctx context.Context) *handshakeGater {
+	log := zerolog.Ctx(ctx).With().Str("component", "pnet").Logger()
+	return &handshakeGater{
+		timeout: handshakeTimeout,
+		onTimeout: func(addr multiaddr.Multiaddr) {
+			log.Warn().Str("addr", addr.String()).Msg("inbound connection not secured in time, the peer may not share the private network key")
+			events.FromContext(ctx).HandshakeTimedOut(addr.String())
+		},
+		pending: map[string]*time.Timer{},
+	}
+}
+
+// InterceptPeerDial allows all outbound dials.
+func (g *handshakeGater) InterceptPeerDial(p peer.ID) bool {
+	return true
+}
+
+// InterceptAddrDial allows all outbound dials.
+func (g *handshakeGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) bool {
+	return true
+}
+
+// InterceptAccept starts waiting for the handshake of an inbound connection.
+func (g *handshakeGater) InterceptAccept(cm network.ConnMultiaddrs) bool {
+	addr := cm.RemoteMultiaddr()
+	key := addr.String()
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.pending[key] = time.AfterFunc(g.timeout, func() {
+		g.mx.Lock()
+		_, ok := g.pending[key]
+		delete(g.pending, key)
+		g.mx.Unlock()
+
+		if ok {
+			g.onTimeout(addr)
+		}
+	})
+
+	return true
+}
+
+// InterceptSecured marks the handshake of an inbound connection as completed.
+func (g *handshakeGater) InterceptSecured(dir network.Direction, _ peer.ID, cm network.ConnMultiaddrs) bool {
+	if dir != network.DirInbound {
+		return true
+	}
+
+	key := cm.RemoteMultiaddr().String()
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	if t, ok := g.pending[key]; ok {
+		t.Stop()
+		delete(g.pending, key)
+	}
+
+	return true
+}
+
+// InterceptUpgraded allows all upgraded connections.
+func (g *handshakeGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
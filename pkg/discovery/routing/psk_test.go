@@ -0,0 +1,159 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/pkg/k8s"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/multiformats/go-multiaddr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testSwarmKey returns a swarm key with every byte set to b.
+func testSwarmKey(b byte) string {
+	return "/key/swarm/psk/1.0.0/\n/base16/\n" + strings.Repeat(string("0123456789abcdef"[b%16]), 64) + "\n"
+}
+
+func TestLoadPSK(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "swarm.key")
+	if err := os.WriteFile(path, []byte(testSwarmKey(1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := &k8s.ClientSet{
+		Namespace: "peerd-ns",
+		Interface: fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "peerd-psk", Namespace: "peerd-ns"},
+			Data:       map[string][]byte{PSKSecretKey: []byte(testSwarmKey(1))},
+		}),
+	}
+
+	fromFile, err := LoadPSK(ctx, nil, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromSecret, err := LoadPSK(ctx, cs, "", "peerd-psk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fromFile) != 32 || !bytes.Equal(fromFile, fromSecret) {
+		t.Errorf("expected the same 32 byte key from file and secret, got %x and %x", fromFile, fromSecret)
+	}
+
+	none, err := LoadPSK(ctx, nil, "", "")
+	if err != nil || none != nil {
+		t.Errorf("expected no key, got %x, %v", none, err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cs     *k8s.ClientSet
+		path   string
+		secret string
+	}{
+		{name: "file and secret", cs: cs, path: path, secret: "peerd-psk"},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing")},
+		{name: "secret without kubernetes", secret: "peerd-psk"},
+		{name: "missing secret", cs: cs, secret: "missing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := LoadPSK(ctx, tc.cs, tc.path, tc.secret); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.key")
+	if err := os.WriteFile(invalid, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPSK(ctx, nil, invalid, ""); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := func(b byte) pnet.PSK {
+		psk, err := pnet.DecodeV1PSK(strings.NewReader(testSwarmKey(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return psk
+	}
+
+	h, err := newHost(ctx, HostOptions{Addrs: []string{"0.0.0.0:0"}, PSK: key(1)})
+	if err != nil {
+		t.Fatalf("expected host in private network: %v", err)
+	}
+	h.Close()
+
+	timedOut := make(chan multiaddr.Multiaddr, 1)
+	g := &handshakeGater{
+		timeout:   500 * time.Millisecond,
+		onTimeout: func(addr multiaddr.Multiaddr) { timedOut <- addr },
+		pending:   map[string]*time.Timer{},
+	}
+
+	h1, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.PrivateNetwork(key(1)), libp2p.ConnectionGater(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+
+	member, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.PrivateNetwork(key(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	outsider, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.PrivateNetwork(key(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outsider.Close()
+
+	target := peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}
+
+	if err := member.Connect(ctx, target); err != nil {
+		t.Fatalf("expected peer with the same key to connect: %v", err)
+	}
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dialCancel()
+	if err := outsider.Connect(dialCtx, target); err == nil {
+		t.Fatal("expected peer with a different key to be unable to connect")
+	}
+
+	select {
+	case <-timedOut:
+	case <-ctx.Done():
+		t.Fatal("expected handshake timeout to be reported")
+	}
+
+	// Only the outsider is reported.
+	select {
+	case addr := <-timedOut:
+		t.Errorf("expected a single handshake timeout, got another from %s", addr)
+	case <-time.After(time.Second):
+	}
+}
@@ -6,21 +6,31 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/azure/peerd/pkg/discovery/routing/bootstrap"
 	"github.com/azure/peerd/pkg/k8s"
-	"github.com/azure/peerd/pkg/k8s/election"
 	"github.com/azure/peerd/pkg/k8s/events"
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/azure/peerd/pkg/peernet"
 	"github.com/dgraph-io/ristretto"
 	cid "github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	mc "github.com/multiformats/go-multicodec"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/rs/zerolog"
@@ -30,7 +40,37 @@ const (
 	MaxRecordAge = 30 * time.Minute
 
 	negCacheTtl     = 500 * time.Millisecond
+	posCacheTtl     = 1 * time.Minute
 	strPeerNotFound = "PEER_NOT_FOUND"
+
+	// rankWindow is how long providers found in the network are buffered to be ranked before they are returned.
+	rankWindow = 10 * time.Millisecond
+)
+
+// Transports of the router host.
+const (
+	// TransportTCP is the TCP transport.
+	TransportTCP = "tcp"
+
+	// TransportQUIC is the QUIC transport, which sets up connections faster and multiplexes streams over UDP.
+	// It cannot be used in a private network.
+	TransportQUIC = "quic"
+)
+
+// Transports of requests for content between peers.
+const (
+	// PeerTransportHTTPS sends requests to the HTTPS port of peers, at the IP of their router address.
+	PeerTransportHTTPS = "https"
+
+	// PeerTransportLibp2p sends requests over libp2p streams of the router host, so only the router address is needed.
+	PeerTransportLibp2p = "libp2p"
+)
+
+// Results of a lookup cache query, reported in metrics.
+const (
+	lookupCacheHit         = "hit"
+	lookupCacheMiss        = "miss"
+	lookupCacheNegativeHit = "negative_hit"
 )
 
 type router struct {
@@ -43,17 +83,42 @@ type router struct {
 	// content is the content discovery service.
 	content *routing.RoutingDiscovery
 
+	// advertiser announces provided keys to the network.
+	advertiser *advertiser
+
 	// peerRegistryPort is the port used for the peer registry.
 	peerRegistryPort string
 
-	// lookupCache is a cache for storing the results of lookups, usually used to store negative results.
+	// peerTransport is how content is requested from peers.
+	peerTransport string
+
+	// lookupCache is a cache for storing the results of lookups.
+	// A key maps to either strPeerNotFound for a negative result, or the []PeerInfo of its providers for a positive result.
 	lookupCache *ristretto.Cache
 
-	// k8sClient is the k8s client.
+	// lookupMx serializes updates of the providers in the lookup cache.
+	lookupMx sync.Mutex
+
+	// markers holds the keys withdrawn by this host and the stale provider records of peers, until the records expire.
+	markers *markers
+
+	// metricsRecorder records lookup cache metrics.
+	metricsRecorder metrics.Metrics
+
+	// scores ranks peers by their observed performance and quarantines failing peers.
+	scores *scores
+
+	// zones orders peers by their zone relative to this host.
+	zones *zones
+
+	// k8sClient is the k8s client, it is nil when running outside of Kubernetes.
 	k8sClient *k8s.ClientSet
 
 	// active is a flag that indicates if this host is actively discovering content on the network.
 	active atomic.Bool
+
+	// families are the IP address families this host is reachable on, used to pick the best address of a peer.
+	families ipFamilies
 }
 
 var _ Router = &router{}
@@ -66,21 +131,70 @@ type ContentNotFoundError struct {
 	key string
 }
 
+// HostOptions configures the libp2p host of a router.
+type HostOptions struct {
+	// Addrs are the addresses the host listens on.
+	// Each address is an IPv4 or IPv6 host and port, such as 0.0.0.0:5003 or [::]:5003.
+	Addrs []string
+
+	// Key is the private key of the identity of the host, see LoadIdentity.
+	// If nil, a random identity is used.
+	Key crypto.PrivKey
+
+	// PSK is the pre-shared key of the private network of the host, see LoadPSK.
+	// If nil, the network is public.
+	PSK pnet.PSK
+
+	// Transports are the transports the host listens on and dials with, see ParseTransports.
+	// If empty, TCP is used.
+	Transports []string
+
+	// PeerTransport is how content is requested from peers, PeerTransportHTTPS or PeerTransportLibp2p.
+	// If empty, HTTPS is used.
+	PeerTransport string
+}
+
 // NewRouter creates a new Router.
-func NewRouter(ctx context.Context, clientset *k8s.ClientSet, hostAddr, peerRegistryPort string) (Router, error) {
+// The given bootstrapper provides the peers used to join the network, clientset may be nil outside of Kubernetes.
+// The host is configured by the given options.
+// The topology describes the zone of this host, peers in the same zone are preferred.
+func NewRouter(ctx context.Context, clientset *k8s.ClientSet, b bootstrap.Bootstrapper, o HostOptions, peerRegistryPort string, t Topology) (Router, error) {
 	log := zerolog.Ctx(ctx).With().Str("component", "router").Logger()
 
-	host, err := newHost(hostAddr)
+	var newNetwork func(host.Host, metrics.Metrics) (peernet.Network, error)
+	switch o.PeerTransport {
+	case "", PeerTransportHTTPS:
+		newNetwork = peernet.New
+	case PeerTransportLibp2p:
+		newNetwork = peernet.NewStreamNetwork
+	default:
+		return nil, fmt.Errorf("unknown peer transport: %s", o.PeerTransport)
+	}
+
+	host, err := newHost(ctx, o)
 	if err != nil {
 		return nil, fmt.Errorf("could not create host: %w", err)
 	}
 
+	if len(host.Addrs()) == 0 {
+		return nil, fmt.Errorf("host has no routable addresses")
+	}
+
+	if t.ForbidCrossZone && t.Zone == "" {
+		log.Error().Msg("cross zone peers are forbidden but the zone of this node is unknown, no peer will be used")
+	}
+
+	// Learn the zones of peers as soon as they connect, including bootstrap peers.
+	z := newZones(t)
+	z.serve(host)
+	if err = z.watch(ctx, host); err != nil {
+		return nil, fmt.Errorf("could not watch peer zones: %w", err)
+	}
+
 	self := fmt.Sprintf("%s/p2p/%s", host.Addrs()[0].String(), host.ID().String())
 	log.Debug().Str("id", self).Msg("starting p2p router")
 
-	leaderElection := election.New("peerd-leader-election", clientset)
-
-	err = leaderElection.RunOrDie(ctx, self)
+	err = b.Run(ctx, self)
 	if err != nil {
 		return nil, err
 	}
@@ -88,16 +202,10 @@ func NewRouter(ctx context.Context, clientset *k8s.ClientSet, hostAddr, peerRegi
 	// TODO avtakkar: reconsider the max record age for cached files. Or, ensure that the cached list is periodically advertised.
 	dhtOpts := []dht.Option{dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/peerd"), dht.DisableValues(), dht.MaxRecordAge(MaxRecordAge)}
 	bootstrapPeerOpt := dht.BootstrapPeersFunc(func() []peer.AddrInfo {
-		addr, err := leaderElection.Leader()
+		addrInfos, err := b.Peers(ctx)
 		if err != nil {
 			events.FromContext(ctx).Disconnected()
-			log.Error().Err(err).Msg("could not get leader")
-			return nil
-		}
-
-		addrInfo, err := peer.AddrInfoFromP2pAddr(addr)
-		if err != nil {
-			log.Error().Err(err).Msg("could not get leader addr info")
+			log.Error().Err(err).Msg("could not get bootstrap peers")
 			return nil
 		}
 
@@ -105,13 +213,17 @@ func NewRouter(ctx context.Context, clientset *k8s.ClientSet, hostAddr, peerRegi
 			events.FromContext(ctx).Connected()
 		}()
 
-		if addrInfo.ID == host.ID() {
-			log.Debug().Msg("bootstrapped as leader")
-			return nil
+		peers := []peer.AddrInfo{}
+		for _, addrInfo := range addrInfos {
+			if addrInfo.ID == host.ID() {
+				log.Debug().Msg("skipping self as bootstrap peer")
+				continue
+			}
+			peers = append(peers, addrInfo)
 		}
 
-		log.Debug().Str("leader", addrInfo.ID.String()).Msg("leader found")
-		return []peer.AddrInfo{*addrInfo}
+		log.Debug().Int("count", len(peers)).Msg("bootstrap peers found")
+		return peers
 	})
 
 	dhtOpts = append(dhtOpts, bootstrapPeerOpt)
@@ -129,7 +241,7 @@ func NewRouter(ctx context.Context, clientset *k8s.ClientSet, hostAddr, peerRegi
 		return nil, err
 	}
 
-	n, err := peernet.New(host)
+	n, err := newNetwork(host, metrics.FromContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -139,8 +251,15 @@ func NewRouter(ctx context.Context, clientset *k8s.ClientSet, hostAddr, peerRegi
 		p2pnet:           n,
 		host:             host,
 		content:          rd,
+		advertiser:       newAdvertiser(ctx, provideFunc(rd), metrics.FromContext(ctx)),
 		peerRegistryPort: peerRegistryPort,
+		peerTransport:    o.PeerTransport,
 		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  metrics.FromContext(ctx),
+		scores:           newScores(),
+		zones:            z,
+		families:         familiesOf(host.Addrs()),
 	}, nil
 }
 
@@ -156,54 +275,141 @@ func (r *router) Close() error {
 
 // ResolveWithNegativeCacheCallback is like Resolve but it also returns a function callback that can be used to cache that a key could not be resolved.
 func (r *router) ResolveWithNegativeCacheCallback(ctx context.Context, key string, allowSelf bool, count int) (<-chan PeerInfo, func(), error) {
-	if val, ok := r.lookupCache.Get(key); ok && val.(string) == strPeerNotFound {
-		// TODO avtakkar: currently only doing a negative cache, this could maybe become a positive cache as well.
-		return nil, nil, ContentNotFoundError{key: key, error: fmt.Errorf("(cached) peer not found for key")}
+	if val, ok := r.lookupCache.Get(key); ok {
+		if s, ok := val.(string); ok && s == strPeerNotFound {
+			r.metricsRecorder.RecordLookupCache(lookupCacheNegativeHit)
+			return nil, nil, ContentNotFoundError{key: key, error: fmt.Errorf("(cached) peer not found for key")}
+		}
 	}
 
 	peerCh, err := r.Resolve(ctx, key, allowSelf, count)
 	return peerCh, func() {
+		r.lookupMx.Lock()
+		defer r.lookupMx.Unlock()
 		r.lookupCache.SetWithTTL(key, strPeerNotFound, 1, negCacheTtl)
 	}, err
 }
 
 // Resolve resolves the given key to a peer address.
+// Providers found in the network are cached for a while, so that repeated lookups of the same key skip the DHT.
+// If fewer than count cached providers can be used, they are returned first, followed by the providers found in the network.
+// Providers are ranked by score, and quarantined peers are never returned.
+// Providers found in the network are buffered for a short while to be ranked, later ones are returned as they are found.
+// Peers in the same zone as this host are returned first, and peers in other zones only if allowed by the topology.
 func (r *router) Resolve(ctx context.Context, key string, allowSelf bool, count int) (<-chan PeerInfo, error) {
 	log := zerolog.Ctx(ctx).With().Str("selfId", r.host.ID().String()).Str("key", key).Logger()
+
+	cached := r.cachedProviders(key, allowSelf, count)
+	if len(cached) >= count {
+		r.metricsRecorder.RecordLookupCache(lookupCacheHit)
+
+		peersCh := make(chan PeerInfo, len(cached))
+		for _, p := range cached {
+			peersCh <- p
+		}
+		close(peersCh)
+
+		return peersCh, nil
+	}
+	r.metricsRecorder.RecordLookupCache(lookupCacheMiss)
+
 	contentId, err := createContentId(key)
 	if err != nil {
 		return nil, err
 	}
 
+	// Too few cached providers are left, so they are returned first and merged with the providers found in the network.
 	providersCh := r.content.FindProvidersAsync(ctx, contentId, count)
-	peersCh := make(chan PeerInfo, count)
+	peersCh := make(chan PeerInfo, len(cached)+count)
+	for _, p := range cached {
+		peersCh <- p
+	}
 
 	go func() {
-		for info := range providersCh {
-			if !allowSelf && info.ID == r.host.ID() {
-				continue
+		// Providers are buffered for a while to be ranked like cached providers,
+		// and peers that are not known to be in the same zone are held back for longer.
+		pending := []PeerInfo{}
+		rank := time.NewTimer(rankWindow)
+		defer rank.Stop()
+		release := time.NewTimer(r.zones.CrossZoneDelay)
+		defer release.Stop()
+		ranked, released := false, false
+
+		flush := func() {
+			r.scores.rank(pending)
+			r.zones.sort(pending)
+
+			held := []PeerInfo{}
+			for _, p := range pending {
+				if released || r.zones.tier(p.ID) == tierSameZone {
+					peersCh <- p
+				} else {
+					held = append(held, p)
+				}
 			}
+			pending = held
+		}
 
-			if len(info.Addrs) != 1 {
-				log.Debug().Msg("expected address list to only contain a single item")
-				continue
-			}
+		for {
+			select {
+			case <-rank.C:
+				ranked = true
+				flush()
+
+			case <-release.C:
+				ranked, released = true, true
+				flush()
+
+			case info, ok := <-providersCh:
+				if !ok {
+					released = true
+					flush()
+					return
+				}
 
-			v, err := info.Addrs[0].ValueForProtocol(multiaddr.P_IP4)
-			if err != nil {
-				log.Error().Err(err).Str("peer", info.Addrs[0].String()).Msg("could not get IPV4 address")
-				continue
-			}
+				if !allowSelf && info.ID == r.host.ID() {
+					continue
+				}
+
+				if slices.ContainsFunc(cached, func(p PeerInfo) bool { return p.ID == info.ID }) {
+					continue
+				}
 
-			// Combine peer with registry port to create mirror endpoint.
-			peersCh <- PeerInfo{info.ID, fmt.Sprintf("https://%s:%s", v, r.peerRegistryPort)}
+				if r.scores.quarantined(info.ID) {
+					log.Debug().Str("peer", info.ID.String()).Msg("skipping quarantined peer")
+					continue
+				}
 
-			if r.active.CompareAndSwap(false, true) {
-				er, err := events.NewRecorder(ctx, r.k8sClient)
-				if err != nil {
-					log.Error().Err(err).Msg("failed to create event recorder")
-				} else {
-					er.Active() // Report that p2p is active.
+				if r.stale(key, info.ID) {
+					log.Debug().Str("peer", info.ID.String()).Msg("skipping stale provider record")
+					continue
+				}
+
+				p, ok := r.peerInfo(info)
+				if !ok {
+					log.Debug().Str("peer", info.ID.String()).Msg("no usable address found for peer")
+					continue
+				}
+				r.cacheProvider(key, p)
+
+				if !r.zones.allowed(info.ID) {
+					log.Debug().Str("peer", info.ID.String()).Msg("skipping peer outside of zone")
+					r.learnZone(ctx, info.ID)
+					continue
+				}
+
+				pending = append(pending, p)
+				if ranked {
+					flush()
+				}
+
+				if r.k8sClient != nil && r.active.CompareAndSwap(false, true) {
+					er, err := events.NewRecorder(ctx, r.k8sClient)
+					if err != nil {
+						log.Error().Err(err).Msg("failed to create event recorder")
+					} else {
+						er.Active() // Report that p2p is active.
+					}
 				}
 			}
 		}
@@ -212,25 +418,213 @@ func (r *router) Resolve(ctx context.Context, key string, allowSelf bool, count
 	return peersCh, nil
 }
 
+// peerInfo returns the endpoint to request content from the given peer, or false if it has no usable address.
+func (r *router) peerInfo(info peer.AddrInfo) (PeerInfo, bool) {
+	if r.peerTransport == PeerTransportLibp2p {
+		// Streams are opened to the addresses of the provider record.
+		r.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+		return PeerInfo{info.ID, peernet.StreamURL(info.ID)}, true
+	}
+
+	ip := r.families.bestIP(info.Addrs)
+	if ip == nil {
+		return PeerInfo{}, false
+	}
+
+	// Combine peer with registry port to create mirror endpoint, IPv6 addresses are bracketed.
+	return PeerInfo{info.ID, "https://" + net.JoinHostPort(ip.String(), r.peerRegistryPort)}, true
+}
+
+// learnZone learns the zone of the given peer in the background, so that it can be used once its zone is known.
+func (r *router) learnZone(ctx context.Context, id peer.ID) {
+	if r.zones.known(id) {
+		return
+	}
+
+	go func() {
+		if err := r.zones.learn(context.WithoutCancel(ctx), r.host, id); err != nil {
+			zerolog.Ctx(ctx).Debug().Err(err).Str("peer", id.String()).Msg("could not learn zone of peer")
+		}
+	}()
+}
+
+// RecordPeerResponse records the outcome of a request to a peer.
+func (r *router) RecordPeerResponse(id peer.ID, duration time.Duration, count int64, err error) {
+	r.scores.record(id, duration, count, err)
+}
+
+// Block stops returning the given peer from any resolution for a while.
+func (r *router) Block(id peer.ID) {
+	r.scores.block(id)
+}
+
+// Trusted returns true if the given peer is known to this host and is not quarantined.
+func (r *router) Trusted(id peer.ID) bool {
+	if id == r.host.ID() {
+		return true
+	}
+
+	return !r.scores.quarantined(id) && len(r.host.Peerstore().Addrs(id)) > 0
+}
+
+// Identity returns the private key of the identity of this host.
+func (r *router) Identity() crypto.PrivKey {
+	return r.host.Peerstore().PrivKey(r.host.ID())
+}
+
+// Invalidate removes the given peer from the cached providers of the key.
+func (r *router) Invalidate(key string, id peer.ID) {
+	r.lookupMx.Lock()
+	defer r.lookupMx.Unlock()
+
+	val, ok := r.lookupCache.Get(key)
+	if !ok {
+		return
+	}
+
+	cached, ok := val.([]PeerInfo)
+	if !ok {
+		return
+	}
+
+	remaining := []PeerInfo{}
+	for _, p := range cached {
+		if p.ID != id {
+			remaining = append(remaining, p)
+		}
+	}
+
+	if len(remaining) == len(cached) {
+		return
+	}
+
+	ttl, ok := r.lookupCache.GetTTL(key)
+	if len(remaining) == 0 || !ok || ttl <= 0 {
+		r.lookupCache.Del(key)
+	} else {
+		r.lookupCache.SetWithTTL(key, remaining, 1, ttl)
+	}
+	r.lookupCache.Wait()
+}
+
+// cachedProviders returns up to count of the best cached providers of the given key.
+func (r *router) cachedProviders(key string, allowSelf bool, count int) []PeerInfo {
+	val, ok := r.lookupCache.Get(key)
+	if !ok {
+		return nil
+	}
+
+	cached, ok := val.([]PeerInfo)
+	if !ok {
+		return nil
+	}
+
+	peers := []PeerInfo{}
+	for _, p := range cached {
+		if !allowSelf && p.ID == r.host.ID() {
+			continue
+		}
+
+		if r.scores.quarantined(p.ID) || !r.zones.allowed(p.ID) || r.stale(key, p.ID) {
+			continue
+		}
+
+		peers = append(peers, p)
+	}
+
+	r.scores.rank(peers)
+	r.zones.sort(peers)
+	if len(peers) > count {
+		peers = peers[:count]
+	}
+
+	return peers
+}
+
+// cacheProvider adds the given provider to the cached providers of the key.
+func (r *router) cacheProvider(key string, p PeerInfo) {
+	r.lookupMx.Lock()
+	defer r.lookupMx.Unlock()
+
+	peers := []PeerInfo{}
+	if val, ok := r.lookupCache.Get(key); ok {
+		if cached, ok := val.([]PeerInfo); ok {
+			peers = append(peers, cached...)
+		}
+	}
+
+	for _, c := range peers {
+		if c.ID == p.ID {
+			return
+		}
+	}
+
+	r.lookupCache.SetWithTTL(key, append(peers, p), 1, posCacheTtl)
+	r.lookupCache.Wait()
+}
+
 // Provide advertises the given keys to the network.
+// Keys are announced in parallel at a limited rate, and keys announced recently are skipped.
 func (r *router) Provide(ctx context.Context, keys []string) error {
 	zerolog.Ctx(ctx).Trace().Str("host", r.host.ID().String()).Strs("keys", keys).Msg("providing keys")
 	for _, key := range keys {
+		// The content is available again.
+		r.markers.del(withdrawnKey(key))
+	}
 
-		contentId, err := createContentId(key)
-		if err != nil {
-			return err
-		}
+	return r.advertiser.advertise(ctx, keys)
+}
 
-		err = r.content.Provide(ctx, contentId, true)
-		if err != nil {
-			return err
-		}
+// provideFunc returns a function that announces content ids with the given content discovery service.
+func provideFunc(rd *routing.RoutingDiscovery) func(ctx context.Context, c cid.Cid) error {
+	return func(ctx context.Context, c cid.Cid) error {
+		return rd.Provide(ctx, c, true)
+	}
+}
+
+// Withdraw stops advertising the given keys from this host.
+// Provider records cannot be removed from the network, so the keys are marked as withdrawn until the records expire.
+func (r *router) Withdraw(ctx context.Context, keys []string) error {
+	zerolog.Ctx(ctx).Trace().Str("host", r.host.ID().String()).Strs("keys", keys).Msg("withdrawing keys")
+	for _, key := range keys {
+		r.markers.set(withdrawnKey(key), MaxRecordAge)
+		r.Invalidate(key, r.host.ID())
+		r.advertiser.forget(key)
 	}
 
 	return nil
 }
 
+// Withdrawn returns true if the given key was withdrawn by this host.
+func (r *router) Withdrawn(key string) bool {
+	return r.markers.has(withdrawnKey(key))
+}
+
+// Forget ignores the provider record of the given peer for the key until the record expires.
+func (r *router) Forget(key string, id peer.ID) {
+	r.Invalidate(key, id)
+	r.markers.set(staleKey(key, id), MaxRecordAge)
+}
+
+// stale returns true if the provider record of the given peer for the key should be ignored.
+func (r *router) stale(key string, id peer.ID) bool {
+	if id == r.host.ID() {
+		return r.Withdrawn(key)
+	}
+
+	return r.markers.has(staleKey(key, id))
+}
+
+// withdrawnKey returns the key of the marker of the given key when it is withdrawn by this host.
+func withdrawnKey(key string) string {
+	return "withdrawn/" + key
+}
+
+// staleKey returns the key of the marker of the stale provider record of the given peer for the key.
+func staleKey(key string, id peer.ID) string {
+	return "stale/" + id.String() + "/" + key
+}
+
 // createContentId creates a deterministic content id from the given key.
 func createContentId(key string) (cid.Cid, error) {
 	pref := cid.Prefix{
@@ -246,34 +640,187 @@ func createContentId(key string) (cid.Cid, error) {
 	return c, nil
 }
 
-// newHost creates a new Host from the given address.
-func newHost(addr string) (host.Host, error) {
+// newHost creates a new Host with the given options.
+// In a private network, inbound connections that are not secured in time are logged and recorded as events.
+func newHost(ctx context.Context, o HostOptions) (host.Host, error) {
+	if len(o.Addrs) == 0 {
+		return nil, fmt.Errorf("at least one host address is required")
+	}
+
+	transports := o.Transports
+	if len(transports) == 0 {
+		transports = []string{TransportTCP}
+	}
+
+	opts := []libp2p.Option{}
+	listenAddrs := []multiaddr.Multiaddr{}
+	for _, transport := range transports {
+		switch transport {
+		case TransportTCP:
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+		case TransportQUIC:
+			if o.PSK != nil {
+				return nil, fmt.Errorf("the %s transport cannot be used in a private network", TransportQUIC)
+			}
+			opts = append(opts, libp2p.Transport(quic.NewTransport))
+		default:
+			return nil, fmt.Errorf("unknown transport: %s", transport)
+		}
+
+		for _, addr := range o.Addrs {
+			hostAddr, err := toMultiaddr(addr, transport)
+			if err != nil {
+				return nil, err
+			}
+			listenAddrs = append(listenAddrs, hostAddr)
+		}
+	}
+
+	factory := libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		advertised := []multiaddr.Multiaddr{}
+		for _, addr := range addrs {
+			if usableIP(addr) != nil {
+				advertised = append(advertised, addr)
+			}
+		}
+		return advertised
+	})
+
+	opts = append(opts, libp2p.ListenAddrs(listenAddrs...), factory)
+	if o.Key != nil {
+		opts = append(opts, libp2p.Identity(o.Key))
+	}
+
+	if o.PSK != nil {
+		opts = append(opts, libp2p.PrivateNetwork(o.PSK), libp2p.ConnectionGater(newHandshakeGater(ctx)))
+	}
+
+	return libp2p.New(opts...)
+}
+
+// toMultiaddr converts the given host and port to a multiaddress of the given transport.
+func toMultiaddr(addr, transport string) (multiaddr.Multiaddr, error) {
 	h, p, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	hostAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%s", h, p))
+	ip := net.ParseIP(h)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid host IP: %s", h)
+	}
+
+	proto := "ip4"
+	if ip.To4() == nil {
+		proto = "ip6"
+	}
+
+	suffix := "tcp/" + p
+	if transport == TransportQUIC {
+		suffix = "udp/" + p + "/quic-v1"
+	}
+
+	hostAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/%s/%s/%s", proto, ip.String(), suffix))
 	if err != nil {
 		return nil, fmt.Errorf("could not create host multi address: %w", err)
 	}
 
-	factory := libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
-		for _, addr := range addrs {
-			v, err := addr.ValueForProtocol(multiaddr.P_IP4)
-			if err != nil {
-				continue
-			}
-			if v == "" {
-				continue
-			}
-			if v == "127.0.0.1" {
-				continue
+	return hostAddr, nil
+}
+
+// ParseTransports parses a comma separated list of transports, such as "tcp,quic".
+func ParseTransports(s string) ([]string, error) {
+	transports := []string{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		switch t {
+		case "":
+			continue
+		case TransportTCP, TransportQUIC:
+			if !slices.Contains(transports, t) {
+				transports = append(transports, t)
 			}
-			return []multiaddr.Multiaddr{addr}
+		default:
+			return nil, fmt.Errorf("unknown transport: %s", t)
 		}
+	}
+
+	if len(transports) == 0 {
+		return nil, fmt.Errorf("at least one transport is required")
+	}
+
+	return transports, nil
+}
+
+// usableIP returns the IP of the given address if it can be used to reach a peer, or nil otherwise.
+// Loopback, unspecified and link-local addresses are not usable.
+func usableIP(addr multiaddr.Multiaddr) net.IP {
+	ip, err := manet.ToIP(addr)
+	if err != nil {
 		return nil
-	})
+	}
+
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	return ip
+}
+
+// ipFamilies describes the IP address families a host is reachable on.
+type ipFamilies struct {
+	ip4 bool
+	ip6 bool
+}
+
+// familiesOf returns the IP address families of the given addresses.
+func familiesOf(addrs []multiaddr.Multiaddr) ipFamilies {
+	f := ipFamilies{}
+	for _, addr := range addrs {
+		ip := usableIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		if ip.To4() != nil {
+			f.ip4 = true
+		} else {
+			f.ip6 = true
+		}
+	}
+	return f
+}
+
+// supports returns true if the given IP is of a family this host is reachable on.
+// If no family is known, all families are supported.
+func (f ipFamilies) supports(ip net.IP) bool {
+	if !f.ip4 && !f.ip6 {
+		return true
+	}
+
+	if ip.To4() != nil {
+		return f.ip4
+	}
+	return f.ip6
+}
+
+// bestIP returns the best IP to reach a peer with the given addresses, or nil if none is usable.
+// IPs of a family this host is also reachable on are preferred, otherwise the order of the addresses is kept.
+func (f ipFamilies) bestIP(addrs []multiaddr.Multiaddr) net.IP {
+	var fallback net.IP
+	for _, addr := range addrs {
+		ip := usableIP(addr)
+		if ip == nil {
+			continue
+		}
 
-	return libp2p.New(libp2p.ListenAddrs(hostAddr), factory)
+		if f.supports(ip) {
+			return ip
+		}
+
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	return fallback
 }
@@ -5,11 +5,13 @@ package routing
 import (
 	"context"
 	"errors"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/azure/peerd/pkg/k8s"
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/dgraph-io/ristretto"
 	cid "github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/connmgr"
@@ -22,10 +24,15 @@ import (
 	corerouting "github.com/libp2p/go-libp2p/core/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	multiaddr "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
-var fakeClientset = k8s.ClientSet{Interface: fake.NewSimpleClientset(), InPod: true}
+var (
+	fakeClientset = k8s.ClientSet{Interface: fake.NewSimpleClientset(), InPod: true}
+	mr            = metrics.NewPromMetrics(prometheus.NewRegistry(), "test", "test")
+)
 
 func TestResolveWithCache(t *testing.T) {
 	c, err := ristretto.NewCache(&ristretto.Config{
@@ -49,6 +56,10 @@ func TestResolveWithCache(t *testing.T) {
 		host:             h,
 		peerRegistryPort: "5000",
 		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
 		content:          routing.NewRoutingDiscovery(tcr),
 	}
 
@@ -66,6 +77,141 @@ func TestResolveWithCache(t *testing.T) {
 	}
 }
 
+func TestResolveWithPositiveCache(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "some-key"
+	contentId, err := createContentId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcr := &testCr{
+		m: map[string][]string{
+			contentId.String(): {"10.0.0.1", "10.0.0.2"},
+		},
+	}
+
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             &testHost{"host-id"},
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
+		content:          routing.NewRoutingDiscovery(tcr),
+	}
+
+	ctx := context.Background()
+	got, err := r.Resolve(ctx, key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-got
+	<-got
+
+	if tcr.lookups != 1 {
+		t.Fatalf("expected 1 lookup, got %d", tcr.lookups)
+	}
+
+	// Second resolution is served from the cache.
+	got, err = r.Resolve(ctx, key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached := []PeerInfo{}
+	for info := range got {
+		cached = append(cached, info)
+	}
+
+	if tcr.lookups != 1 {
+		t.Errorf("expected cached resolution to skip lookup, got %d lookups", tcr.lookups)
+	}
+
+	if len(cached) != 2 {
+		t.Fatalf("expected 2 cached peers, got %d", len(cached))
+	}
+
+	// Count is respected.
+	got, err = r.Resolve(ctx, key, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 {
+		t.Errorf("expected 1 cached peer, got %d", len(got))
+	}
+
+	// A failing peer is removed from the cache.
+	r.Invalidate(key, cached[0].ID)
+
+	got, err = r.Resolve(ctx, key, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := <-got; info.ID != cached[1].ID {
+		t.Errorf("expected peer %s, got %s", cached[1].ID, info.ID)
+	}
+
+	if tcr.lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", tcr.lookups)
+	}
+
+	// With too few cached peers left, the network is queried again and its providers follow the cached ones.
+	got, err = r.Resolve(ctx, key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := <-got; info.ID != cached[1].ID {
+		t.Errorf("expected peer %s, got %s", cached[1].ID, info.ID)
+	}
+
+	select {
+	case info := <-got:
+		if info.ID != cached[0].ID {
+			t.Errorf("expected peer %s, got %s", cached[0].ID, info.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for provider found in the network")
+	}
+
+	select {
+	case info := <-got:
+		t.Errorf("expected cached peer not to be returned twice, got %s", info.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if tcr.lookups != 2 {
+		t.Errorf("expected 2 lookups, got %d", tcr.lookups)
+	}
+
+	// Once all peers are invalidated, the network is queried again.
+	r.Invalidate(key, cached[0].ID)
+	r.Invalidate(key, cached[1].ID)
+
+	got, err = r.Resolve(ctx, key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-got
+
+	if tcr.lookups != 3 {
+		t.Errorf("expected 3 lookups, got %d", tcr.lookups)
+	}
+}
+
 func TestResolve(t *testing.T) {
 	c, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,
@@ -88,6 +234,10 @@ func TestResolve(t *testing.T) {
 		host:             h,
 		peerRegistryPort: "5000",
 		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
 		content: routing.NewRoutingDiscovery(&testCr{
 			m: map[string][]string{
 				contentId.String(): {"10.0.0.1", "10.0.0.2"},
@@ -119,6 +269,64 @@ func TestResolve(t *testing.T) {
 	}
 }
 
+func TestResolveRanksProviders(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "some-key"
+	contentId, err := createContentId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             &testHost{"host-id"},
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{Zone: "eastus-1"}),
+		content: routing.NewRoutingDiscovery(&testCr{
+			m: map[string][]string{
+				contentId.String(): {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			},
+		}),
+	}
+
+	// The slowest peer is found first, and the peer in another zone is faster than the rest.
+	r.scores.record("10.0.0.1", time.Second, 1000, nil)
+	r.scores.record("10.0.0.2", time.Second, 1000000, nil)
+	r.scores.record("10.0.0.3", time.Second, 10000000, nil)
+	r.zones.set("10.0.0.1", "eastus-1")
+	r.zones.set("10.0.0.2", "eastus-1")
+	r.zones.set("10.0.0.3", "eastus-2")
+
+	got, err := r.Resolve(context.Background(), key, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"10.0.0.2", "10.0.0.1", "10.0.0.3"}
+	for i, id := range expected {
+		select {
+		case info := <-got:
+			if info.ID != peer.ID(id) {
+				t.Errorf("expected peer %s at position %d, got %s", id, i, info.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for peer %s", id)
+		}
+	}
+}
+
 func TestProvide(t *testing.T) {
 	c, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: 1e7,
@@ -139,15 +347,29 @@ func TestProvide(t *testing.T) {
 		m: map[string][]string{},
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rd := routing.NewRoutingDiscovery(tcr)
 	r := &router{
 		k8sClient:        &fakeClientset,
 		host:             h,
 		peerRegistryPort: "5000",
 		lookupCache:      c,
-		content:          routing.NewRoutingDiscovery(tcr),
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
+		content:          rd,
+		advertiser:       newAdvertiser(ctx, provideFunc(rd), mr),
 	}
 
-	ctx := context.Background()
+	err = r.Provide(ctx, []string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A recently provided key is not announced again.
 	err = r.Provide(ctx, []string{key})
 	if err != nil {
 		t.Fatal(err)
@@ -160,6 +382,97 @@ func TestProvide(t *testing.T) {
 	}
 }
 
+func TestWithdrawAndForget(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "some-key"
+	contentId, err := createContentId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rd := routing.NewRoutingDiscovery(&testCr{
+		m: map[string][]string{
+			contentId.String(): {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+	})
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             &testHost{"10.0.0.3"},
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
+		content:          rd,
+		advertiser:       newAdvertiser(ctx, provideFunc(rd), mr),
+	}
+
+	resolve := func() []peer.ID {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		got, err := r.Resolve(ctx, key, true, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []peer.ID{}
+		for {
+			select {
+			case <-ctx.Done():
+				slices.Sort(ids)
+				return ids
+			case info, ok := <-got:
+				if !ok {
+					slices.Sort(ids)
+					return ids
+				}
+				ids = append(ids, info.ID)
+			}
+		}
+	}
+
+	if err := r.Withdraw(ctx, []string{key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Withdrawn(key) {
+		t.Fatal("expected key to be withdrawn")
+	}
+
+	// The withdrawn record of this host is ignored.
+	if got := resolve(); !slices.Equal(got, []peer.ID{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("expected withdrawn host to be skipped, got %v", got)
+	}
+
+	// A peer without the content is ignored.
+	r.Forget(key, "10.0.0.1")
+	if got := resolve(); !slices.Equal(got, []peer.ID{"10.0.0.2"}) {
+		t.Errorf("expected forgotten peer to be skipped, got %v", got)
+	}
+
+	// Providing the key again clears the withdrawal.
+	if err := r.Provide(ctx, []string{key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Withdrawn(key) {
+		t.Error("expected key not to be withdrawn after providing it")
+	}
+}
+
 func TestNewHost(t *testing.T) {
 	for _, tc := range []struct {
 		name         string
@@ -182,7 +495,7 @@ func TestNewHost(t *testing.T) {
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			h, err := newHost(tc.addr)
+			h, err := newHost(context.Background(), HostOptions{Addrs: []string{tc.addr}})
 			if tc.expectedErr && err == nil {
 				t.Fatalf("expected error, got nil")
 			}
@@ -199,28 +512,310 @@ func TestNewHost(t *testing.T) {
 				t.Fatal("expected host to be non-nil")
 			}
 
-			if len(h.Addrs()) != 1 {
-				t.Fatalf("expected 1 address, got %d", len(h.Addrs()))
+			if len(h.Addrs()) == 0 {
+				t.Fatal("expected at least 1 address")
 			}
 
-			if !strings.HasSuffix(h.Addrs()[0].String(), "/tcp/"+tc.expectedPort) {
-				t.Fatalf("expected address to end with /tcp/%s, got %s", tc.expectedPort, h.Addrs()[0].String())
+			for _, addr := range h.Addrs() {
+				if !strings.HasSuffix(addr.String(), "/tcp/"+tc.expectedPort) {
+					t.Fatalf("expected address to end with /tcp/%s, got %s", tc.expectedPort, addr.String())
+				}
 			}
 		})
 	}
 }
 
+func TestResolveIPv6(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "some-key"
+	contentId, err := createContentId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             &testHost{"host-id"},
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            newZones(Topology{}),
+		content: routing.NewRoutingDiscovery(&testCr{
+			m: map[string][]string{
+				contentId.String(): {"fd00::1"},
+			},
+		}),
+		families: ipFamilies{ip6: true},
+	}
+
+	got, err := r.Resolve(context.Background(), key, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := <-got
+	if info.HttpHost != "https://[fd00::1]:5000" {
+		t.Errorf("expected https://[fd00::1]:5000, got %s", info.HttpHost)
+	}
+}
+
+func TestPeerInfo(t *testing.T) {
+	h, err := newHost(context.Background(), HostOptions{Addrs: []string{"127.0.0.1:0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	id, err := peer.Decode("12D3KooWJFz4wmVm4DW8p8fjcGqzMyV2ZfzToCrXbSbhm2vpnh3i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/10.0.0.1/tcp/5003")}}
+
+	for _, tc := range []struct {
+		transport string
+		expected  string
+	}{
+		{transport: "", expected: "https://10.0.0.1:5001"},
+		{transport: PeerTransportHTTPS, expected: "https://10.0.0.1:5001"},
+		{transport: PeerTransportLibp2p, expected: "http://" + id.String()},
+	} {
+		r := &router{host: h, peerRegistryPort: "5001", peerTransport: tc.transport, families: ipFamilies{ip4: true}}
+
+		p, ok := r.peerInfo(info)
+		if !ok {
+			t.Fatalf("%q: expected peer info", tc.transport)
+		}
+
+		if p.ID != id || p.HttpHost != tc.expected {
+			t.Errorf("%q: expected %s at %s, got %s at %s", tc.transport, id, tc.expected, p.ID, p.HttpHost)
+		}
+	}
+
+	// Streams are opened to the router address of the peer.
+	if addrs := h.Peerstore().Addrs(id); len(addrs) != 1 || !addrs[0].Equal(info.Addrs[0]) {
+		t.Errorf("expected router address of peer to be known, got %v", addrs)
+	}
+
+	// Only requests over https need a usable IP.
+	local := peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/5003")}}
+	if _, ok := (&router{host: h, peerTransport: PeerTransportHTTPS}).peerInfo(local); ok {
+		t.Error("expected loopback address to be unusable over https")
+	}
+}
+
+func TestBestIP(t *testing.T) {
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/5003"),
+		multiaddr.StringCast("/ip6/fe80::1/tcp/5003"),
+		multiaddr.StringCast("/ip4/10.0.0.1/tcp/5003"),
+		multiaddr.StringCast("/ip6/fd00::1/tcp/5003"),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		families ipFamilies
+		addrs    []multiaddr.Multiaddr
+		expected string
+	}{
+		{
+			name:     "unknown families keeps order",
+			families: ipFamilies{},
+			addrs:    addrs,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "ipv4 only",
+			families: ipFamilies{ip4: true},
+			addrs:    addrs,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "ipv6 only",
+			families: ipFamilies{ip6: true},
+			addrs:    addrs,
+			expected: "fd00::1",
+		},
+		{
+			name:     "dual stack keeps order",
+			families: ipFamilies{ip4: true, ip6: true},
+			addrs:    addrs,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "falls back to other family",
+			families: ipFamilies{ip6: true},
+			addrs:    addrs[:3],
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "no usable address",
+			families: ipFamilies{ip4: true},
+			addrs:    addrs[:2],
+			expected: "<nil>",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.families.bestIP(tc.addrs).String(); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestToMultiaddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr        string
+		transport   string
+		expected    string
+		expectedErr bool
+	}{
+		{addr: "0.0.0.0:5003", transport: TransportTCP, expected: "/ip4/0.0.0.0/tcp/5003"},
+		{addr: "[::]:5003", transport: TransportTCP, expected: "/ip6/::/tcp/5003"},
+		{addr: "[fd00::1]:5003", transport: TransportTCP, expected: "/ip6/fd00::1/tcp/5003"},
+		{addr: "0.0.0.0:5003", transport: TransportQUIC, expected: "/ip4/0.0.0.0/udp/5003/quic-v1"},
+		{addr: "[::]:5003", transport: TransportQUIC, expected: "/ip6/::/udp/5003/quic-v1"},
+		{addr: "localhost:5003", transport: TransportTCP, expectedErr: true},
+		{addr: "invalidaddress", transport: TransportTCP, expectedErr: true},
+	} {
+		t.Run(tc.transport+"/"+tc.addr, func(t *testing.T) {
+			got, err := toMultiaddr(tc.addr, tc.transport)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got.String())
+			}
+		})
+	}
+}
+
+func TestParseTransports(t *testing.T) {
+	for _, tc := range []struct {
+		s           string
+		expected    []string
+		expectedErr bool
+	}{
+		{s: "tcp", expected: []string{TransportTCP}},
+		{s: "quic", expected: []string{TransportQUIC}},
+		{s: "tcp, quic,tcp", expected: []string{TransportTCP, TransportQUIC}},
+		{s: "", expectedErr: true},
+		{s: "tcp,udp", expectedErr: true},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := ParseTransports(tc.s)
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewHostQUIC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h1, err := newHost(ctx, HostOptions{Addrs: []string{"127.0.0.1:0"}, Transports: []string{TransportQUIC}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+
+	// Listen addresses also include the relay address.
+	for _, addr := range ipAddrs(h1.Network().ListenAddresses()) {
+		if !strings.HasSuffix(addr.String(), "/quic-v1") {
+			t.Errorf("expected only QUIC addresses, got %s", addr)
+		}
+	}
+
+	h2, err := newHost(ctx, HostOptions{Addrs: []string{"127.0.0.1:0"}, Transports: []string{TransportTCP, TransportQUIC}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	tcpAddrs, quicAddrs := 0, 0
+	for _, addr := range ipAddrs(h2.Network().ListenAddresses()) {
+		if strings.HasSuffix(addr.String(), "/quic-v1") {
+			quicAddrs++
+		} else {
+			tcpAddrs++
+		}
+	}
+
+	if tcpAddrs != 1 || quicAddrs != 1 {
+		t.Errorf("expected 1 TCP and 1 QUIC address, got %d and %d", tcpAddrs, quicAddrs)
+	}
+
+	// QUIC cannot be used in a private network.
+	psk := make([]byte, 32)
+	if _, err := newHost(ctx, HostOptions{Addrs: []string{"127.0.0.1:0"}, PSK: psk, Transports: []string{TransportQUIC}}); err == nil {
+		t.Error("expected error for QUIC in a private network")
+	}
+
+	if _, err := newHost(ctx, HostOptions{Addrs: []string{"127.0.0.1:0"}, Transports: []string{"udp"}}); err == nil {
+		t.Error("expected error for unknown transport")
+	}
+}
+
+// ipAddrs returns the IP addresses of the given addresses.
+func ipAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	ips := []multiaddr.Multiaddr{}
+	for _, addr := range addrs {
+		if _, err := manet.ToIP(addr); err == nil {
+			ips = append(ips, addr)
+		}
+	}
+	return ips
+}
+
 type testCr struct {
 	m        map[string][]string
 	provided []cid.Cid
+	lookups  int
 }
 
 // FindProvidersAsync implements routing.ContentRouting.
 func (t *testCr) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	t.lookups++
 	ch := make(chan peer.AddrInfo, count)
 	if val, ok := t.m[c.String()]; ok {
 		for _, addr := range val {
-			ch <- peer.AddrInfo{ID: peer.ID(addr), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/" + addr + "/tcp/5005")}}
+			proto := "/ip4/"
+			if strings.Contains(addr, ":") {
+				proto = "/ip6/"
+			}
+			ch <- peer.AddrInfo{ID: peer.ID(addr), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast(proto + addr + "/tcp/5005")}}
 		}
 	}
 	return ch
@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// scoreAlpha is the weight of the latest sample in the moving averages of a peer.
+	scoreAlpha = 0.3
+
+	// quarantineThreshold is the number of consecutive failures after which a peer is quarantined.
+	quarantineThreshold = 3
+
+	// quarantineDuration is how long a peer stays in quarantine.
+	quarantineDuration = 1 * time.Minute
+
+	// blockDuration is how long a peer that served corrupt content is not used.
+	blockDuration = 1 * time.Hour
+
+	// scoreTtl is how long the score of a peer is kept after its last response.
+	scoreTtl = 10 * time.Minute
+)
+
+// peerScore describes the observed performance of a peer.
+type peerScore struct {
+	// latency is the moving average of the response time.
+	latency time.Duration
+
+	// throughput is the moving average of the transfer speed in bytes per second.
+	throughput float64
+
+	// errorRate is the moving average of failed responses, between 0 and 1.
+	errorRate float64
+
+	// consecutiveFailures is the number of failures since the last success.
+	consecutiveFailures int
+
+	// quarantinedUntil is the time until which the peer should not be used.
+	quarantinedUntil time.Time
+
+	// lastSeen is the time of the last response.
+	lastSeen time.Time
+}
+
+// value returns the score used to rank the peer, higher is better.
+func (s *peerScore) value() float64 {
+	return s.throughput * (1 - s.errorRate)
+}
+
+// scores keeps track of the performance of peers to rank and quarantine them.
+type scores struct {
+	mx     sync.Mutex
+	peers  map[peer.ID]*peerScore
+	now    func() time.Time
+	pruned time.Time
+}
+
+// newScores creates a new peer score tracker.
+func newScores() *scores {
+	return &scores{
+		peers: map[peer.ID]*peerScore{},
+		now:   time.Now,
+	}
+}
+
+// record records the response of a peer.
+// A nil err records a success that transferred count bytes in the given duration.
+func (s *scores) record(id peer.ID, duration time.Duration, count int64, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	ps, ok := s.peers[id]
+	if !ok {
+		ps = &peerScore{latency: duration}
+		s.peers[id] = ps
+	}
+	ps.lastSeen = now
+
+	if err != nil {
+		ps.errorRate = ewma(ps.errorRate, 1, ok)
+		ps.consecutiveFailures++
+		if ps.consecutiveFailures >= quarantineThreshold {
+			// Do not shorten a block.
+			if until := now.Add(quarantineDuration); until.After(ps.quarantinedUntil) {
+				ps.quarantinedUntil = until
+			}
+			ps.consecutiveFailures = 0
+		}
+		return
+	}
+
+	ps.errorRate = ewma(ps.errorRate, 0, ok)
+	ps.consecutiveFailures = 0
+	ps.latency = time.Duration(ewma(float64(ps.latency), float64(duration), ok))
+
+	if count > 0 && duration > 0 {
+		bps := float64(count) / duration.Seconds()
+		ps.throughput = ewma(ps.throughput, bps, ps.throughput > 0)
+	}
+}
+
+// block quarantines the peer for blockDuration, regardless of its responses.
+func (s *scores) block(id peer.ID) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := s.now()
+	ps, ok := s.peers[id]
+	if !ok {
+		ps = &peerScore{}
+		s.peers[id] = ps
+	}
+	ps.lastSeen = now
+	ps.quarantinedUntil = now.Add(blockDuration)
+}
+
+// quarantined returns true if the peer should not be used for now.
+func (s *scores) quarantined(id peer.ID) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	ps, ok := s.peers[id]
+	return ok && s.now().Before(ps.quarantinedUntil)
+}
+
+// rank sorts the given peers from best to worst score.
+// Peers without a score are ranked as the average known peer, so that new peers are still tried.
+func (s *scores) rank(peers []PeerInfo) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	values := make(map[peer.ID]float64, len(peers))
+	sum, known := 0.0, 0
+	for _, p := range peers {
+		if ps, ok := s.peers[p.ID]; ok {
+			values[p.ID] = ps.value()
+			sum += ps.value()
+			known++
+		}
+	}
+
+	if known == 0 {
+		return
+	}
+
+	avg := sum / float64(known)
+	for _, p := range peers {
+		if _, ok := values[p.ID]; !ok {
+			values[p.ID] = avg
+		}
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		return values[peers[i].ID] > values[peers[j].ID]
+	})
+}
+
+// prune removes the scores of peers that have not responded for a while, unless they are quarantined.
+func (s *scores) prune(now time.Time) {
+	if now.Sub(s.pruned) < scoreTtl {
+		return
+	}
+	s.pruned = now
+
+	for id, ps := range s.peers {
+		if now.Sub(ps.lastSeen) > scoreTtl && !now.Before(ps.quarantinedUntil) {
+			delete(s.peers, id)
+		}
+	}
+}
+
+// ewma updates the moving average with the given sample, or returns the sample if there is no average yet.
+func ewma(avg, sample float64, initialized bool) float64 {
+	if !initialized {
+		return sample
+	}
+	return scoreAlpha*sample + (1-scoreAlpha)*avg
+}
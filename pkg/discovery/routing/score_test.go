@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestScoresRank(t *testing.T) {
+	s := newScores()
+
+	s.record("fast", 100*time.Millisecond, 1024*1024, nil)
+	s.record("slow", 1*time.Second, 1024*1024, nil)
+	s.record("flaky", 100*time.Millisecond, 1024*1024, nil)
+	s.record("flaky", 100*time.Millisecond, 0, errors.New("failed"))
+
+	peers := []PeerInfo{{ID: "slow"}, {ID: "unknown"}, {ID: "flaky"}, {ID: "fast"}}
+	s.rank(peers)
+
+	expected := []peer.ID{"fast", "flaky", "unknown", "slow"}
+	for i, p := range peers {
+		if p.ID != expected[i] {
+			t.Errorf("expected peer %s at position %d, got %s", expected[i], i, p.ID)
+		}
+	}
+}
+
+func TestScoresRankUnknown(t *testing.T) {
+	s := newScores()
+
+	peers := []PeerInfo{{ID: "b"}, {ID: "a"}}
+	s.rank(peers)
+
+	if peers[0].ID != "b" || peers[1].ID != "a" {
+		t.Errorf("expected order to be kept for unknown peers, got %v", peers)
+	}
+}
+
+func TestScoresQuarantine(t *testing.T) {
+	now := time.Now()
+	s := newScores()
+	s.now = func() time.Time { return now }
+
+	id := peer.ID("peer")
+	for i := 0; i < quarantineThreshold-1; i++ {
+		s.record(id, time.Millisecond, 0, errors.New("failed"))
+	}
+
+	if s.quarantined(id) {
+		t.Fatal("expected peer not to be quarantined before threshold")
+	}
+
+	// A success resets the consecutive failures.
+	s.record(id, time.Millisecond, 1, nil)
+	s.record(id, time.Millisecond, 0, errors.New("failed"))
+	if s.quarantined(id) {
+		t.Fatal("expected peer not to be quarantined after success")
+	}
+
+	for i := 0; i < quarantineThreshold-1; i++ {
+		s.record(id, time.Millisecond, 0, errors.New("failed"))
+	}
+
+	if !s.quarantined(id) {
+		t.Fatal("expected peer to be quarantined")
+	}
+
+	now = now.Add(quarantineDuration + time.Second)
+	if s.quarantined(id) {
+		t.Fatal("expected quarantine to expire")
+	}
+}
+
+func TestScoresBlock(t *testing.T) {
+	now := time.Now()
+	s := newScores()
+	s.now = func() time.Time { return now }
+
+	id := peer.ID("peer")
+	s.block(id)
+	if !s.quarantined(id) {
+		t.Fatal("expected blocked peer to be quarantined")
+	}
+
+	// Failures do not shorten the block.
+	for i := 0; i < quarantineThreshold; i++ {
+		s.record(id, time.Millisecond, 0, errors.New("failed"))
+	}
+
+	// The score of a blocked peer is kept until the block expires.
+	now = now.Add(scoreTtl + time.Second)
+	s.record("other", time.Millisecond, 1, nil)
+	if !s.quarantined(id) {
+		t.Fatal("expected peer to still be blocked")
+	}
+
+	now = now.Add(blockDuration)
+	if s.quarantined(id) {
+		t.Fatal("expected block to expire")
+	}
+}
+
+func TestScoresPrune(t *testing.T) {
+	now := time.Now()
+	s := newScores()
+	s.now = func() time.Time { return now }
+
+	s.record("old", time.Millisecond, 1, nil)
+
+	now = now.Add(scoreTtl + time.Second)
+	s.record("new", time.Millisecond, 1, nil)
+
+	if _, ok := s.peers["old"]; ok {
+		t.Error("expected old peer to be pruned")
+	}
+
+	if _, ok := s.peers["new"]; !ok {
+		t.Error("expected new peer to be kept")
+	}
+}
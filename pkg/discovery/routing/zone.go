@@ -0,0 +1,197 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// zoneProtocol is the protocol used by peers to exchange their zones.
+	zoneProtocol = protocol.ID("/peerd/zone/1.0.0")
+
+	// zoneExchangeTimeout is the timeout for learning the zone of a peer.
+	zoneExchangeTimeout = 2 * time.Second
+
+	// maxZoneLength is the maximum length of a zone, which is a Kubernetes label value.
+	maxZoneLength = 63
+
+	// DefaultCrossZoneDelay is how long peers in other zones are held back while resolving by default.
+	// It leaves time for same zone providers to be found and tried first, while still being short next to a pull from origin.
+	DefaultCrossZoneDelay = 50 * time.Millisecond
+)
+
+// Zone tiers, in order of preference.
+const (
+	tierSameZone = iota
+	tierUnknownZone
+	tierOtherZone
+)
+
+// Topology describes where this host is located and which peers it may use.
+type Topology struct {
+	// Zone is the zone of this host, such as the topology.kubernetes.io/zone label of its node.
+	// If empty, peers are not ordered by zone.
+	Zone string
+
+	// ForbidCrossZone prevents the use of peers that are not known to be in the same zone.
+	// If the zone of this host is unknown, no peer is used.
+	ForbidCrossZone bool
+
+	// CrossZoneDelay is how long peers that are not known to be in the same zone are held back while resolving,
+	// to give same zone peers a head start. DefaultCrossZoneDelay is used if zero.
+	CrossZoneDelay time.Duration
+}
+
+// zones keeps track of the zones of peers to prefer peers in the same zone as this host.
+type zones struct {
+	Topology
+
+	mx    sync.RWMutex
+	peers map[peer.ID]string
+}
+
+// newZones creates a new zone tracker for the given topology.
+func newZones(t Topology) *zones {
+	if t.CrossZoneDelay <= 0 {
+		t.CrossZoneDelay = DefaultCrossZoneDelay
+	}
+
+	return &zones{
+		Topology: t,
+		peers:    map[peer.ID]string{},
+	}
+}
+
+// tier returns the preference tier of the given peer, lower is better.
+func (z *zones) tier(id peer.ID) int {
+	if z.Zone == "" {
+		return tierSameZone
+	}
+
+	z.mx.RLock()
+	defer z.mx.RUnlock()
+
+	zone, ok := z.peers[id]
+	switch {
+	case !ok || zone == "":
+		return tierUnknownZone
+	case zone == z.Zone:
+		return tierSameZone
+	default:
+		return tierOtherZone
+	}
+}
+
+// allowed returns true if the given peer may be used.
+// If cross zone peers are forbidden and the zone of this host is unknown, no peer is allowed.
+func (z *zones) allowed(id peer.ID) bool {
+	if !z.ForbidCrossZone {
+		return true
+	}
+
+	return z.Zone != "" && z.tier(id) == tierSameZone
+}
+
+// known returns true if the zone of the given peer has been learned.
+func (z *zones) known(id peer.ID) bool {
+	z.mx.RLock()
+	defer z.mx.RUnlock()
+
+	_, ok := z.peers[id]
+	return ok
+}
+
+// sort orders the given peers by zone tier, keeping the order of peers within a tier.
+func (z *zones) sort(peers []PeerInfo) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		return z.tier(peers[i].ID) < z.tier(peers[j].ID)
+	})
+}
+
+// set records the zone of the given peer.
+func (z *zones) set(id peer.ID, zone string) {
+	z.mx.Lock()
+	defer z.mx.Unlock()
+	z.peers[id] = zone
+}
+
+// serve responds to zone requests from peers with the zone of this host.
+func (z *zones) serve(h host.Host) {
+	h.SetStreamHandler(zoneProtocol, func(s network.Stream) {
+		defer s.Close()
+		_ = s.SetWriteDeadline(time.Now().Add(zoneExchangeTimeout))
+		_, _ = s.Write([]byte(z.Zone))
+	})
+}
+
+// learn requests the zone of the given peer.
+func (z *zones) learn(ctx context.Context, h host.Host, id peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, zoneExchangeTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, id, zoneProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	_ = s.SetReadDeadline(time.Now().Add(zoneExchangeTimeout))
+	b, err := io.ReadAll(io.LimitReader(s, maxZoneLength))
+	if err != nil {
+		return err
+	}
+
+	z.set(id, strings.TrimSpace(string(b)))
+	return nil
+}
+
+// watch learns the zone of every peer that this host identifies, until the context is done.
+func (z *zones) watch(ctx context.Context, h host.Host) error {
+	log := zerolog.Ctx(ctx).With().Str("component", "zones").Logger()
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				if z.known(evt.Peer) || !slices.Contains(evt.Protocols, zoneProtocol) {
+					continue
+				}
+
+				go func() {
+					if err := z.learn(ctx, h, evt.Peer); err != nil {
+						log.Debug().Err(err).Str("peer", evt.Peer.String()).Msg("could not learn zone of peer")
+					}
+				}()
+			}
+		}
+	}()
+
+	return nil
+}
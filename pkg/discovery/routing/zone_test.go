@@ -0,0 +1,201 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+func TestZonesTier(t *testing.T) {
+	z := newZones(Topology{Zone: "eastus-1"})
+	z.set("same", "eastus-1")
+	z.set("other", "eastus-2")
+	z.set("unlabeled", "")
+
+	for _, tc := range []struct {
+		id       peer.ID
+		expected int
+	}{
+		{"same", tierSameZone},
+		{"other", tierOtherZone},
+		{"unlabeled", tierUnknownZone},
+		{"unknown", tierUnknownZone},
+	} {
+		if got := z.tier(tc.id); got != tc.expected {
+			t.Errorf("expected tier %d for %s, got %d", tc.expected, tc.id, got)
+		}
+	}
+
+	peers := []PeerInfo{{ID: "other"}, {ID: "unknown"}, {ID: "same"}}
+	z.sort(peers)
+
+	expected := []peer.ID{"same", "unknown", "other"}
+	for i, p := range peers {
+		if p.ID != expected[i] {
+			t.Errorf("expected peer %s at position %d, got %s", expected[i], i, p.ID)
+		}
+	}
+}
+
+func TestZonesAllowed(t *testing.T) {
+	z := newZones(Topology{Zone: "eastus-1"})
+	z.set("same", "eastus-1")
+	z.set("other", "eastus-2")
+
+	if !z.allowed("same") || !z.allowed("other") || !z.allowed("unknown") {
+		t.Error("expected all peers to be allowed")
+	}
+
+	z.ForbidCrossZone = true
+	if !z.allowed("same") {
+		t.Error("expected same zone peer to be allowed")
+	}
+
+	if z.allowed("other") || z.allowed("unknown") {
+		t.Error("expected cross zone peers to be forbidden")
+	}
+
+	// Without a zone, peers cannot be told apart.
+	z = newZones(Topology{})
+	z.set("other", "eastus-2")
+	if !z.allowed("other") {
+		t.Error("expected peer to be allowed without a zone")
+	}
+
+	// Without a zone, no peer is known to be in the same zone.
+	z = newZones(Topology{ForbidCrossZone: true})
+	z.set("other", "eastus-2")
+	z.set("none", "")
+	if z.allowed("other") || z.allowed("none") || z.allowed("unknown") {
+		t.Error("expected peers to be forbidden without a zone")
+	}
+}
+
+func TestZonesCrossZoneDelay(t *testing.T) {
+	if d := newZones(Topology{}).CrossZoneDelay; d != DefaultCrossZoneDelay {
+		t.Errorf("expected default delay %v, got %v", DefaultCrossZoneDelay, d)
+	}
+
+	if d := newZones(Topology{CrossZoneDelay: time.Second}).CrossZoneDelay; d != time.Second {
+		t.Errorf("expected delay %v, got %v", time.Second, d)
+	}
+}
+
+func TestZonesLearn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+
+	h2, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	z1 := newZones(Topology{Zone: "eastus-1"})
+	z1.serve(h1)
+
+	z2 := newZones(Topology{Zone: "eastus-2"})
+	z2.serve(h2)
+
+	if err := h2.Connect(ctx, peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := z2.learn(ctx, h2, h1.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !z2.known(h1.ID()) {
+		t.Fatal("expected zone of peer to be known")
+	}
+
+	if got := z2.tier(h1.ID()); got != tierOtherZone {
+		t.Errorf("expected tier %d, got %d", tierOtherZone, got)
+	}
+}
+
+func TestResolvePrefersSameZone(t *testing.T) {
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 1e7,
+		MaxCost:     1000,
+		BufferItems: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "some-key"
+	contentId, err := createContentId(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := newZones(Topology{Zone: "eastus-1"})
+	z.set("10.0.0.1", "eastus-2")
+	z.set("10.0.0.2", "eastus-1")
+
+	r := &router{
+		k8sClient:        &fakeClientset,
+		host:             &testHost{"host-id"},
+		peerRegistryPort: "5000",
+		lookupCache:      c,
+		markers:          newMarkers(),
+		metricsRecorder:  mr,
+		scores:           newScores(),
+		zones:            z,
+		content: routing.NewRoutingDiscovery(&testCr{
+			m: map[string][]string{
+				contentId.String(): {"10.0.0.1", "10.0.0.2"},
+			},
+		}),
+	}
+
+	got, err := r.Resolve(context.Background(), key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The other zone peer is found first, but held back.
+	if info := <-got; info.ID != "10.0.0.2" {
+		t.Errorf("expected same zone peer first, got %s", info.ID)
+	}
+
+	if info := <-got; info.ID != "10.0.0.1" {
+		t.Errorf("expected other zone peer second, got %s", info.ID)
+	}
+
+	// Cross zone peers are not returned when forbidden, neither from the cache nor from the network.
+	z.ForbidCrossZone = true
+	got, err = r.Resolve(context.Background(), key, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers := []PeerInfo{}
+	timeout := time.After(100 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case info := <-got:
+			peers = append(peers, info)
+		case <-timeout:
+			done = true
+		}
+	}
+
+	if len(peers) != 1 || peers[0].ID != "10.0.0.2" {
+		t.Errorf("expected only same zone peer, got %v", peers)
+	}
+}
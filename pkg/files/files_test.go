@@ -64,7 +64,7 @@ type mockReader struct {
 }
 
 // FstatRemote implements remote.Reader.
-func (*mockReader) FstatRemote() (int64, error) {
+func (*mockReader) FstatRemote() (int64, bool, error) {
 	panic("unimplemented")
 }
 
@@ -3,17 +3,25 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 
 	"sync"
 
+	"github.com/azure/peerd/pkg/cache"
 	"github.com/azure/peerd/pkg/discovery/content/reader"
 	"github.com/azure/peerd/pkg/files"
 	"github.com/azure/peerd/pkg/math"
 )
 
-var errOnlySingleChunkAvailable = fmt.Errorf("only single chunk available")
+var (
+	errOnlySingleChunkAvailable = fmt.Errorf("only single chunk available")
+
+	// errUnverified is returned for a chunk read from peers whose blob could not be verified.
+	errUnverified = errors.New("chunk from peers not verified")
+)
 
 // file describes a file that can be read from this content store.
 // It implements the File interface. It is similar to os.File.
@@ -74,6 +82,7 @@ func (f *file) Seek(offset int64, whence int) (int64, error) {
 }
 
 // Fstat returns the size of the file.
+// A size sent by a peer is only held in memory, and persisted once the blob is verified.
 func (f *file) Fstat() (int64, error) {
 	var hit bool
 
@@ -81,19 +90,25 @@ func (f *file) Fstat() (int64, error) {
 	if !hit {
 		f.reader.Log().Debug().Str("name", f.Name).Int64("size", f.size).Msg("fstat getlen cache miss_1")
 		f.statLock.Lock()
+		defer f.statLock.Unlock()
+
 		f.size, hit = f.store.cache.Size(f.Name)
 		if !hit {
 			f.reader.Log().Debug().Str("name", f.Name).Int64("size", f.size).Msg("fstat getlen cache miss_2")
-			var err error
-			f.size, err = f.reader.FstatRemote()
+			size, trusted, err := f.reader.FstatRemote()
 			if err != nil {
 				f.reader.Log().Error().Err(err).Msg("fstat error")
 				return 0, err
 			}
-			f.store.cache.PutSize(f.Name, f.size)
-			f.reader.Log().Debug().Str("name", f.Name).Int64("size", f.size).Msg("fstat putlen")
+			f.size = size
+
+			if trusted {
+				f.store.cache.PutSize(f.Name, f.size)
+			} else {
+				f.store.cache.HoldSize(f.Name, f.size)
+			}
+			f.reader.Log().Debug().Str("name", f.Name).Int64("size", f.size).Bool("trusted", trusted).Msg("fstat putlen")
 		}
-		f.statLock.Unlock()
 	}
 
 	return f.size, nil
@@ -124,17 +139,34 @@ func (f *file) ReadAt(buff []byte, offset int64) (int, error) {
 
 	count := int(math.Min64(int64(files.CacheBlockSize), fileSize-alignedOffset))
 
-	data, err := f.store.cache.GetOrCreate(f.Name, alignedOffset, count, func() ([]byte, error) {
+	ret := 0
+	fetch := func() ([]byte, error) {
 		return files.FetchFile(f.reader, f.Name, alignedOffset, count)
-	})
+	}
+	view := func(data []byte) {
+		pos := int(offset - alignedOffset)
+		ret = math.Min(len(buff), len(data)-pos)
+		ret = copy(buff[:ret], data[pos:pos+ret])
+	}
+
+	err = f.store.cache.View(f.Name, alignedOffset, count, fetch, view)
+	if errors.Is(err, cache.ErrFillRefused) {
+		// The chunk is served without caching it, unless it must be held until its blob is verified.
+		var data []byte
+		if data, err = fetch(); err == nil && f.store.held(f.Name, alignedOffset) {
+			err = errUnverified
+		} else if err == nil {
+			view(data)
+		}
+	} else if err == nil && f.store.held(f.Name, alignedOffset) {
+		// The chunk was read from a peer that signed it, so it is only returned once its blob is verified.
+		err = f.store.verifyHeld(f.Name, f.reader)
+	}
 	if err != nil {
 		f.reader.Log().Error().Err(err).Msg("readat error")
 		return 0, fmt.Errorf("failed to ReadAt, path: %v, offset: %v, error: %v", f.Name, offset, err.Error())
 	}
-
-	pos := int(offset - alignedOffset)
-	ret := math.Min(len(buff), len(data)-pos)
-	ret = copy(buff[:ret], data[pos:pos+ret])
+	f.store.verifyBlob(f.Name, f.reader)
 
 	if offset+int64(len(buff)) > fileSize {
 		err = io.EOF
@@ -142,3 +174,38 @@ func (f *file) ReadAt(buff []byte, offset int64) (int, error) {
 
 	return ret, err
 }
+
+// Section opens the file of the cached chunk that holds the count bytes at offset, positioned at offset.
+func (f *file) Section(offset int64, count int64) (*os.File, error) {
+	fileSize, err := f.Fstat()
+	if err != nil {
+		return nil, err
+	}
+
+	alignedOffset := math.AlignDown(offset, int64(files.CacheBlockSize))
+	chunkSize := math.Min64(int64(files.CacheBlockSize), fileSize-alignedOffset)
+	if offset < 0 || count <= 0 || offset+count > alignedOffset+chunkSize {
+		return nil, os.ErrNotExist
+	}
+
+	if f.chunkOffset != 0 && alignedOffset != f.chunkOffset {
+		return nil, errOnlySingleChunkAvailable
+	}
+
+	// Chunks held until their blob is verified are read with ReadAt, which verifies it.
+	if f.store.held(f.Name, alignedOffset) {
+		return nil, os.ErrNotExist
+	}
+
+	chunk, err := f.store.cache.Open(f.Name, alignedOffset, int(chunkSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := chunk.Seek(offset-alignedOffset, io.SeekStart); err != nil {
+		chunk.Close()
+		return nil, err
+	}
+
+	return chunk, nil
+}
@@ -4,12 +4,16 @@ package store
 
 import (
 	"crypto/rand"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/azure/peerd/pkg/cache"
+	"github.com/azure/peerd/pkg/discovery/content/reader"
 	readermocks "github.com/azure/peerd/pkg/discovery/content/reader/mocks"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/files"
@@ -225,6 +229,82 @@ func TestFstat(t *testing.T) {
 	}
 }
 
+// failingReader is a reader whose remote stat fails.
+type failingReader struct {
+	reader.Reader
+}
+
+// FstatRemote implements reader.Reader.
+func (failingReader) FstatRemote() (int64, bool, error) {
+	return 0, false, reader.ErrOriginUnavailable
+}
+
+func TestFstatError(t *testing.T) {
+	s, err := NewFilesStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &file{
+		Name:   "test-fstat-error",
+		reader: failingReader{readermocks.NewMockReader(nil)},
+		store:  s.(*store),
+	}
+
+	// A failed stat does not block the next one.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			if _, err := f.Fstat(); !errors.Is(err, reader.ErrOriginUnavailable) {
+				t.Errorf("expected %v, got %v", reader.ErrOriginUnavailable, err)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected fstat to return after a failed stat")
+	}
+}
+
+// peerReader is a reader whose remote stat returns a size sent by a peer.
+type peerReader struct {
+	reader.Reader
+}
+
+// FstatRemote implements reader.Reader.
+func (peerReader) FstatRemote() (int64, bool, error) {
+	return 100, false, nil
+}
+
+func TestFstatFromPeer(t *testing.T) {
+	s, err := NewFilesStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &file{
+		Name:   "test-fstat-peer",
+		reader: peerReader{readermocks.NewMockReader(nil)},
+		store:  s.(*store),
+	}
+
+	if size, err := f.Fstat(); err != nil || size != 100 {
+		t.Fatalf("expected size 100, got %v, %v", size, err)
+	}
+
+	// The size is used, but not persisted until the blob is verified.
+	if size, ok := s.(*store).cache.Size(f.Name); !ok || size != 100 {
+		t.Errorf("expected size 100 to be cached, got %v", size)
+	}
+
+	if _, err := os.Stat(filepath.Join(cache.Path, f.Name, "metainfo")); !os.IsNotExist(err) {
+		t.Errorf("expected size not to be persisted, got %v", err)
+	}
+}
+
 func randomBytesN(n int) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
@@ -233,3 +313,49 @@ func randomBytesN(n int) ([]byte, error) {
 	}
 	return b, nil
 }
+
+func TestSection(t *testing.T) {
+	data := []byte("hello world")
+
+	files.CacheBlockSize = 4
+
+	s, err := NewFilesStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &file{
+		Name:   "section",
+		reader: readermocks.NewMockReader(data),
+		store:  s.(*store),
+	}
+
+	// The chunk is not cached yet.
+	if _, err := f.Section(5, 2); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got %v", os.ErrNotExist, err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := f.ReadAt(buf, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	section, err := f.Section(5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer section.Close()
+
+	got, err := io.ReadAll(io.LimitReader(section, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != " w" {
+		t.Errorf("expected %q, got %q", " w", string(got))
+	}
+
+	// The range must be in a single chunk.
+	if _, err := f.Section(5, 4); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v for range across chunks, got %v", os.ErrNotExist, err)
+	}
+}
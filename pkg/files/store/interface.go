@@ -3,9 +3,14 @@
 package store
 
 import (
+	"errors"
+	"os"
 	"time"
 
 	"github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/discovery/content/reader"
+	"github.com/azure/peerd/pkg/urlparser"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -17,8 +22,14 @@ type FilesStore interface {
 	// Open opens the requested file and starts prefetching it. It also returns the size of the file.
 	Open(c context.Context) (File, error)
 
+	// Manifest returns the chunk manifest entry of the requested chunk, if the chunk is cached.
+	Manifest(c context.Context) (*manifest.Entry, bool)
+
 	// Subscribe returns a channel that will be notified when a blob is added to the store.
 	Subscribe() chan string
+
+	// SubscribeEvictions returns a channel that will be notified when a blob is evicted from the store.
+	SubscribeEvictions() chan string
 }
 
 // File is an abstraction for a file that can be read from this store.
@@ -35,8 +46,16 @@ type File interface {
 
 	// ReadAt reads len(p) bytes from the File starting at byte offset off. It returns the number of bytes read and the error, if any.
 	ReadAt(buff []byte, off int64) (int, error)
+
+	// Section opens the file of the cached chunk that holds the count bytes at offset off, positioned at off, so that
+	// they can be sent without reading them into memory. The caller must close it. It returns os.ErrNotExist if the
+	// bytes are not all in a single cached chunk.
+	Section(off int64, count int64) (*os.File, error)
 }
 
+// ErrWithdrawn indicates that the requested content is no longer advertised by this host.
+var ErrWithdrawn = errors.New("content withdrawn")
+
 var (
 	// PrefetchWorkers is the number of workers that will be used to prefetch files.
 	// To disable prefetch, set this to 0.
@@ -47,4 +66,17 @@ var (
 
 	// ResolveTimeout is the timeout for resolving a key.
 	ResolveTimeout = 20 * time.Millisecond
+
+	// Origin is the configuration of requests to origin, such as retries and the circuit breaker.
+	Origin = reader.DefaultOriginConfig
+
+	// ManifestsPath is the directory where the chunk manifest entries of cached chunks are kept across restarts.
+	ManifestsPath = "/tmp/distribution/peerd/manifests"
+
+	// VerifyCachedChunks enables checking the digest of every chunk left in the cache by a previous run before serving
+	// it again. Otherwise, only the size of the chunks is checked.
+	VerifyCachedChunks = false
+
+	// URLParserRules are the user-defined rules for parsing the digest of blob URLs, tried before the built-in parsers.
+	URLParserRules []urlparser.Rule
 )
@@ -30,14 +30,19 @@ func TestMain(m *testing.M) {
 func setup() {
 	suf := newRandomStringN(10)
 	cache.Path += suf
+	ManifestsPath += suf
 }
 
-// teardown removes the cache directory.
+// teardown removes the cache and manifests directories.
 func teardown() error {
 	if err := os.RemoveAll(cache.Path); err != nil {
 		return fmt.Errorf("failed to remove cache dir: %v --- %v", cache.Path, err)
 	}
 
+	if err := os.RemoveAll(ManifestsPath); err != nil {
+		return fmt.Errorf("failed to remove manifests dir: %v --- %v", ManifestsPath, err)
+	}
+
 	return nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"context"
 
 	"github.com/azure/peerd/pkg/cache"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
 	"github.com/azure/peerd/pkg/discovery/routing"
 )
 
@@ -19,6 +20,10 @@ func (m *MockStore) Cache() cache.Cache {
 	return m.store.cache
 }
 
+func (m *MockStore) Manifests() *manifest.Store {
+	return m.store.manifests
+}
+
 func NewMockStore(ctx context.Context, r routing.Router) (*MockStore, error) {
 	s, err := NewFilesStore(ctx, r)
 	if err != nil {
@@ -4,6 +4,8 @@ package store
 
 import (
 	"context"
+	"errors"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -11,28 +13,51 @@ import (
 
 	"github.com/azure/peerd/pkg/cache"
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
 	"github.com/azure/peerd/pkg/discovery/content/reader"
 	"github.com/azure/peerd/pkg/discovery/routing"
 	"github.com/azure/peerd/pkg/files"
 	"github.com/azure/peerd/pkg/metrics"
 	"github.com/azure/peerd/pkg/urlparser"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/opencontainers/go-digest"
 	"github.com/rs/zerolog"
+	"golang.org/x/sync/singleflight"
 )
 
 // NewFilesStore creates a new store.
 func NewFilesStore(ctx context.Context, r routing.Router) (FilesStore, error) {
+	parser, err := urlparser.NewWithRules(URLParserRules)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := manifest.OpenStore(ManifestsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := peer.IDFromPrivateKey(r.Identity())
+	if err != nil {
+		return nil, err
+	}
+
 	fs := &store{
 		metricsRecorder: metrics.FromContext(ctx),
-		cache:           cache.New(ctx, int64(files.CacheBlockSize)),
 		prefetchChan:    make(chan prefetchableSegment, PrefetchWorkers),
 		prefetchable:    PrefetchWorkers > 0,
 		router:          r,
+		self:            self,
 		resolveRetries:  ResolveRetries,
 		resolveTimeout:  ResolveTimeout,
 		blobsChan:       make(chan string, 1000),
-		parser:          urlparser.New(),
+		evictedChan:     make(chan string, 1000),
+		parser:          parser,
+		manifests:       manifests,
+		origins:         reader.NewOrigins(Origin, metrics.FromContext(ctx)),
 	}
+	fs.cache = cache.New(ctx, int64(files.CacheBlockSize), fs.onEvict(zerolog.Ctx(ctx)))
+	fs.load(zerolog.Ctx(ctx))
 
 	go func() {
 		<-ctx.Done()
@@ -51,6 +76,13 @@ func NewFilesStore(ctx context.Context, r routing.Router) (FilesStore, error) {
 	return fs, nil
 }
 
+// Results of the verification of a blob, reported in metrics.
+const (
+	verificationKindBlob = "blob"
+	verificationSuccess  = "success"
+	verificationCorrupt  = "corrupt"
+)
+
 // prefetchableSegment describes a part of a file to prefetch.
 type prefetchableSegment struct {
 	name   string
@@ -67,10 +99,17 @@ type store struct {
 	prefetchable    bool
 	prefetchChan    chan prefetchableSegment
 	router          routing.Router
+	self            peer.ID
 	resolveRetries  int
 	resolveTimeout  time.Duration
 	blobsChan       chan string
+	evictedChan     chan string
 	parser          urlparser.Parser
+	manifests       *manifest.Store
+	origins         *reader.Origins
+
+	// checks shares the verification of a blob between the reads waiting for it.
+	checks singleflight.Group
 }
 
 var _ FilesStore = &store{}
@@ -80,21 +119,105 @@ func (s *store) Subscribe() chan string {
 	return s.blobsChan
 }
 
+// SubscribeEvictions returns a channel that will be notified when a blob is evicted from the store.
+func (s *store) SubscribeEvictions() chan string {
+	return s.evictedChan
+}
+
+// onEvict returns a cache eviction callback that notifies evictions subscribers of the evicted chunk.
+// The manifest entry of the chunk is removed with it.
+func (s *store) onEvict(log *zerolog.Logger) func(name string, offset int64) {
+	return func(name string, offset int64) {
+		s.manifests.Delete(digest.Digest(name), offset)
+		key := files.FileChunkKey(name, offset, int64(files.CacheBlockSize))
+		select {
+		case s.evictedChan <- key:
+		default:
+			// The advertisement expires on its own.
+			log.Warn().Str("key", key).Msg("evictions channel full, dropping eviction")
+		}
+	}
+}
+
+// load loads the chunks cached by a previous run, such as before a restart, and advertises the ones this host vouches
+// for again. A chunk is only kept if its manifest entry was kept with it, since peers do not accept chunks without one.
+func (s *store) load(log *zerolog.Logger) {
+	type cached struct {
+		name   string
+		offset int64
+	}
+
+	loaded := []cached{}
+	s.cache.Load(func(name string, offset int64, chunk *io.SectionReader) bool {
+		e, ok := s.manifests.Get(digest.Digest(name), offset)
+		if !ok || e.Size != chunk.Size() {
+			return false
+		}
+
+		if VerifyCachedChunks {
+			data, err := io.ReadAll(chunk)
+			if err != nil || e.Verify(digest.Digest(name), offset, data) != nil {
+				log.Warn().Err(err).Str("name", name).Int64("offset", offset).Msg("cached chunk failed verification")
+				return false
+			}
+		}
+
+		loaded = append(loaded, cached{name, offset})
+		return true
+	})
+
+	keys := []string{}
+	for _, c := range loaded {
+		// The cache may still have rejected the chunk.
+		if s.cache.Exists(c.name, c.offset) && s.vouched(c.name, c.offset) {
+			keys = append(keys, files.FileChunkKey(c.name, c.offset, int64(files.CacheBlockSize)))
+		}
+	}
+
+	// The entries of chunks that were not kept are of no use.
+	s.manifests.DeleteFunc(func(e *manifest.Entry) bool {
+		return !s.cache.Exists(e.Blob.String(), e.Offset)
+	})
+
+	// Subscribers start reading once the store is created.
+	go func() {
+		for _, key := range keys {
+			s.blobsChan <- key
+		}
+	}()
+}
+
 // Open opens the requested file and starts prefetching it.
 func (s *store) Open(c pcontext.Context) (File, error) {
 
 	chunkKey := c.GetString(pcontext.FileChunkCtxKey)
-	tokens := strings.Split(chunkKey, files.FileChunkKeySep)
-	name := tokens[0]
-	alignedOff, _ := strconv.ParseInt(tokens[1], 10, 64)
+	name, alignedOff := chunkOf(c)
 
 	log := pcontext.Logger(c)
 	if pcontext.IsRequestFromAPeer(c) {
+		// This chunk was withdrawn, tell the peer that its provider record is stale.
+		if s.router.Withdrawn(chunkKey) {
+			log.Info().Str("key", chunkKey).Msg("peer request withdrawn")
+			return nil, ErrWithdrawn
+		}
+
 		// This request came from a peer. Don't serve it unless we have the requested range cached.
 		if ok := s.cache.Exists(name, alignedOff); !ok {
 			log.Info().Str("name", name).Msg("peer request not cached")
 			return nil, os.ErrNotExist
 		}
+
+		// Chunks held until their blob is verified are not served either.
+		if s.held(name, alignedOff) {
+			log.Info().Str("name", name).Msg("peer request not verified")
+			return nil, os.ErrNotExist
+		}
+
+		// Peers do not send the URL of the blob, so its size must be cached too.
+		if _, ok := s.cache.Size(name); !ok {
+			log.Info().Str("name", name).Msg("peer request size not cached")
+			return nil, os.ErrNotExist
+		}
 	}
 
 	f := &file{
@@ -102,7 +225,7 @@ func (s *store) Open(c pcontext.Context) (File, error) {
 		store:  s,
 		cur:    0,
 		size:   0,
-		reader: reader.NewReader(c, s.router, s.resolveRetries, s.resolveTimeout, s.metricsRecorder),
+		reader: reader.NewReader(c, s.router, s.manifests, s.origins, s.resolveRetries, s.resolveTimeout, s.metricsRecorder),
 	}
 
 	if pcontext.IsRequestFromAPeer(c) {
@@ -120,12 +243,18 @@ func (s *store) Open(c pcontext.Context) (File, error) {
 	return f, err
 }
 
+// Manifest returns the chunk manifest entry of the requested chunk, if the chunk is cached.
+func (s *store) Manifest(c pcontext.Context) (*manifest.Entry, bool) {
+	name, offset := chunkOf(c)
+	return s.manifests.Get(digest.Digest(name), offset)
+}
+
 // Key tries to find the cache key for the requested content or returns empty.
 func (s *store) Key(c pcontext.Context) (string, digest.Digest, error) {
 	log := pcontext.Logger(c)
 
 	blobUrl := pcontext.BlobUrl(c)
-	d, err := s.parser.ParseDigest(blobUrl)
+	d, err := s.parser.ParseDigest(blobUrl, c.Request.Header)
 	if err != nil {
 		log.Error().Err(err).Msg("store key")
 	}
@@ -146,13 +275,162 @@ func (s *store) Key(c pcontext.Context) (string, digest.Digest, error) {
 // prefetch prefetches files.
 func (s *store) prefetch() {
 	for p := range s.prefetchChan {
-		if _, err := s.cache.GetOrCreate(p.name, p.offset, p.count, func() ([]byte, error) {
+		if err := s.cache.View(p.name, p.offset, p.count, func() ([]byte, error) {
 			return files.FetchFile(p.reader, p.name, p.offset, p.count)
-		}); err != nil {
+		}, func([]byte) {}); errors.Is(err, cache.ErrFillRefused) {
+			p.reader.Log().Debug().Str("name", p.name).Int64("offset", p.offset).Msg("prefetch skipped, chunk not cached")
+		} else if err != nil {
 			p.reader.Log().Error().Err(err).Str("name", p.name).Msg("prefetch failed")
 		} else {
-			// Advertise the chunk.
-			s.blobsChan <- files.FileChunkKey(p.name, p.offset, int64(files.CacheBlockSize))
+			if s.vouched(p.name, p.offset) {
+				// Advertise the chunk.
+				s.blobsChan <- files.FileChunkKey(p.name, p.offset, int64(files.CacheBlockSize))
+			} else {
+				p.reader.Log().Debug().Str("name", p.name).Int64("offset", p.offset).Msg("chunk from peers not advertised until its blob is verified")
+			}
+			s.verifyBlob(p.name, p.reader)
+		}
+	}
+}
+
+// vouched returns true if the manifest entry of the chunk is signed by this host, which it only signs for chunks read
+// from origin or verified against the digest of their blob.
+// Chunks read from peers are not advertised until then, so that a peer vouching for corrupt data cannot spread it.
+func (s *store) vouched(name string, offset int64) bool {
+	e, ok := s.manifests.Get(digest.Digest(name), offset)
+	return ok && e.Author == s.self
+}
+
+// held returns true if the chunk was read from a peer that signed its manifest entry itself, or from a peer whose
+// author this host does not trust, so that it is not returned until its blob is verified. Chunks vouched for by this
+// host, or relayed by a peer other than their author which this host trusts, are returned right away.
+func (s *store) held(name string, offset int64) bool {
+	e, ok := s.manifests.Get(digest.Digest(name), offset)
+	return ok && e.Author != s.self && !e.Trusted
+}
+
+// verifyHeld checks the digest of the blob with the given name, fetching the chunks that are not cached yet, so that its
+// chunks held since they were read from peers can be returned. It returns errUnverified if the blob is not verified.
+func (s *store) verifyHeld(name string, r reader.Reader) error {
+	size, ok := s.cache.Size(name)
+	d := digest.Digest(name)
+	if !ok || d.Validate() != nil {
+		return errUnverified
+	}
+
+	if verified, _, _ := s.checks.Do(name, func() (any, error) {
+		return s.checkBlob(d, size, r), nil
+	}); !verified.(bool) {
+		return errUnverified
+	}
+
+	return nil
+}
+
+// verifyBlob checks the digest of the blob with the given name once all its chunks are cached.
+// Each chunk was verified on its own, but a blob can still be corrupt if an author vouched for a wrong chunk.
+// If the blob is verified, this host vouches for the chunks read from peers and advertises them. If the blob is corrupt,
+// its chunks and size are removed from the cache, which also withdraws their advertisements, and the peers that vouched
+// for its chunks are blocked.
+func (s *store) verifyBlob(name string, r reader.Reader) {
+	size, ok := s.cache.Size(name)
+	if !ok {
+		return
+	}
+
+	d := digest.Digest(name)
+	blockSize := int64(files.CacheBlockSize)
+	if d.Validate() != nil || !s.manifests.Assembled(d, int((size+blockSize-1)/blockSize)) {
+		return
+	}
+
+	go s.checks.Do(name, func() (any, error) {
+		return s.checkBlob(d, size, r), nil
+	})
+}
+
+// checkBlob reads the cached chunks of the blob in order and compares their digest with the digest of the blob.
+// It returns true if the blob is verified.
+func (s *store) checkBlob(d digest.Digest, size int64, r reader.Reader) bool {
+	name := d.String()
+	blockSize := int64(files.CacheBlockSize)
+	log := r.Log().With().Str("name", name).Logger()
+
+	v := d.Verifier()
+	vouched := []*manifest.Entry{}
+	for offset := int64(0); offset < size; offset += blockSize {
+		count := int(min(blockSize, size-offset))
+		if err := s.cache.View(name, offset, count, func() ([]byte, error) {
+			return files.FetchFile(r, name, offset, count)
+		}, func(data []byte) {
+			// nolint:errcheck // writes to a digester do not fail
+			v.Write(data)
+
+			if s.vouched(name, offset) {
+				return
+			}
+			e, err := manifest.NewEntry(s.router.Identity(), d, offset, data)
+			if err != nil {
+				log.Error().Err(err).Int64("offset", offset).Msg("failed to create chunk manifest entry")
+				return
+			}
+			vouched = append(vouched, e)
+		}); err != nil {
+			log.Error().Err(err).Int64("offset", offset).Msg("blob verification failed to read chunk")
+			return false
 		}
 	}
+
+	if v.Verified() {
+		s.metricsRecorder.RecordVerification(verificationKindBlob, verificationSuccess)
+		log.Debug().Int("vouched", len(vouched)).Msg("blob verified")
+
+		// The size may have come from a peer, and can now be trusted.
+		s.cache.PutSize(name, size)
+
+		// The chunks read from peers can now be shared.
+		for _, e := range vouched {
+			if !s.cache.Exists(name, e.Offset) {
+				continue
+			}
+			if err := s.manifests.Put(e); err != nil {
+				log.Warn().Err(err).Msg("failed to persist chunk manifest entry")
+			}
+			s.blobsChan <- files.FileChunkKey(name, e.Offset, blockSize)
+		}
+		return true
+	}
+
+	s.metricsRecorder.RecordVerification(verificationKindBlob, verificationCorrupt)
+	authors := []string{}
+	blocked := map[peer.ID]struct{}{}
+	for _, e := range s.manifests.Entries(d) {
+		authors = append(authors, e.Author.String())
+
+		// The authors vouched for chunks of a corrupt blob, so they are not used anymore.
+		if _, ok := blocked[e.Author]; !ok && e.Author != s.self {
+			blocked[e.Author] = struct{}{}
+			s.router.Block(e.Author)
+		}
+	}
+	log.Error().Strs("authors", authors).Msg("blob failed verification, removing its chunks and size")
+
+	for offset := int64(0); offset < size; offset += blockSize {
+		s.cache.Delete(name, offset)
+	}
+
+	// The size may have come from a peer too.
+	s.cache.DeleteSize(name)
+	return false
+}
+
+// chunkOf returns the name and aligned offset of the chunk of the request.
+func chunkOf(c pcontext.Context) (string, int64) {
+	tokens := strings.Split(c.GetString(pcontext.FileChunkCtxKey), files.FileChunkKeySep)
+	if len(tokens) < 2 {
+		return tokens[0], 0
+	}
+
+	offset, _ := strconv.ParseInt(tokens[1], 10, 64)
+	return tokens[0], offset
 }
@@ -3,16 +3,27 @@
 package store
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/azure/peerd/pkg/cache"
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	readermocks "github.com/azure/peerd/pkg/discovery/content/reader/mocks"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/files"
 	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -54,6 +65,73 @@ func TestOpenP2p(t *testing.T) {
 	}
 }
 
+func TestOpenP2pSizeNotCached(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5001/p2p/v1/chunks/sha256:1234/0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set(pcontext.P2PHeaderKey, "true")
+
+	name := digest.FromString("size not cached").String()
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+	ctx.Set(pcontext.FileChunkCtxKey, files.FileChunkKey(name, 0, int64(files.CacheBlockSize)))
+
+	PrefetchWorkers = 0 // turn off prefetching
+	s, err := NewFilesStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nolint:errcheck
+	s.(*store).cache.GetOrCreate(name, 0, 10, func() ([]byte, error) {
+		return []byte("0123456789"), nil
+	})
+
+	// The chunk is cached, but the size of the blob would have to be requested from origin.
+	_, err = s.Open(pcontext.Context{Context: ctx})
+	if err != os.ErrNotExist {
+		t.Errorf("expected %v, got %v", os.ErrNotExist, err)
+	}
+}
+
+func TestOpenP2pWithdrawn(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", files.CacheBlockSize, files.CacheBlockSize+172))
+	req.Header.Set(pcontext.P2PHeaderKey, "true")
+
+	expD := "sha256:d18c7a64c5158179bdee531a663c5b487de57ff17cff3af29a51c7e70b491d9d"
+	expK := fmt.Sprintf("%v%v%v", expD, files.FileChunkKeySep, files.CacheBlockSize)
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+	ctx.Params = []gin.Param{
+		{Key: "url", Value: hostAndPath},
+	}
+	ctx.Set(pcontext.FileChunkCtxKey, expK)
+
+	r := mocks.NewMockRouter(make(map[string][]string))
+	if err := r.Withdraw(ctxWithMetrics, []string{expK}); err != nil {
+		t.Fatal(err)
+	}
+
+	PrefetchWorkers = 0 // turn off prefetching
+	s, err := NewFilesStore(ctxWithMetrics, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Open(pcontext.Context{Context: ctx})
+	if err != ErrWithdrawn {
+		t.Errorf("expected %v, got %v", ErrWithdrawn, err)
+	}
+}
+
 func TestOpenNonP2p(t *testing.T) {
 	// Create a new request with a URL that has a query string.
 	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
@@ -134,3 +212,248 @@ func TestSubscribe(t *testing.T) {
 		t.Fatal("expected channel, got nil")
 	}
 }
+
+func TestCheckBlob(t *testing.T) {
+	data := make([]byte, files.CacheBlockSize+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		d        digest.Digest
+		verified bool
+	}{
+		{name: "verified", d: digest.FromBytes(data), verified: true},
+		{name: "corrupt", d: digest.FromString("other"), verified: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := mocks.NewMockRouter(make(map[string][]string))
+			s, err := NewMockStore(ctxWithMetrics, router)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The size was sent by a peer.
+			name := tc.d.String()
+			s.Cache().HoldSize(name, int64(len(data)))
+			for offset := 0; offset < len(data); offset += files.CacheBlockSize {
+				chunk := data[offset:min(offset+files.CacheBlockSize, len(data))]
+				if _, err := s.Cache().GetOrCreate(name, int64(offset), len(chunk), func() ([]byte, error) {
+					return chunk, nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The first chunk was read from a peer that vouched for it.
+			other, _, err := crypto.GenerateEd25519Key(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := manifest.NewEntry(other, tc.d, 0, data[:files.CacheBlockSize])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Manifests().Put(e); err != nil {
+				t.Fatal(err)
+			}
+
+			s.checkBlob(tc.d, int64(len(data)), readermocks.NewMockReader(data))
+
+			// The author of a corrupt blob is blocked, and its size is removed.
+			if blocked := slices.Contains(router.Blocked(), e.Author); blocked == tc.verified {
+				t.Errorf("expected author to be blocked: %v, got %v", !tc.verified, blocked)
+			}
+
+			if _, ok := s.Cache().Size(name); ok != tc.verified {
+				t.Errorf("expected size to be cached: %v, got %v", tc.verified, ok)
+			}
+
+			// The size of a verified blob is persisted.
+			if _, err := os.Stat(filepath.Join(cache.Path, name, "metainfo")); os.IsNotExist(err) == tc.verified {
+				t.Errorf("expected size to be persisted: %v, got %v", tc.verified, err)
+			}
+
+			// A corrupt blob is removed from the cache, and a verified one is vouched for and advertised.
+			self, err := peer.IDFromPrivateKey(router.Identity())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, offset := range []int64{0, int64(files.CacheBlockSize)} {
+				if got := s.Cache().Exists(name, offset); got != tc.verified {
+					t.Errorf("expected chunk at %d to be cached: %v, got %v", offset, tc.verified, got)
+				}
+
+				e, ok := s.Manifests().Get(tc.d, offset)
+				if got := ok && e.Author == self; got != tc.verified {
+					t.Errorf("expected chunk at %d to be vouched for: %v, got %v", offset, tc.verified, got)
+				}
+			}
+
+			// Chunks cached by other tests are advertised too when the store is created.
+			advertised := 0
+			for done := false; !done; {
+				select {
+				case key := <-s.Subscribe():
+					if strings.HasPrefix(key, name) {
+						advertised++
+					}
+				case <-time.After(100 * time.Millisecond):
+					done = true
+				}
+			}
+
+			expected := 0
+			if tc.verified {
+				expected = (len(data) + files.CacheBlockSize - 1) / files.CacheBlockSize
+			}
+			if advertised != expected {
+				t.Errorf("expected %d chunks to be advertised, got %d", expected, advertised)
+			}
+		})
+	}
+}
+
+func TestVerifyHeld(t *testing.T) {
+	data := make([]byte, files.CacheBlockSize+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		d        digest.Digest
+		expected error
+	}{
+		{name: "verified", d: digest.FromBytes(data), expected: nil},
+		{name: "corrupt", d: digest.FromString("other"), expected: errUnverified},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := mocks.NewMockRouter(make(map[string][]string))
+			s, err := NewMockStore(ctxWithMetrics, router)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			name := tc.d.String()
+			s.Cache().HoldSize(name, int64(len(data)))
+			if _, err := s.Cache().GetOrCreate(name, 0, files.CacheBlockSize, func() ([]byte, error) {
+				return data[:files.CacheBlockSize], nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The first chunk was read from the peer that signed it.
+			other, _, err := crypto.GenerateEd25519Key(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := manifest.NewEntry(other, tc.d, 0, data[:files.CacheBlockSize])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Manifests().Put(e); err != nil {
+				t.Fatal(err)
+			}
+
+			if !s.held(name, 0) {
+				t.Fatal("expected chunk signed by the serving peer to be held")
+			}
+
+			// The rest of the blob is read to verify it.
+			if err := s.verifyHeld(name, readermocks.NewMockReader(data)); err != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, err)
+			}
+
+			// A verified chunk is no longer held, and a corrupt one is removed.
+			if tc.expected == nil && s.held(name, 0) {
+				t.Error("expected verified chunk to not be held")
+			} else if tc.expected != nil && s.Cache().Exists(name, 0) {
+				t.Error("expected corrupt chunk to be removed")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer func(v bool) { VerifyCachedChunks = v }(VerifyCachedChunks)
+	VerifyCachedChunks = true
+
+	PrefetchWorkers = 0 // turn off prefetching
+	router := mocks.NewMockRouter(make(map[string][]string))
+	s, err := NewMockStore(ctxWithMetrics, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("l"), files.CacheBlockSize)
+	loaded, relayed := digest.FromString("loaded"), digest.FromString("relayed")
+	corrupt, unsigned := digest.FromString("corrupt"), digest.FromString("unsigned")
+	for _, d := range []digest.Digest{loaded, relayed, corrupt, unsigned} {
+		s.Cache().PutSize(d.String(), int64(len(data)))
+		if _, err := s.Cache().GetOrCreate(d.String(), 0, len(data), func() ([]byte, error) {
+			return data, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The chunk of relayed was read from a peer whose entry this host has not vouched for yet.
+	for d, signed := range map[digest.Digest][]byte{loaded: data, relayed: data, corrupt: bytes.Repeat([]byte("c"), len(data))} {
+		key := router.Identity()
+		if d == relayed {
+			key = other
+		}
+
+		e, err := manifest.NewEntry(key, d, 0, signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Manifests().Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The store is created again, as after a restart.
+	s, err = NewMockStore(ctxWithMetrics, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Cache().Delete(loaded.String(), 0)
+		s.Cache().Delete(relayed.String(), 0)
+	})
+
+	for d, expected := range map[digest.Digest]bool{loaded: true, relayed: true, corrupt: false, unsigned: false} {
+		if got := s.Cache().Exists(d.String(), 0); got != expected {
+			t.Errorf("expected %v to be loaded: %v, got %v", d, expected, got)
+		}
+
+		if _, got := s.Manifests().Get(d, 0); got != expected {
+			t.Errorf("expected entry of %v to be loaded: %v, got %v", d, expected, got)
+		}
+	}
+
+	select {
+	case got := <-s.Subscribe():
+		if expected := files.FileChunkKey(loaded.String(), 0, int64(files.CacheBlockSize)); got != expected {
+			t.Errorf("expected %v to be advertised, got %v", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected loaded chunk to be advertised")
+	}
+
+	// The relayed chunk is served, but not advertised until its blob is verified.
+	select {
+	case got := <-s.Subscribe():
+		t.Errorf("expected only the loaded chunk to be advertised, got %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
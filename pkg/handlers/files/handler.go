@@ -4,15 +4,27 @@ package files
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
+	"github.com/azure/peerd/pkg/files"
 	"github.com/azure/peerd/pkg/files/store"
 	"github.com/azure/peerd/pkg/metrics"
+	"github.com/opencontainers/go-digest"
+	"github.com/rs/zerolog"
 )
 
+// errNotFromAPeer indicates that a request for a chunk was not sent by a peer.
+var errNotFromAPeer = errors.New("chunks are only served to peers")
+
 // FilesHandler describes a handler for files.
 type FilesHandler struct {
 	store           store.FilesStore
@@ -30,7 +42,33 @@ func (h *FilesHandler) Handle(c pcontext.Context) {
 		log.Debug().Dur("duration", dur).Msg("files handler stop")
 	}()
 
-	err := h.fill(c)
+	h.serve(log, c, h.fill)
+}
+
+// HandleChunk handles a request from a peer for a chunk of a blob, identified by its digest and an offset in the chunk.
+// The chunk is only served if it is cached, and requests that are not from a peer are refused.
+func (h *FilesHandler) HandleChunk(c pcontext.Context) {
+	log := pcontext.Logger(c).With().Str("digest", c.Param("digest")).Str("offset", c.Param("offset")).Logger()
+	log.Debug().Msg("chunks handler start")
+	s := time.Now()
+	defer func() {
+		dur := time.Since(s)
+		h.metricsRecorder.RecordRequest(c.Request.Method, "chunks", float64(dur.Milliseconds()))
+		log.Debug().Dur("duration", dur).Msg("chunks handler stop")
+	}()
+
+	if !pcontext.IsRequestFromAPeer(c) {
+		// nolint
+		c.AbortWithError(http.StatusForbidden, errNotFromAPeer)
+		return
+	}
+
+	h.serve(log, c, h.fillChunk)
+}
+
+// serve serves the requested file after filling the context with fill.
+func (h *FilesHandler) serve(log zerolog.Logger, c pcontext.Context, fill func(pcontext.Context) error) {
+	err := fill(c)
 	if err != nil {
 		log.Debug().Err(err).Msg("failed to fill context")
 		// nolint
@@ -43,6 +81,10 @@ func (h *FilesHandler) Handle(c pcontext.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	if err == store.ErrWithdrawn {
+		c.AbortWithStatus(http.StatusGone)
+		return
+	}
 	if err != nil {
 		// nolint
 		c.AbortWithError(http.StatusInternalServerError, err)
@@ -56,9 +98,86 @@ func (h *FilesHandler) Handle(c pcontext.Context) {
 	w.Header().Set(pcontext.NodeHeaderKey, pcontext.NodeName)
 	w.Header().Set(pcontext.CorrelationHeaderKey, c.GetString(pcontext.CorrelationIdCtxKey))
 
+	// Let the requester verify the chunk.
+	if e, ok := h.store.Manifest(c); ok {
+		if v, err := e.Encode(); err == nil {
+			w.Header().Set(manifest.HeaderKey, v)
+		}
+	}
+
+	if h.serveSection(log, c, f) {
+		return
+	}
+
 	http.ServeContent(w, c.Request, "file", time.Now(), f)
 }
 
+// serveSection serves the requested range from the file of the cached chunk that holds it, with io.Copy so that the
+// kernel sends it with sendfile, instead of copying it through the heap. It returns false if the request is not for a
+// single range in a cached chunk, which is then served by http.ServeContent.
+func (h *FilesHandler) serveSection(log zerolog.Logger, c pcontext.Context, f store.File) bool {
+	if c.Request.Method != http.MethodGet {
+		return false
+	}
+
+	size, err := f.Fstat()
+	if err != nil {
+		return false
+	}
+
+	start, length, ok := parseRange(c.Request.Header.Get("Range"), size)
+	if !ok {
+		return false
+	}
+
+	section, err := f.Section(start, length)
+	if err != nil {
+		return false
+	}
+	defer section.Close()
+
+	w := c.Writer
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err := io.Copy(pcontext.BodyWriter(w), io.LimitReader(section, length)); err != nil {
+		log.Debug().Err(err).Msg("failed to serve cached section")
+	}
+
+	return true
+}
+
+// parseRange returns the start and length of the single range of a file of the given size in the given Range header,
+// such as "bytes=0-99" or "bytes=100-". Other ranges, such as multiple ranges, are not supported.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+
+	return start, end - start + 1, true
+}
+
 // fill fills the context with handler specific information.
 func (h *FilesHandler) fill(c pcontext.Context) error {
 	c.Set("handler", "files")
@@ -76,6 +195,29 @@ func (h *FilesHandler) fill(c pcontext.Context) error {
 	return nil
 }
 
+// fillChunk fills the context with the chunk requested by a peer.
+// Peers know a chunk by the digest of its blob only, so the blob URL is empty and the chunk cannot be read from origin.
+func (h *FilesHandler) fillChunk(c pcontext.Context) error {
+	c.Set("handler", "chunks")
+
+	d, err := digest.Parse(c.Param("digest"))
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(c.Param("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return fmt.Errorf("invalid chunk offset: %v", c.Param("offset"))
+	}
+
+	c.Set(pcontext.DigestCtxKey, d.String())
+	c.Set(pcontext.FileChunkCtxKey, files.FileChunkKey(d.String(), offset, int64(files.CacheBlockSize)))
+	c.Set(pcontext.BlobUrlCtxKey, "")
+	c.Set(pcontext.BlobRangeCtxKey, c.Request.Header.Get("Range"))
+
+	return nil
+}
+
 // New creates a new files handler.
 func New(ctx context.Context, fs store.FilesStore) *FilesHandler {
 	return &FilesHandler{fs, metrics.FromContext(ctx)}
@@ -11,11 +11,13 @@ import (
 	"testing"
 
 	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/azure/peerd/pkg/discovery/content/manifest"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/files"
 	"github.com/azure/peerd/pkg/files/store"
 	"github.com/azure/peerd/pkg/metrics"
 	"github.com/gin-gonic/gin"
+	"github.com/opencontainers/go-digest"
 )
 
 var (
@@ -47,7 +49,8 @@ func TestPartialContentResponseInP2PMode(t *testing.T) {
 	}
 
 	store.PrefetchWorkers = 0 // turn off prefetching
-	s, err := store.NewMockStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	router := mocks.NewMockRouter(make(map[string][]string))
+	s, err := store.NewMockStore(ctxWithMetrics, router)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -63,6 +66,16 @@ func TestPartialContentResponseInP2PMode(t *testing.T) {
 		return []byte(content), nil
 	})
 
+	// Only chunks vouched for by this host are served to peers.
+	e, err := manifest.NewEntry(router.Identity(), digest.Digest(expD), 10, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Manifests().Put(e)
+
+	// Otherwise, the stores of the following tests would load the chunk.
+	t.Cleanup(func() { s.Cache().Delete(expD, 10) })
+
 	pctx := pcontext.FromContext(ctx)
 
 	h.Handle(pctx)
@@ -79,6 +92,15 @@ func TestPartialContentResponseInP2PMode(t *testing.T) {
 	if string(ret) != content[2:] {
 		t.Errorf("expected %v, got %v", content[2:], ret)
 	}
+
+	// The chunk is served with its manifest entry, even for a sub-range.
+	got, err := manifest.Decode(resp.Header.Get(manifest.HeaderKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Verify(digest.Digest(expD), 10, []byte(content)); err != nil {
+		t.Errorf("expected served entry to verify, got %v", err)
+	}
 }
 
 func TestNotFoundInP2PMode(t *testing.T) {
@@ -114,6 +136,40 @@ func TestNotFoundInP2PMode(t *testing.T) {
 	}
 }
 
+func TestGoneInP2PMode(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", files.CacheBlockSize, files.CacheBlockSize+172))
+	req.Header.Set(pcontext.P2PHeaderKey, "true")
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+	ctx.Params = []gin.Param{
+		{Key: "url", Value: hostAndPath},
+	}
+
+	expD := "sha256:d18c7a64c5158179bdee531a663c5b487de57ff17cff3af29a51c7e70b491d9d"
+	r := mocks.NewMockRouter(make(map[string][]string))
+	if err := r.Withdraw(ctxWithMetrics, []string{files.FileChunkKey(expD, int64(files.CacheBlockSize), int64(files.CacheBlockSize))}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.PrefetchWorkers = 0 // turn off prefetching
+	s, err := store.NewFilesStore(ctxWithMetrics, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := New(ctxWithMetrics, s)
+
+	h.Handle(pcontext.FromContext(ctx))
+	if ctx.Writer.Status() != http.StatusGone {
+		t.Errorf("expected %v, got %v", http.StatusGone, ctx.Writer.Status())
+	}
+}
+
 func TestFill(t *testing.T) {
 	// Create a new request with a URL that has a query string.
 	req, err := http.NewRequest("GET", "http://127.0.0.1:5000/blobs/"+u, nil)
@@ -160,3 +216,105 @@ func TestFill(t *testing.T) {
 		t.Errorf("expected %v, got %v", hostAndPath+query, ctx.GetString(pcontext.BlobUrlCtxKey))
 	}
 }
+
+func TestHandleChunk(t *testing.T) {
+	files.CacheBlockSize = 10
+	expD := digest.FromString("chunk").String()
+
+	store.PrefetchWorkers = 0 // turn off prefetching
+	s, err := store.NewMockStore(ctxWithMetrics, mocks.NewMockRouter(make(map[string][]string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "0123456789"
+	s.Cache().PutSize(expD, 200)
+	// nolint:errcheck
+	s.Cache().GetOrCreate(expD, 10, 10, func() ([]byte, error) {
+		return []byte(content), nil
+	})
+
+	h := New(ctxWithMetrics, s)
+
+	tests := []struct {
+		name           string
+		digest         string
+		offset         string
+		fromPeer       bool
+		expectedStatus int
+	}{
+		{name: "cached chunk", digest: expD, offset: "12", fromPeer: true, expectedStatus: http.StatusPartialContent},
+		{name: "not from a peer", digest: expD, offset: "12", expectedStatus: http.StatusForbidden},
+		{name: "invalid digest", digest: "sha256:1234", offset: "12", fromPeer: true, expectedStatus: http.StatusBadRequest},
+		{name: "invalid offset", digest: expD, offset: "-1", fromPeer: true, expectedStatus: http.StatusBadRequest},
+		{name: "chunk not cached", digest: expD, offset: "22", fromPeer: true, expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://127.0.0.1:5001/p2p/v1/chunks/"+tt.digest+"/"+tt.offset, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Range", "bytes="+tt.offset+"-19")
+			if tt.fromPeer {
+				req.Header.Set(pcontext.P2PHeaderKey, "true")
+			}
+
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = req
+			ctx.Params = []gin.Param{{Key: "digest", Value: tt.digest}, {Key: "offset", Value: tt.offset}}
+
+			h.HandleChunk(pcontext.FromContext(ctx))
+			resp := recorder.Result()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected %v, got %v", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if resp.StatusCode != http.StatusPartialContent {
+				return
+			}
+
+			ret, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(ret) != content[2:] {
+				t.Errorf("expected %v, got %v", content[2:], string(ret))
+			}
+
+			if v := resp.Header.Get("Content-Range"); v != "bytes 12-19/200" {
+				t.Errorf("expected size of the blob in Content-Range, got %v", v)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		length int64
+		ok     bool
+	}{
+		{header: "bytes=0-99", start: 0, length: 100, ok: true},
+		{header: "bytes=12-19", start: 12, length: 8, ok: true},
+		{header: "bytes=150-", start: 150, length: 50, ok: true},
+		{header: "bytes=150-500", start: 150, length: 50, ok: true},
+		{header: "", ok: false},
+		{header: "bytes=-100", ok: false},
+		{header: "bytes=0-9,20-29", ok: false},
+		{header: "bytes=20-10", ok: false},
+		{header: "bytes=200-", ok: false},
+		{header: "items=0-9", ok: false},
+	}
+
+	for _, tt := range tests {
+		start, length, ok := parseRange(tt.header, 200)
+		if ok != tt.ok || start != tt.start || length != tt.length {
+			t.Errorf("%q: expected %v, %v, %v, got %v, %v, %v", tt.header, tt.start, tt.length, tt.ok, start, length, ok)
+		}
+	}
+}
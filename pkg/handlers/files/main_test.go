@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/azure/peerd/pkg/cache"
+	"github.com/azure/peerd/pkg/files/store"
 )
 
 func TestMain(m *testing.M) {
@@ -24,14 +25,19 @@ func TestMain(m *testing.M) {
 func setup() {
 	suf := newRandomStringN(10)
 	cache.Path += suf
+	store.ManifestsPath += suf
 }
 
-// teardown removes the cache directory.
+// teardown removes the cache and manifests directories.
 func teardown() error {
 	if err := os.RemoveAll(cache.Path); err != nil {
 		return fmt.Errorf("failed to remove cache dir: %v --- %v", cache.Path, err)
 	}
 
+	if err := os.RemoveAll(store.ManifestsPath); err != nil {
+		return fmt.Errorf("failed to remove manifests dir: %v --- %v", store.ManifestsPath, err)
+	}
+
 	return nil
 }
 
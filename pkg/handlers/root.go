@@ -12,7 +12,9 @@ import (
 	"github.com/azure/peerd/pkg/discovery/routing"
 	filesStore "github.com/azure/peerd/pkg/files/store"
 	"github.com/azure/peerd/pkg/handlers/files"
+	"github.com/azure/peerd/pkg/handlers/upload"
 	v2 "github.com/azure/peerd/pkg/handlers/v2"
+	"github.com/azure/peerd/pkg/peernet"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 )
@@ -20,8 +22,17 @@ import (
 var fh *files.FilesHandler
 var v2h *v2.V2Handler
 
+// Options describes the configuration of the handler.
+type Options struct {
+	// MutualTLS indicates that requests are only from a peer if it presented a verified client certificate.
+	MutualTLS bool
+
+	// Upload limits the uploads to peers.
+	Upload upload.Config
+}
+
 // Server creates a new HTTP server.
-func Handler(ctx context.Context, r routing.Router, containerdStore containerd.Store, fs filesStore.FilesStore) (http.Handler, error) {
+func Handler(ctx context.Context, r routing.Router, containerdStore containerd.Store, fs filesStore.FilesStore, o Options) (http.Handler, error) {
 	var err error
 	fh = files.New(ctx, fs)
 
@@ -30,14 +41,17 @@ func Handler(ctx context.Context, r routing.Router, containerdStore containerd.S
 		return nil, err
 	}
 
-	engine := newEngine(ctx)
-	registerRoutes(engine, fileHandler, v2Handler)
+	engine := newEngine(ctx, o.MutualTLS)
+	if o.Upload.Enabled() {
+		engine.Use(upload.New(o.Upload).Middleware())
+	}
+	registerRoutes(engine, fileHandler, chunkHandler, v2Handler)
 
 	return engine, nil
 }
 
 // newEngine creates a new gin engine.
-func newEngine(ctx context.Context) *gin.Engine {
+func newEngine(ctx context.Context, mutualTLS bool) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.New()
 
@@ -48,6 +62,9 @@ func newEngine(ctx context.Context) *gin.Engine {
 		pc := pcontext.FromContext(c)
 
 		pcontext.FillCorrelationId(pc)
+		if _, ok := peernet.StreamPeerID(c.Request); ok || mutualTLS {
+			fillPeerId(pc)
+		}
 		c.Set(pcontext.LoggerCtxKey, baseLog)
 
 		l := pcontext.Logger(pc)
@@ -80,10 +97,13 @@ func newEngine(ctx context.Context) *gin.Engine {
 }
 
 // registerRoutes registers the routes for the HTTP server.
-func registerRoutes(engine *gin.Engine, f, v gin.HandlerFunc) {
+func registerRoutes(engine *gin.Engine, f, ch, v gin.HandlerFunc) {
 	engine.HEAD("/blobs/*url", f)
 	engine.GET("/blobs/*url", f)
 
+	engine.HEAD("/p2p/v1/chunks/:digest/:offset", ch)
+	engine.GET("/p2p/v1/chunks/:digest/:offset", ch)
+
 	engine.HEAD("/v2", v)
 	engine.GET("/v2", v)
 	engine.HEAD("/v2/*ref", v)
@@ -100,6 +120,18 @@ func fileHandler(c *gin.Context) {
 	fh.Handle(pcontext.FromContext(c))
 }
 
+// chunkHandler is a handler function for the /p2p/v1/chunks API, which is only used by peers
+// @Summary Get a cached chunk of a blob by digest
+// @Param digest path string true "The digest of the blob"
+// @Param offset path int true "An offset in the chunk"
+// @Success 206 {string} string "The chunk content"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Router /p2p/v1/chunks/{digest}/{offset} [get]
+func chunkHandler(c *gin.Context) {
+	fh.HandleChunk(pcontext.FromContext(c))
+}
+
 // v2Handler is a handler function for the /v2 API
 // @Summary Get a manifest or a blob by repository and reference or digest
 // @Param repo path string true "The repository name"
@@ -112,3 +144,19 @@ func fileHandler(c *gin.Context) {
 func v2Handler(c *gin.Context) {
 	v2h.Handle(pcontext.FromContext(c))
 }
+
+// fillPeerId fills the ID of the peer that sent the request over a libp2p stream or presented a verified client
+// certificate in the context. If the request was made over neither, the peer ID is empty.
+func fillPeerId(c pcontext.Context) {
+	if pid, ok := peernet.StreamPeerID(c.Request); ok {
+		c.Set(pcontext.PeerIdCtxKey, pid.String())
+		return
+	}
+
+	pid, err := peernet.PeerID(c.Request.TLS)
+	if err != nil {
+		c.Set(pcontext.PeerIdCtxKey, "")
+		return
+	}
+	c.Set(pcontext.PeerIdCtxKey, pid.String())
+}
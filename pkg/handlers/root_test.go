@@ -4,15 +4,21 @@ package handlers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/azure/peerd/pkg/containerd"
+	pcontext "github.com/azure/peerd/pkg/context"
 	"github.com/azure/peerd/pkg/discovery/routing/mocks"
 	"github.com/azure/peerd/pkg/files/store"
 	"github.com/azure/peerd/pkg/metrics"
 	"github.com/gin-gonic/gin"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 )
 
 var (
@@ -26,7 +32,7 @@ var (
 func TestV2RoutesRegistrations(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	mc, me := gin.CreateTestContext(recorder)
-	registerRoutes(me, nil, simpleOKHandler)
+	registerRoutes(me, nil, nil, simpleOKHandler)
 
 	tests := []struct {
 		name           string
@@ -88,8 +94,35 @@ func TestV2RoutesRegistrations(t *testing.T) {
 	}
 }
 
+func TestChunkRoutesRegistrations(t *testing.T) {
+	engine := gin.New()
+	var digest, offset string
+	registerRoutes(engine, nil, func(c *gin.Context) {
+		digest, offset = c.Param("digest"), c.Param("offset")
+		c.Status(http.StatusOK)
+	}, nil)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest(method, "/p2p/v1/chunks/sha256:1234/1048576", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		engine.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected status code %d, got %d", method, http.StatusOK, recorder.Code)
+		}
+
+		if digest != "sha256:1234" || offset != "1048576" {
+			t.Errorf("%s: expected digest and offset params, got %v and %v", method, digest, offset)
+		}
+	}
+}
+
 func TestNewEngine(t *testing.T) {
-	engine := newEngine(ctxWithMetrics)
+	engine := newEngine(ctxWithMetrics, false)
 	if engine == nil {
 		t.Fatal("Expected non-nil engine, got nil")
 	}
@@ -111,7 +144,7 @@ func TestHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	h, err := Handler(ctxWithMetrics, mr, ms, mfs)
+	h, err := Handler(ctxWithMetrics, mr, ms, mfs, Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,3 +153,82 @@ func TestHandler(t *testing.T) {
 		t.Fatal("Expected non-nil handler, got nil")
 	}
 }
+
+func TestMutualTLSIgnoresHeader(t *testing.T) {
+	for _, tc := range []struct {
+		mutualTLS bool
+		expected  bool
+	}{
+		{mutualTLS: false, expected: true},
+		{mutualTLS: true, expected: false},
+	} {
+		fromPeer := false
+		engine := newEngine(ctxWithMetrics, tc.mutualTLS)
+		registerRoutes(engine, func(c *gin.Context) {
+			fromPeer = pcontext.IsRequestFromAPeer(pcontext.FromContext(c))
+		}, nil, nil)
+
+		req, err := http.NewRequest(http.MethodGet, "/blobs/https://registry/blob", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(pcontext.P2PHeaderKey, "true")
+
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+
+		if fromPeer != tc.expected {
+			t.Errorf("mutual TLS %v: expected request from a peer to be %v, got %v", tc.mutualTLS, tc.expected, fromPeer)
+		}
+	}
+}
+
+func TestFillPeerId(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := libp2ptls.NewIdentity(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, _ := id.ConfigForPeer("")
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://127.0.0.1:5001/blobs/fdsfsdsd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(pcontext.P2PHeaderKey, "true")
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+
+	pc := pcontext.FromContext(ctx)
+
+	// Without a verified certificate, the header is ignored.
+	fillPeerId(pc)
+	if pcontext.IsRequestFromAPeer(pc) {
+		t.Fatal("expected request without a peer certificate to not be from a peer")
+	}
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	fillPeerId(pc)
+
+	if got := pc.GetString(pcontext.PeerIdCtxKey); got != expected.String() {
+		t.Errorf("expected peer id %s, got %s", expected, got)
+	}
+
+	if !pcontext.IsRequestFromAPeer(pc) {
+		t.Error("expected request with a peer certificate to be from a peer")
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package upload
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RetryAfter is how long a peer is asked to wait when all upload slots are taken.
+	RetryAfter = 1 * time.Second
+
+	// peerIdleTimeout is how long the rate limiter of a peer is kept after its last upload.
+	peerIdleTimeout = 1 * time.Minute
+)
+
+// Config describes the limits of uploads to peers.
+// A zero value means unlimited.
+type Config struct {
+	// Rate is the maximum number of bytes per second uploaded to all peers.
+	Rate int64
+
+	// PeerRate is the maximum number of bytes per second uploaded to a single peer.
+	PeerRate int64
+
+	// MaxUploads is the maximum number of concurrent uploads to peers.
+	MaxUploads int
+}
+
+// Enabled returns true if any limit is set.
+func (c Config) Enabled() bool {
+	return c.Rate > 0 || c.PeerRate > 0 || c.MaxUploads > 0
+}
+
+// Limiter limits the bandwidth and the number of concurrent uploads to peers.
+type Limiter struct {
+	config Config
+	global *rate.Limiter
+	slots  chan struct{}
+
+	mx        sync.Mutex
+	peers     map[string]*peerLimiter
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// peerLimiter is the rate limiter of a single peer.
+type peerLimiter struct {
+	limiter  *rate.Limiter
+	active   int
+	lastUsed time.Time
+}
+
+// New creates a new upload limiter.
+func New(c Config) *Limiter {
+	l := &Limiter{
+		config: c,
+		peers:  map[string]*peerLimiter{},
+		now:    time.Now,
+	}
+
+	if c.Rate > 0 {
+		l.global = newRateLimiter(c.Rate)
+	}
+
+	if c.MaxUploads > 0 {
+		l.slots = make(chan struct{}, c.MaxUploads)
+	}
+
+	return l
+}
+
+// Middleware returns a handler that applies the limits to requests from peers.
+// If all upload slots are taken, the request is rejected with 503 and a Retry-After header, so that the peer can
+// try another peer right away.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pc := pcontext.FromContext(c)
+		if !pcontext.IsRequestFromAPeer(pc) {
+			c.Next()
+			return
+		}
+
+		if !l.acquire() {
+			log := pcontext.Logger(pc)
+			log.Debug().Int("slots", l.config.MaxUploads).Msg("upload slots full, rejecting peer")
+			c.Header("Retry-After", strconv.Itoa(int(RetryAfter.Seconds())))
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+
+		key := peerKey(pc)
+		limiters := []*rate.Limiter{}
+		if l.global != nil {
+			limiters = append(limiters, l.global)
+		}
+		if p := l.peer(key); p != nil {
+			limiters = append(limiters, p)
+			defer l.done(key)
+		}
+
+		if len(limiters) > 0 {
+			c.Writer = &limitedWriter{ResponseWriter: c.Writer, ctx: c.Request.Context(), limiters: limiters}
+		}
+
+		c.Next()
+	}
+}
+
+// acquire takes an upload slot, if one is free.
+func (l *Limiter) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees an upload slot.
+func (l *Limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// peer returns the rate limiter of the given peer, or nil if there is no per-peer limit.
+func (l *Limiter) peer(key string) *rate.Limiter {
+	if l.config.PeerRate <= 0 {
+		return nil
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastPrune) > peerIdleTimeout {
+		l.prune(now)
+	}
+
+	p, ok := l.peers[key]
+	if !ok {
+		p = &peerLimiter{limiter: newRateLimiter(l.config.PeerRate)}
+		l.peers[key] = p
+	}
+
+	p.active++
+	p.lastUsed = now
+	return p.limiter
+}
+
+// done marks an upload to the given peer as finished.
+func (l *Limiter) done(key string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if p, ok := l.peers[key]; ok {
+		p.active--
+		p.lastUsed = l.now()
+	}
+}
+
+// prune removes the rate limiters of peers without uploads for a while.
+// The caller must hold the lock.
+func (l *Limiter) prune(now time.Time) {
+	for key, p := range l.peers {
+		if p.active == 0 && now.Sub(p.lastUsed) > peerIdleTimeout {
+			delete(l.peers, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// peerKey identifies the peer of the request by its verified ID, or else by the IP of its connection.
+// Request headers, including forwarding headers, are set by the peer and so are never used, otherwise a peer could
+// change them on every request to get around its limit.
+func peerKey(c pcontext.Context) string {
+	if pid := c.GetString(pcontext.PeerIdCtxKey); pid != "" {
+		return pid
+	}
+
+	return c.RemoteIP()
+}
+
+// newRateLimiter creates a rate limiter of the given bytes per second, which allows up to a second's worth at once.
+func newRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// limitedWriter is a response writer that waits for all its rate limiters before writing.
+type limitedWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	limiters []*rate.Limiter
+
+	// burst is the reader of a single burst of ReadFrom, reused across bursts so that they do not allocate.
+	burst io.LimitedReader
+}
+
+var (
+	_ gin.ResponseWriter = &limitedWriter{}
+	_ io.ReaderFrom      = &limitedWriter{}
+)
+
+// Write writes the data in chunks no larger than the burst of any limiter.
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := w.burstSize(len(b))
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+// ReadFrom copies from r in chunks no larger than the burst of any limiter, like Write. Each chunk is copied to the
+// writer under it with io.Copy, so that files are still sent with sendfile.
+// The limiters are charged for a chunk before it is copied. A chunk is only cut short if r ends before its limit or
+// fails, which ends the copy, so at most one chunk is charged for bytes that were never sent.
+func (w *limitedWriter) ReadFrom(r io.Reader) (int64, error) {
+	// The reader of a file must stay a single io.LimitedReader of the file for sendfile to be used. Other readers are
+	// copied with Write, which charges the limiters for exactly the bytes written.
+	lr, ok := r.(*io.LimitedReader)
+	if !ok {
+		return io.Copy(writerOnly{w}, r)
+	}
+
+	dst := pcontext.BodyWriter(w.ResponseWriter)
+	written := int64(0)
+	for lr.N > 0 {
+		n := w.burstSize(int(min(lr.N, math.MaxInt32)))
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+
+		w.burst.R, w.burst.N = lr.R, int64(n)
+		m, err := io.Copy(dst, &w.burst)
+		lr.N -= m
+		written += m
+		if err != nil || m < int64(n) {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// burstSize returns the number of bytes out of n that can be written at once under all limiters.
+func (w *limitedWriter) burstSize(n int) int {
+	for _, l := range w.limiters {
+		n = min(n, l.Burst())
+	}
+	return n
+}
+
+// wait waits until all limiters allow n bytes.
+func (w *limitedWriter) wait(n int) error {
+	for _, l := range w.limiters {
+		if err := l.WaitN(w.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writerOnly hides the io.ReaderFrom of a writer, so that io.Copy writes to it with Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// WriteString writes the string with the limits of Write.
+func (w *limitedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
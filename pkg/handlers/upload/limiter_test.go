@@ -0,0 +1,234 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	pcontext "github.com/azure/peerd/pkg/context"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+)
+
+// newTestEngine creates an engine that serves size bytes on /blob after the limiter, copies them with io.Copy on
+// /copy, and blocks on /wait until release is closed.
+func newTestEngine(l *Limiter, size int, release chan struct{}) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	log := zerolog.Nop()
+	engine.Use(func(c *gin.Context) {
+		c.Set(pcontext.LoggerCtxKey, &log)
+	})
+	engine.Use(l.Middleware())
+	engine.GET("/blob", func(c *gin.Context) {
+		// nolint:errcheck
+		c.Writer.Write(bytes.Repeat([]byte("a"), size))
+	})
+	engine.GET("/copy", func(c *gin.Context) {
+		// nolint:errcheck
+		io.Copy(pcontext.BodyWriter(c.Writer), io.LimitReader(bytes.NewReader(bytes.Repeat([]byte("a"), size)), int64(size)))
+	})
+	engine.GET("/wait", func(c *gin.Context) {
+		<-release
+	})
+	return engine
+}
+
+// request serves a request to the given path, from the peer at the given IP if it is not empty.
+func request(engine *gin.Engine, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if ip != "" {
+		req.RemoteAddr = ip + ":5001"
+		req.Header.Set(pcontext.P2PHeaderKey, "true")
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestSlotsFull(t *testing.T) {
+	release := make(chan struct{})
+	engine := newTestEngine(New(Config{MaxUploads: 1}), 10, release)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		request(engine, "/wait", "10.0.0.1")
+	}()
+
+	// Wait for the first upload to take the only slot.
+	deadline := time.Now().Add(time.Second)
+	var w *httptest.ResponseRecorder
+	for time.Now().Before(deadline) {
+		w = request(engine, "/blob", "10.0.0.2")
+		if w.Code == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d when slots are full, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After of 1 second, got %q", got)
+	}
+
+	// Requests that are not from peers are not limited.
+	if w := request(engine, "/blob", ""); w.Code != http.StatusOK {
+		t.Errorf("expected local request to be served, got %d", w.Code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if w := request(engine, "/blob", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("expected request to be served once a slot is free, got %d", w.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		path   string
+	}{
+		{name: "global", config: Config{Rate: 1000}, path: "/blob"},
+		{name: "peer", config: Config{PeerRate: 1000}, path: "/blob"},
+		{name: "copy", config: Config{Rate: 1000}, path: "/copy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := newTestEngine(New(tc.config), 1500, nil)
+
+			// The first second's worth is the burst, the rest is written at the rate.
+			start := time.Now()
+			w := request(engine, tc.path, "10.0.0.1")
+			elapsed := time.Since(start)
+
+			if w.Code != http.StatusOK || w.Body.Len() != 1500 {
+				t.Fatalf("expected 1500 bytes, got %d with status %d", w.Body.Len(), w.Code)
+			}
+
+			if elapsed < 400*time.Millisecond {
+				t.Errorf("expected upload to be rate limited, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestPeerRateIsPerPeer(t *testing.T) {
+	l := New(Config{PeerRate: 1000})
+	engine := newTestEngine(l, 1000, nil)
+
+	// Each peer has its own burst.
+	start := time.Now()
+	request(engine, "/blob", "10.0.0.1")
+	request(engine, "/blob", "10.0.0.2")
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected peers to be limited separately, took %v", elapsed)
+	}
+
+	if len(l.peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(l.peers))
+	}
+
+	// Idle peers are pruned.
+	now := time.Now().Add(2 * peerIdleTimeout)
+	l.now = func() time.Time { return now }
+	request(engine, "/blob", "10.0.0.3")
+
+	if len(l.peers) != 1 {
+		t.Errorf("expected idle peers to be pruned, got %d peers", len(l.peers))
+	}
+}
+
+func TestPeerRateIgnoresHeaders(t *testing.T) {
+	l := New(Config{PeerRate: 1000})
+	engine := newTestEngine(l, 1000, nil)
+
+	// A peer that changes its node name and forwarding headers is still limited by the IP of its connection.
+	start := time.Now()
+	for _, node := range []string{"node-1", "node-2"} {
+		req := httptest.NewRequest(http.MethodGet, "/blob", nil)
+		req.RemoteAddr = "10.0.0.1:5001"
+		req.Header.Set(pcontext.P2PHeaderKey, "true")
+		req.Header.Set(pcontext.NodeHeaderKey, node)
+		req.Header.Set("X-Forwarded-For", "10.1.0."+node[len(node)-1:])
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expected peer to be limited once, took %v", elapsed)
+	}
+
+	if len(l.peers) != 1 {
+		t.Errorf("expected 1 peer, got %d", len(l.peers))
+	}
+}
+
+func TestConfigEnabled(t *testing.T) {
+	if (Config{}).Enabled() {
+		t.Error("expected zero config to be disabled")
+	}
+
+	if !(Config{MaxUploads: 1}).Enabled() {
+		t.Error("expected config with a limit to be enabled")
+	}
+}
+
+// readerFromRecorder is a response recorder that copies bodies with io.ReaderFrom, like the writer of net/http.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (r readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(io.Discard, src)
+}
+
+func TestReadFromDoesNotAllocate(t *testing.T) {
+	c, _ := gin.CreateTestContext(readerFromRecorder{httptest.NewRecorder()})
+	w := &limitedWriter{ResponseWriter: c.Writer, ctx: context.Background(), limiters: []*rate.Limiter{rate.NewLimiter(rate.Inf, 100)}}
+
+	data := bytes.Repeat([]byte("a"), 1000)
+	src := bytes.NewReader(data)
+	lr := &io.LimitedReader{}
+	allocs := testing.AllocsPerRun(10, func() {
+		src.Reset(data)
+		lr.R, lr.N = src, int64(len(data))
+		if n, err := w.ReadFrom(lr); err != nil || n != int64(len(data)) {
+			t.Fatalf("expected %d bytes, got %d: %v", len(data), n, err)
+		}
+	})
+
+	// The race detector allocates on its own, but not once per burst.
+	if bursts := float64(len(data) / 100); allocs >= bursts {
+		t.Errorf("expected bursts to not allocate, got %v allocations for %v bursts", allocs, bursts)
+	}
+}
+
+func TestReadFromChargesBytesWritten(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	l := newRateLimiter(1000)
+	w := &limitedWriter{ResponseWriter: c.Writer, ctx: context.Background(), limiters: []*rate.Limiter{l}}
+
+	// A reader of unknown length ends within a burst, and only the bytes written are charged.
+	if n, err := w.ReadFrom(bytes.NewReader(bytes.Repeat([]byte("a"), 300))); err != nil || n != 300 {
+		t.Fatalf("expected 300 bytes, got %d: %v", n, err)
+	}
+
+	if tokens := l.Tokens(); tokens < 699 || tokens > 710 {
+		t.Errorf("expected 300 bytes to be charged, %v tokens left", tokens)
+	}
+}
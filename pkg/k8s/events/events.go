@@ -64,7 +64,12 @@ func NewRecorder(ctx context.Context, k *k8s.ClientSet) (EventRecorder, error) {
 }
 
 // WithContext returns a new context with an event recorder.
+// If clientset is nil, such as when running outside of Kubernetes, events are discarded.
 func WithContext(ctx context.Context, clientset *k8s.ClientSet) (context.Context, error) {
+	if clientset == nil {
+		return context.WithValue(ctx, eventsRecorderCtxKey, &noopRecorder{}), nil
+	}
+
 	er, err := NewRecorder(ctx, clientset)
 	if err != nil {
 		return nil, err
@@ -108,4 +113,40 @@ func (er *eventRecorder) Initializing() {
 	er.recorder.Eventf(er.objRef, v1.EventTypeNormal, "P2PInitializing", "P2P proxy is initializing on instance %s", er.objRef.Name)
 }
 
+// HandshakeTimedOut should be called to indicate that an inbound connection from the given address was not secured in time.
+func (er *eventRecorder) HandshakeTimedOut(addr string) {
+	er.recorder.Eventf(er.objRef, v1.EventTypeWarning, "P2PHandshakeTimeout", "P2P proxy did not complete a handshake with %s in time on instance %s", addr, er.objRef.Name)
+}
+
+// DiskPressure should be called to indicate that the file system holding the cache is under pressure.
+func (er *eventRecorder) DiskPressure(path string, percent int) {
+	er.recorder.Eventf(er.objRef, v1.EventTypeWarning, "P2PDiskPressure", "P2P proxy cache at %s is under disk pressure (%d%% used) on instance %s", path, percent, er.objRef.Name)
+}
+
 var _ EventRecorder = &eventRecorder{}
+
+// noopRecorder is an EventRecorder that discards all events.
+type noopRecorder struct{}
+
+// Active discards the event.
+func (*noopRecorder) Active() {}
+
+// Connected discards the event.
+func (*noopRecorder) Connected() {}
+
+// Disconnected discards the event.
+func (*noopRecorder) Disconnected() {}
+
+// Failed discards the event.
+func (*noopRecorder) Failed() {}
+
+// Initializing discards the event.
+func (*noopRecorder) Initializing() {}
+
+// HandshakeTimedOut discards the event.
+func (*noopRecorder) HandshakeTimedOut(addr string) {}
+
+// DiskPressure discards the event.
+func (*noopRecorder) DiskPressure(path string, percent int) {}
+
+var _ EventRecorder = &noopRecorder{}
@@ -48,6 +48,27 @@ func TestWithContext(t *testing.T) {
 	}
 }
 
+func TestWithContextOutsideKubernetes(t *testing.T) {
+	ctx, err := WithContext(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	er, ok := FromContext(ctx).(*noopRecorder)
+	if !ok {
+		t.Fatal("expected noop event recorder")
+	}
+
+	// Events are discarded.
+	er.Initializing()
+	er.Connected()
+	er.Active()
+	er.Disconnected()
+	er.Failed()
+	er.HandshakeTimedOut("/ip4/10.0.0.1/tcp/5003")
+	er.DiskPressure("/tmp/distribution/peerd/cache", 90)
+}
+
 func TestNewRecorderInNode(t *testing.T) {
 	ns := "test-ns"
 
@@ -138,6 +159,8 @@ func TestExpectedEvents(t *testing.T) {
 	er.Disconnected()
 	er.Initializing()
 	er.Failed()
+	er.HandshakeTimedOut("/ip4/10.0.0.1/tcp/5003")
+	er.DiskPressure("/tmp/distribution/peerd/cache", 90)
 }
 
 func TestFromContext(t *testing.T) {
@@ -175,7 +198,7 @@ func (*testRecorder) Event(object runtime.Object, eventtype string, reason strin
 
 // Eventf implements record.EventRecorder.
 func (t *testRecorder) Eventf(object runtime.Object, eventtype string, reason string, messageFmt string, args ...interface{}) {
-	if reason != "P2PActive" && reason != "P2PConnected" && reason != "P2PDisconnected" && reason != "P2PInitializing" && reason != "P2PFailed" {
+	if reason != "P2PActive" && reason != "P2PConnected" && reason != "P2PDisconnected" && reason != "P2PInitializing" && reason != "P2PFailed" && reason != "P2PHandshakeTimeout" && reason != "P2PDiskPressure" {
 		t.t.Errorf("unexpected reason: %s", reason)
 	}
 }
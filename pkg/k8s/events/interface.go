@@ -18,4 +18,13 @@ type EventRecorder interface {
 
 	// Failed should be called to indicate that the node has failed.
 	Failed()
+
+	// HandshakeTimedOut should be called to indicate that an inbound connection from the given address was not secured
+	// within the handshake timeout. This is the case for peers that do not share the private network key of the node,
+	// but also for port scans and dropped connections.
+	HandshakeTimedOut(addr string)
+
+	// DiskPressure should be called to indicate that the file system holding the cache at the given path is the given
+	// percentage full, and that cached chunks are being evicted.
+	DiskPressure(path string, percent int)
 }
@@ -3,8 +3,11 @@
 package k8s
 
 import (
+	"context"
+	"fmt"
 	"os"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -12,6 +15,9 @@ import (
 
 const (
 	peerdDefaultNamespace = "peerd-ns"
+
+	// ZoneLabel is the well-known label of a node that holds its zone.
+	ZoneLabel = "topology.kubernetes.io/zone"
 )
 
 // ClientSet is an interface for k8s API server.
@@ -57,6 +63,41 @@ func NewKubernetesInterface(kubeConfigPath, name string) (*ClientSet, error) {
 	return k, nil
 }
 
+// NodeZone returns the zone of the node this process runs on, or an empty string if the node has no zone label.
+// When running in a pod, the node is the one the pod is scheduled on.
+func (k *ClientSet) NodeZone(ctx context.Context) (string, error) {
+	nodeName := k.Name
+	if k.InPod {
+		pod, err := k.CoreV1().Pods(k.Namespace).Get(ctx, k.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		nodeName = pod.Spec.NodeName
+	}
+
+	node, err := k.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return node.Labels[ZoneLabel], nil
+}
+
+// SecretData returns the value of the given key of the given secret, in the namespace of this process.
+func (k *ClientSet) SecretData(ctx context.Context, name, key string) ([]byte, error) {
+	secret, err := k.CoreV1().Secrets(k.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", k.Namespace, name, key)
+	}
+
+	return b, nil
+}
+
 // getPodNamespace returns the namespace in which the pod is running or the default namespace.
 // Ref: https://kubernetes.io/docs/tasks/run-application/access-api-from-pod/
 func getPodNamespace() string {
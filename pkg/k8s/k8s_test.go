@@ -3,8 +3,14 @@
 package k8s
 
 import (
+	"context"
 	"os"
 	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestEmptyConfigOutsidePod(t *testing.T) {
@@ -34,3 +40,104 @@ func TestGetPodNamespace(t *testing.T) {
 	// Unset NAMESPACE.
 	os.Unsetenv("NAMESPACE")
 }
+
+func TestNodeZone(t *testing.T) {
+	objs := []runtime.Object{
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "zoned-node",
+				Labels: map[string]string{ZoneLabel: "eastus-1"},
+			},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "unzoned-node",
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "peerd-pod",
+				Namespace: peerdDefaultNamespace,
+			},
+			Spec: v1.PodSpec{NodeName: "zoned-node"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		cs          *ClientSet
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "node with zone",
+			cs:       &ClientSet{Name: "zoned-node"},
+			expected: "eastus-1",
+		},
+		{
+			name:     "node without zone",
+			cs:       &ClientSet{Name: "unzoned-node"},
+			expected: "",
+		},
+		{
+			name:     "pod on node with zone",
+			cs:       &ClientSet{Name: "peerd-pod", InPod: true, Namespace: peerdDefaultNamespace},
+			expected: "eastus-1",
+		},
+		{
+			name:        "unknown node",
+			cs:          &ClientSet{Name: "unknown-node"},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cs.Interface = fake.NewSimpleClientset(objs...)
+
+			got, err := tc.cs.NodeZone(context.Background())
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.expected {
+				t.Errorf("expected zone %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSecretData(t *testing.T) {
+	cs := &ClientSet{
+		Namespace: peerdDefaultNamespace,
+		Interface: fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "peerd-psk",
+				Namespace: peerdDefaultNamespace,
+			},
+			Data: map[string][]byte{"swarm.key": []byte("secret")},
+		}),
+	}
+
+	got, err := cs.SecretData(context.Background(), "peerd-psk", "swarm.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "secret" {
+		t.Errorf("expected secret data %q, got %q", "secret", got)
+	}
+
+	if _, err := cs.SecretData(context.Background(), "peerd-psk", "unknown"); err == nil {
+		t.Error("expected error for unknown key")
+	}
+
+	if _, err := cs.SecretData(context.Background(), "unknown", "swarm.key"); err == nil {
+		t.Error("expected error for unknown secret")
+	}
+}
@@ -22,6 +22,31 @@ type Metrics interface {
 
 	// RecordUpstreamResponse records the time it takes for an upstream to respond for a key.
 	RecordUpstreamResponse(hostname, key, op string, duration float64, count int64)
+
+	// RecordUpstreamBreakerState records the state of the circuit breaker of an upstream host: 0 when closed, 1 when
+	// half-open and 2 when open.
+	RecordUpstreamBreakerState(hostname string, state int)
+
+	// RecordLookupCache records the result of a query to the peer lookup cache, such as a hit or a miss.
+	RecordLookupCache(result string)
+
+	// RecordProvideQueueDepth records the number of keys waiting to be advertised.
+	RecordProvideQueueDepth(depth int)
+
+	// RecordProvideDuration records the time it takes to advertise a key.
+	RecordProvideDuration(duration float64)
+
+	// RecordPeerConnection records whether a request to a peer used a new or a reused connection.
+	RecordPeerConnection(result string)
+
+	// RecordPeerHandshake records the result of a TLS handshake with a peer.
+	RecordPeerHandshake(result string)
+
+	// RecordChunkCache records the result of a lookup of a chunk in a tier of the file cache, such as a hit in memory.
+	RecordChunkCache(tier, result string)
+
+	// RecordVerification records the result of verifying content, of the given kind such as a chunk or a blob.
+	RecordVerification(kind, result string)
 }
 
 // WithContext returns a new context with an metrics recorder.
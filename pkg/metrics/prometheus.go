@@ -16,6 +16,14 @@ type promMetrics struct {
 	peerDiscoveryDuration *prometheus.HistogramVec
 	peerResponseSpeed     *prometheus.HistogramVec
 	upstreamResponseSpeed *prometheus.HistogramVec
+	upstreamBreakerState  *prometheus.GaugeVec
+	lookupCacheTotal      *prometheus.CounterVec
+	provideQueueDepth     *prometheus.GaugeVec
+	provideDuration       *prometheus.HistogramVec
+	peerConnectionsTotal  *prometheus.CounterVec
+	peerHandshakesTotal   *prometheus.CounterVec
+	verificationsTotal    *prometheus.CounterVec
+	chunkCacheTotal       *prometheus.CounterVec
 }
 
 var _ Metrics = &promMetrics{}
@@ -45,6 +53,54 @@ func (m *promMetrics) RecordUpstreamResponse(hostname string, key string, op str
 	m.upstreamResponseSpeed.WithLabelValues(m.name, hostname, op).Observe(bps / float64(1024*1024))
 }
 
+// RecordUpstreamBreakerState records the state of the circuit breaker of an upstream host.
+// It sets the Prometheus gauge for the given hostname.
+func (m *promMetrics) RecordUpstreamBreakerState(hostname string, state int) {
+	m.upstreamBreakerState.WithLabelValues(m.name, hostname).Set(float64(state))
+}
+
+// RecordLookupCache records the result of a peer lookup cache query.
+// It increments the Prometheus counter for the given result.
+func (m *promMetrics) RecordLookupCache(result string) {
+	m.lookupCacheTotal.WithLabelValues(m.name, result).Inc()
+}
+
+// RecordProvideQueueDepth records the number of keys waiting to be advertised.
+// It sets the Prometheus gauge for the provide queue depth.
+func (m *promMetrics) RecordProvideQueueDepth(depth int) {
+	m.provideQueueDepth.WithLabelValues(m.name).Set(float64(depth))
+}
+
+// RecordProvideDuration records the duration of advertising a key.
+// It updates the Prometheus metric for provide duration.
+func (m *promMetrics) RecordProvideDuration(duration float64) {
+	m.provideDuration.WithLabelValues(m.name).Observe(duration)
+}
+
+// RecordPeerConnection records whether a request to a peer used a new or a reused connection.
+// It increments the Prometheus counter for the given result.
+func (m *promMetrics) RecordPeerConnection(result string) {
+	m.peerConnectionsTotal.WithLabelValues(m.name, result).Inc()
+}
+
+// RecordPeerHandshake records the result of a TLS handshake with a peer.
+// It increments the Prometheus counter for the given result.
+func (m *promMetrics) RecordPeerHandshake(result string) {
+	m.peerHandshakesTotal.WithLabelValues(m.name, result).Inc()
+}
+
+// RecordVerification records the result of verifying content of the given kind.
+// It increments the Prometheus counter for the given kind and result.
+func (m *promMetrics) RecordVerification(kind, result string) {
+	m.verificationsTotal.WithLabelValues(m.name, kind, result).Inc()
+}
+
+// RecordChunkCache records the result of a lookup of a chunk in a tier of the file cache.
+// It increments the Prometheus counter for the given tier and result.
+func (m *promMetrics) RecordChunkCache(tier, result string) {
+	m.chunkCacheTotal.WithLabelValues(m.name, tier, result).Inc()
+}
+
 // NewPromMetrics creates a new instance of promMetrics.
 func NewPromMetrics(reg prometheus.Registerer, name, prefix string) *promMetrics {
 
@@ -76,11 +132,68 @@ func NewPromMetrics(reg prometheus.Registerer, name, prefix string) *promMetrics
 	}, []string{"self", "hostname", "op"})
 	reg.MustRegister(upstreamResponseDurationHist)
 
+	upstreamBreakerStateGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "_upstream_breaker_state",
+		Help: "State of the circuit breaker of an upstream host, 0 when closed, 1 when half-open and 2 when open.",
+	}, []string{"self", "hostname"})
+	reg.MustRegister(upstreamBreakerStateGauge)
+
+	lookupCacheCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "_peer_lookup_cache_total",
+		Help: "Number of peer lookup cache queries by result.",
+	}, []string{"self", "result"})
+	reg.MustRegister(lookupCacheCounter)
+
+	provideQueueDepthGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prefix + "_provide_queue_depth",
+		Help: "Number of keys waiting to be advertised.",
+	}, []string{"self"})
+	reg.MustRegister(provideQueueDepthGauge)
+
+	provideDurationHist := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prefix + "_provide_duration_seconds",
+		Help:    "Duration of advertising a key in seconds.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"self"})
+	reg.MustRegister(provideDurationHist)
+
+	peerConnectionsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "_peer_connections_total",
+		Help: "Number of requests to peers by whether their connection was new or reused.",
+	}, []string{"self", "result"})
+	reg.MustRegister(peerConnectionsCounter)
+
+	peerHandshakesCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "_peer_tls_handshakes_total",
+		Help: "Number of TLS handshakes with peers by result.",
+	}, []string{"self", "result"})
+	reg.MustRegister(peerHandshakesCounter)
+
+	verificationsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "_verifications_total",
+		Help: "Number of verifications of content from peers by kind and result.",
+	}, []string{"self", "kind", "result"})
+	reg.MustRegister(verificationsCounter)
+
+	chunkCacheCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "_chunk_cache_total",
+		Help: "Number of lookups of chunks in the file cache by tier and result.",
+	}, []string{"self", "tier", "result"})
+	reg.MustRegister(chunkCacheCounter)
+
 	return &promMetrics{
 		name:                  name,
 		requestDuration:       requestDurationHist,
 		peerDiscoveryDuration: peerDiscoveryDurationHist,
 		peerResponseSpeed:     peerResponseDurationHist,
 		upstreamResponseSpeed: upstreamResponseDurationHist,
+		upstreamBreakerState:  upstreamBreakerStateGauge,
+		lookupCacheTotal:      lookupCacheCounter,
+		provideQueueDepth:     provideQueueDepthGauge,
+		provideDuration:       provideDurationHist,
+		peerConnectionsTotal:  peerConnectionsCounter,
+		peerHandshakesTotal:   peerHandshakesCounter,
+		verificationsTotal:    verificationsCounter,
+		chunkCacheTotal:       chunkCacheCounter,
 	}
 }
@@ -173,3 +173,105 @@ func TestPromMetrics_RecordUpstreamResponse(t *testing.T) {
 		t.Errorf("unexpected metric result:\n%s", err)
 	}
 }
+
+func TestPromMetrics_RecordLookupCache(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordLookupCache("hit")
+	m.RecordLookupCache("hit")
+	m.RecordLookupCache("miss")
+
+	if got := testutil.ToFloat64(m.lookupCacheTotal.WithLabelValues("test", "hit")); got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.lookupCacheTotal.WithLabelValues("test", "miss")); got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+}
+
+func TestPromMetrics_RecordProvide(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordProvideQueueDepth(5)
+	m.RecordProvideQueueDepth(3)
+	m.RecordProvideDuration(0.1)
+
+	if got := testutil.ToFloat64(m.provideQueueDepth.WithLabelValues("test")); got != 3 {
+		t.Errorf("expected queue depth 3, got %v", got)
+	}
+
+	if got := testutil.CollectAndCount(m.provideDuration); got != 1 {
+		t.Errorf("expected 1 provide duration series, got %v", got)
+	}
+}
+
+func TestPromMetrics_RecordPeerConnection(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordPeerConnection("new")
+	m.RecordPeerConnection("reused")
+	m.RecordPeerConnection("reused")
+	m.RecordPeerHandshake("success")
+
+	if got := testutil.ToFloat64(m.peerConnectionsTotal.WithLabelValues("test", "reused")); got != 2 {
+		t.Errorf("expected 2 reused connections, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.peerConnectionsTotal.WithLabelValues("test", "new")); got != 1 {
+		t.Errorf("expected 1 new connection, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.peerHandshakesTotal.WithLabelValues("test", "success")); got != 1 {
+		t.Errorf("expected 1 handshake, got %v", got)
+	}
+}
+
+func TestPromMetrics_RecordVerification(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordVerification("chunk", "success")
+	m.RecordVerification("chunk", "corrupt")
+	m.RecordVerification("blob", "success")
+
+	if got := testutil.ToFloat64(m.verificationsTotal.WithLabelValues("test", "chunk", "corrupt")); got != 1 {
+		t.Errorf("expected 1 corrupt chunk, got %v", got)
+	}
+
+	if got := testutil.CollectAndCount(m.verificationsTotal); got != 3 {
+		t.Errorf("expected 3 verification series, got %v", got)
+	}
+}
+
+func TestPromMetrics_RecordUpstreamBreakerState(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordUpstreamBreakerState("origin", 2)
+	m.RecordUpstreamBreakerState("origin", 1)
+
+	if got := testutil.ToFloat64(m.upstreamBreakerState.WithLabelValues("test", "origin")); got != 1 {
+		t.Errorf("expected breaker state 1, got %v", got)
+	}
+}
+
+func TestPromMetrics_RecordChunkCache(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := NewPromMetrics(reg, "test", "peerd")
+
+	m.RecordChunkCache("memory", "hit")
+	m.RecordChunkCache("memory", "miss")
+	m.RecordChunkCache("disk", "hit")
+
+	if got := testutil.ToFloat64(m.chunkCacheTotal.WithLabelValues("test", "memory", "hit")); got != 1 {
+		t.Errorf("expected 1 memory hit, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.chunkCacheTotal.WithLabelValues("test", "disk", "hit")); got != 1 {
+		t.Errorf("expected 1 disk hit, got %v", got)
+	}
+}
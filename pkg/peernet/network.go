@@ -4,9 +4,13 @@ package peernet
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
@@ -30,6 +34,10 @@ type Network interface {
 	// This config should not require client certificate verification.
 	DefaultTLSConfig() *tls.Config
 
+	// MutualTLSConfig creates a TLS config for a server that only accepts peers.
+	// This config should require a client certificate of a libp2p identity.
+	MutualTLSConfig() *tls.Config
+
 	// RoundTripperFor returns an HTTP round tripper which authenticates the given peer.
 	// If pid is empty, the round tripper should work for any peer.
 	RoundTripperFor(pid peer.ID) http.RoundTripper
@@ -40,9 +48,11 @@ type Network interface {
 }
 
 type network struct {
-	id               *libp2ptls.Identity
 	defaultTLSConfig *tls.Config
+	mutualTLSConfig  *tls.Config
 	defaultTransport *http.Transport
+	transports       *transportPool
+	metricsRecorder  metrics.Metrics
 }
 
 var _ Network = &network{}
@@ -53,31 +63,37 @@ func (n *network) DefaultTLSConfig() *tls.Config {
 	return n.defaultTLSConfig
 }
 
-// HTTPClientFor returns a single use HTTP client for the given peer.
-// The client does not verify the peer's certificate.
+// MutualTLSConfig creates a TLS config to use for this server when only peers are allowed.
+// This config requires a client certificate of a libp2p identity and is reusable.
+func (n *network) MutualTLSConfig() *tls.Config {
+	return n.mutualTLSConfig
+}
+
+// HTTPClientFor returns an HTTP client for the given peer.
+// The client shares the pooled transport of the peer, so its connections are reused.
+// If pid is empty, the client does not verify the peer's certificate.
 func (n *network) HTTPClientFor(pid peer.ID) *http.Client {
 	if pid == "" {
 		return defaultHttpClient
 	}
 
 	return &http.Client{
-		Transport: n.transportFor(pid),
+		Transport: n.RoundTripperFor(pid),
 		Timeout:   defaultTimeout,
 	}
 }
 
-// RoundTripperFor returns a single use round tripper for the given peer.
+// RoundTripperFor returns a round tripper for the given peer, backed by its pooled transport.
 // The peer is expected to provide a valid certificate.
 // If pid is empty, the round tripper will work for any peer.
 func (n *network) RoundTripperFor(pid peer.ID) http.RoundTripper {
-	if pid == "" {
-		return n.defaultTransport
+	return &tracedTransport{
+		Transport:       n.transportFor(pid),
+		metricsRecorder: n.metricsRecorder,
 	}
-
-	return n.transportFor(pid)
 }
 
-// transportFor returns a single use transport for outbound connection to the given peer.
+// transportFor returns the pooled transport for outbound connections to the given peer.
 // The peer is expected to provide a valid certificate.
 // If pid is empty, the transport will work for any peer.
 func (n *network) transportFor(pid peer.ID) *http.Transport {
@@ -85,23 +101,69 @@ func (n *network) transportFor(pid peer.ID) *http.Transport {
 		return n.defaultTransport
 	}
 
-	p2pTlsConfigForPeer, _ := n.id.ConfigForPeer(pid)
+	return n.transports.get(pid)
+}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			Certificates:          p2pTlsConfigForPeer.Certificates,
-			ClientAuth:            tls.RequireAndVerifyClientCert,
-			VerifyPeerCertificate: p2pTlsConfigForPeer.VerifyPeerCertificate,
-			InsecureSkipVerify:    true,
-		},
-		MaxConnsPerHost: 100,
+// newPeerTransport creates a transport for outbound connections to the given peer.
+func (n *network) newPeerTransport(pid peer.ID) *http.Transport {
+	return newTransport(&tls.Config{
+		Certificates:          n.defaultTLSConfig.Certificates,
+		ClientAuth:            tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate: verifyPeerCertificate(pid),
+		InsecureSkipVerify:    true,
+	})
+}
+
+// verifyPeerCertificate returns a function that verifies the certificate of a libp2p identity.
+// If pid is not empty, the certificate must belong to the given peer.
+// Unlike the config of libp2ptls.Identity.ConfigForPeer, it can be used for any number of handshakes.
+func verifyPeerCertificate(pid peer.ID) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		chain := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			chain[i] = cert
+		}
+
+		actual, err := peerIDFromCertChain(chain)
+		if err != nil {
+			return err
+		}
+
+		if pid != "" && actual != pid {
+			return fmt.Errorf("peer id mismatch: expected %s, got %s", pid, actual)
+		}
+
+		return nil
 	}
+}
 
-	return transport
+// PeerID returns the ID of the peer that presented the client certificate of the given TLS connection.
+// The certificate is expected to have been verified by the MutualTLSConfig.
+func PeerID(state *tls.ConnectionState) (peer.ID, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", errors.New("no peer certificate")
+	}
+
+	return peerIDFromCertChain(state.PeerCertificates)
+}
+
+// peerIDFromCertChain verifies the certificate chain of a libp2p identity and returns its peer ID.
+func peerIDFromCertChain(chain []*x509.Certificate) (peer.ID, error) {
+	pubKey, err := libp2ptls.PubKeyFromCertChain(chain)
+	if err != nil {
+		return "", err
+	}
+
+	return peer.IDFromPublicKey(pubKey)
 }
 
 // New creates a new network interface for communicating with peers.
-func New(h host.Host) (Network, error) {
+// Transports are pooled per peer and their connections are reused.
+func New(h host.Host, m metrics.Metrics) (Network, error) {
 	privKey := h.Peerstore().PrivKey(h.ID())
 
 	id, err := libp2ptls.NewIdentity(privKey)
@@ -114,14 +176,19 @@ func New(h host.Host) (Network, error) {
 		Certificates: tlsConfig.Certificates,
 	}
 
-	defaultTransport := &http.Transport{
-		TLSClientConfig: defaultTLSConfig,
-		MaxConnsPerHost: 100,
+	mutualTLSConfig := &tls.Config{
+		Certificates:          tlsConfig.Certificates,
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: verifyPeerCertificate(""),
 	}
 
-	return &network{
-		id:               id,
+	n := &network{
 		defaultTLSConfig: defaultTLSConfig,
-		defaultTransport: defaultTransport,
-	}, nil
+		mutualTLSConfig:  mutualTLSConfig,
+		defaultTransport: newTransport(defaultTLSConfig.Clone()),
+		metricsRecorder:  m,
+	}
+	n.transports = newTransportPool(maxPooledPeers, n.newPeerTransport)
+
+	return n, nil
 }
@@ -4,16 +4,24 @@ package peernet
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/azure/peerd/pkg/metrics"
 	"github.com/azure/peerd/pkg/peernet/mocks"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+var mr = metrics.NewPromMetrics(prometheus.NewRegistry(), "test", "test")
+
 func TestNew(t *testing.T) {
 	h := &mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}
 
-	_, err := New(h)
+	_, err := New(h, mr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -22,7 +30,7 @@ func TestNew(t *testing.T) {
 func TestDefaultTLSConfig(t *testing.T) {
 	h := &mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}
 
-	n, err := New(h)
+	n, err := New(h, mr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,7 +48,7 @@ func TestDefaultTLSConfig(t *testing.T) {
 func TestTransportFor(t *testing.T) {
 	h := &mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}
 
-	n, err := New(h)
+	n, err := New(h, mr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,6 +74,10 @@ func TestTransportFor(t *testing.T) {
 		t.Fatal("expected InsecureSkipVerify")
 	}
 
+	if n.(*network).transportFor("test-peer") != testPeerTransport {
+		t.Fatal("expected pooled transport to be reused")
+	}
+
 	testPeerTransport2 := n.(*network).transportFor("test-peer-2")
 	if testPeerTransport2 == nil {
 		t.Fatal("expected non-nil transport")
@@ -90,7 +102,7 @@ func TestTransportFor(t *testing.T) {
 func TestHTTPClientFor(t *testing.T) {
 	h := &mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}
 
-	n, err := New(h)
+	n, err := New(h, mr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,7 +124,7 @@ func TestHTTPClientFor(t *testing.T) {
 func TestRoundTripperFor(t *testing.T) {
 	h := &mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}
 
-	n, err := New(h)
+	n, err := New(h, mr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,11 +139,95 @@ func TestRoundTripperFor(t *testing.T) {
 		t.Fatal("expected non-nil client")
 	}
 
-	if c.(*http.Transport).TLSClientConfig == nil {
+	if c.(*tracedTransport).TLSClientConfig == nil {
 		t.Fatal("expected non-nil TLS config")
 	}
 
-	if c.(*http.Transport).TLSClientConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+	if c.(*tracedTransport).TLSClientConfig.ClientAuth != tls.RequireAndVerifyClientCert {
 		t.Fatal("expected RequireAndVerifyClientCert")
 	}
 }
+
+func TestMutualTLSConfig(t *testing.T) {
+	server, err := New(&mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}, mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := New(&mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}, mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := server.MutualTLSConfig()
+	if config.ClientAuth != tls.RequireAnyClientCert {
+		t.Fatal("expected RequireAnyClientCert")
+	}
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pid, err := PeerID(r.TLS)
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(pid.String()))
+	}))
+	s.TLS = config
+	s.StartTLS()
+	defer s.Close()
+
+	serverID := certPeerID(t, server.DefaultTLSConfig())
+	clientID := certPeerID(t, client.DefaultTLSConfig())
+
+	// Every new connection is verified, not only the first.
+	for i := 0; i < 2; i++ {
+		resp, err := client.HTTPClientFor(serverID).Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != clientID.String() {
+			t.Errorf("expected verified peer id %s, got %s", clientID, b)
+		}
+
+		client.(*network).transportFor(serverID).CloseIdleConnections()
+	}
+
+	// Clients without the certificate of an identity are rejected.
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if resp, err := anonymous.Get(s.URL); err == nil {
+		resp.Body.Close()
+		t.Error("expected client without certificate to be rejected")
+	}
+}
+
+func TestPeerID(t *testing.T) {
+	if _, err := PeerID(nil); err == nil {
+		t.Error("expected error without TLS")
+	}
+
+	if _, err := PeerID(&tls.ConnectionState{}); err == nil {
+		t.Error("expected error without peer certificates")
+	}
+}
+
+// certPeerID returns the peer ID of the certificate in the given config.
+func certPeerID(t *testing.T, c *tls.Config) peer.ID {
+	cert, err := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := PeerID(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pid
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package peernet
+
+import (
+	"container/list"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/azure/peerd/pkg/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// maxPooledPeers is the number of peers whose transports are kept in the pool.
+	maxPooledPeers = 256
+
+	// idleConnTimeout is how long an idle connection to a peer is kept open.
+	idleConnTimeout = 90 * time.Second
+
+	// maxIdleConnsPerPeer is the number of idle connections kept open to each peer.
+	maxIdleConnsPerPeer = 16
+
+	// maxConnsPerPeer is the number of connections that may be open to each peer.
+	maxConnsPerPeer = 100
+)
+
+// transportPool is a bounded pool of transports keyed by peer.
+// When the pool is full, the least recently used transport is evicted and its idle connections are closed.
+type transportPool struct {
+	size    int
+	newFunc func(pid peer.ID) *http.Transport
+
+	mx    sync.Mutex
+	lru   *list.List
+	items map[peer.ID]*list.Element
+}
+
+// poolEntry is a transport in the pool.
+type poolEntry struct {
+	pid       peer.ID
+	transport *http.Transport
+}
+
+// newTransportPool creates a new pool of at most size transports, created with newFunc.
+func newTransportPool(size int, newFunc func(pid peer.ID) *http.Transport) *transportPool {
+	return &transportPool{
+		size:    size,
+		newFunc: newFunc,
+		lru:     list.New(),
+		items:   map[peer.ID]*list.Element{},
+	}
+}
+
+// get returns the transport for the given peer, creating it if it is not in the pool.
+func (p *transportPool) get(pid peer.ID) *http.Transport {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if e, ok := p.items[pid]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*poolEntry).transport
+	}
+
+	t := p.newFunc(pid)
+	p.items[pid] = p.lru.PushFront(&poolEntry{pid: pid, transport: t})
+
+	for p.lru.Len() > p.size {
+		oldest := p.lru.Back()
+		entry := p.lru.Remove(oldest).(*poolEntry)
+		delete(p.items, entry.pid)
+		entry.transport.CloseIdleConnections()
+	}
+
+	return t
+}
+
+// len returns the number of transports in the pool.
+func (p *transportPool) len() int {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.lru.Len()
+}
+
+// newTransport creates a transport that keeps idle connections open and attempts HTTP/2.
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   true,
+		MaxConnsPerHost:     maxConnsPerPeer,
+		MaxIdleConnsPerHost: maxIdleConnsPerPeer,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+}
+
+// tracedTransport is a transport that records whether connections are reused and the results of TLS handshakes.
+type tracedTransport struct {
+	*http.Transport
+	metricsRecorder metrics.Metrics
+}
+
+var _ http.RoundTripper = &tracedTransport{}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.metricsRecorder.RecordPeerConnection("reused")
+			} else {
+				t.metricsRecorder.RecordPeerConnection("new")
+			}
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				t.metricsRecorder.RecordPeerHandshake("failure")
+			} else {
+				t.metricsRecorder.RecordPeerHandshake("success")
+			}
+		},
+	}
+
+	return t.Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
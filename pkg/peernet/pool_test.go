@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package peernet
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/azure/peerd/pkg/metrics"
+	"github.com/azure/peerd/pkg/peernet/mocks"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTransportPoolEvicts(t *testing.T) {
+	created := 0
+	p := newTransportPool(2, func(pid peer.ID) *http.Transport {
+		created++
+		return newTransport(nil)
+	})
+
+	a := p.get("peer-a")
+	p.get("peer-b")
+
+	// Using a marks it as recently used, so b is evicted.
+	if p.get("peer-a") != a {
+		t.Fatal("expected pooled transport to be reused")
+	}
+
+	p.get("peer-c")
+
+	if p.len() != 2 {
+		t.Fatalf("expected pool to be bounded to 2 transports, got %d", p.len())
+	}
+
+	if p.get("peer-a") != a {
+		t.Error("expected recently used transport to be kept")
+	}
+
+	p.get("peer-b")
+	if created != 4 {
+		t.Errorf("expected evicted transport to be created again, got %d transports created", created)
+	}
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverID, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := libp2ptls.NewIdentity(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig, _ := id.ConfigForPeer("")
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	s.EnableHTTP2 = true
+	s.TLS = &tls.Config{Certificates: serverConfig.Certificates}
+	s.StartTLS()
+	defer s.Close()
+
+	reg := prometheus.NewRegistry()
+	n, err := New(&mocks.MockHost{PeerStore: &mocks.MockPeerstore{}}, metrics.NewPromMetrics(reg, "test", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := n.HTTPClientFor(serverID).Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2, got %s (server saw %s)", resp.Proto, b)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		result   string
+		expected float64
+	}{
+		{name: "test_peer_tls_handshakes_total", result: "success", expected: 1},
+		{name: "test_peer_connections_total", result: "new", expected: 1},
+		{name: "test_peer_connections_total", result: "reused", expected: 1},
+	} {
+		if got := counterValue(t, reg, tc.name, tc.result); got != tc.expected {
+			t.Errorf("expected %s{result=%q} to be %v, got %v", tc.name, tc.result, tc.expected, got)
+		}
+	}
+
+	// A peer with a different identity is rejected.
+	other, err := peer.Decode("12D3KooWJFz4wmVm4DW8p8fjcGqzMyV2ZfzToCrXbSbhm2vpnh3i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := n.HTTPClientFor(other).Get(s.URL); err == nil {
+		t.Error("expected peer with a different identity to be rejected")
+	}
+
+	if got := counterValue(t, reg, "test_peer_tls_handshakes_total", "failure"); got != 1 {
+		t.Errorf("expected a failed handshake, got %v", got)
+	}
+}
+
+// counterValue returns the value of the counter with the given name and result label.
+func counterValue(t *testing.T, reg *prometheus.Registry, name, result string) float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "result" && l.GetValue() == result {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return 0
+}
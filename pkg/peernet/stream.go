@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package peernet
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/azure/peerd/pkg/metrics"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/gostream"
+)
+
+// StreamProtocol is the libp2p protocol of HTTP requests between peers over streams.
+const StreamProtocol protocol.ID = "/peerd/http/1.1"
+
+// Listener is implemented by networks that receive requests from peers on their own listener.
+type Listener interface {
+	// Listen returns a listener for connections from peers.
+	// Connections should be served with StreamConnContext, so that handlers can identify the peer.
+	Listen() (net.Listener, error)
+}
+
+// streamNetwork is a network that sends HTTP requests to peers over libp2p streams of the router host.
+// Peers are authenticated by the libp2p connection, so only the router address of a peer is needed.
+// The TLS configs and requests to any peer are served by the underlying HTTPS network.
+type streamNetwork struct {
+	Network
+	h               host.Host
+	transport       *http.Transport
+	metricsRecorder metrics.Metrics
+}
+
+var _ Network = &streamNetwork{}
+var _ Listener = &streamNetwork{}
+
+// NewStreamNetwork creates a new network interface for communicating with peers over libp2p streams.
+func NewStreamNetwork(h host.Host, m metrics.Metrics) (Network, error) {
+	n, err := New(h, m)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &streamNetwork{
+		Network:         n,
+		h:               h,
+		metricsRecorder: m,
+	}
+
+	s.transport = newTransport(nil)
+	s.transport.DialContext = s.dial
+
+	return s, nil
+}
+
+// HTTPClientFor returns an HTTP client for the given peer, which sends requests over libp2p streams.
+// If pid is empty, the client of the underlying HTTPS network is returned.
+func (n *streamNetwork) HTTPClientFor(pid peer.ID) *http.Client {
+	if pid == "" {
+		return n.Network.HTTPClientFor(pid)
+	}
+
+	return &http.Client{
+		Transport: n.RoundTripperFor(pid),
+		Timeout:   defaultTimeout,
+	}
+}
+
+// RoundTripperFor returns a round tripper for the given peer, which sends requests over libp2p streams.
+// If pid is empty, the round tripper of the underlying HTTPS network is returned.
+func (n *streamNetwork) RoundTripperFor(pid peer.ID) http.RoundTripper {
+	if pid == "" {
+		return n.Network.RoundTripperFor(pid)
+	}
+
+	return &tracedTransport{
+		Transport:       n.transport,
+		metricsRecorder: n.metricsRecorder,
+	}
+}
+
+// Listen returns a listener for HTTP requests from peers over libp2p streams.
+func (n *streamNetwork) Listen() (net.Listener, error) {
+	return gostream.Listen(n.h, StreamProtocol, gostream.IgnoreEOF())
+}
+
+// dial opens a stream to the peer whose ID is the host of the given address.
+func (n *streamNetwork) dial(ctx context.Context, _, addr string) (net.Conn, error) {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := peer.Decode(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return gostream.Dial(ctx, n.h, pid, StreamProtocol)
+}
+
+// StreamURL returns the base URL of requests to the given peer over libp2p streams.
+func StreamURL(pid peer.ID) string {
+	return "http://" + pid.String()
+}
+
+// streamPeerCtxKey is the context key of the peer of a connection over a libp2p stream.
+type streamPeerCtxKey struct{}
+
+// StreamConnContext adds the ID of the remote peer of a connection over a libp2p stream to its context.
+// It is meant to be used as the ConnContext of the HTTP server of a Listener.
+func StreamConnContext(ctx context.Context, c net.Conn) context.Context {
+	if c.RemoteAddr().Network() != gostream.Network {
+		return ctx
+	}
+
+	pid, err := peer.Decode(c.RemoteAddr().String())
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, streamPeerCtxKey{}, pid)
+}
+
+// StreamPeerID returns the ID of the peer that sent the given request over a libp2p stream.
+// The peer is authenticated by the libp2p connection.
+func StreamPeerID(r *http.Request) (peer.ID, bool) {
+	pid, ok := r.Context().Value(streamPeerCtxKey{}).(peer.ID)
+	return pid, ok
+}
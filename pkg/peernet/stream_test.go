@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package peernet
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/azure/peerd/pkg/metrics"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStreamNetwork(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	serverNet, err := NewStreamNetwork(server, mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := serverNet.(Listener).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pid, ok := StreamPeerID(r)
+			if !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(r.URL.Path + " " + pid.String()))
+		}),
+		ConnContext: StreamConnContext,
+	}
+	go s.Serve(l) //nolint:errcheck
+	defer s.Close()
+
+	// Only the router address of the server is needed.
+	client.Peerstore().AddAddrs(server.ID(), server.Addrs(), time.Minute)
+
+	reg := prometheus.NewRegistry()
+	clientNet, err := NewStreamNetwork(client, metrics.NewPromMetrics(reg, "test", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, StreamURL(server.ID())+"/blobs/some-blob", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := clientNet.HTTPClientFor(server.ID()).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "/blobs/some-blob " + client.ID().String(); string(b) != expected {
+			t.Errorf("expected %q, got %q", expected, b)
+		}
+	}
+
+	if got := counterValue(t, reg, "test_peer_connections_total", "reused"); got != 1 {
+		t.Errorf("expected stream to be reused, got %v reused connections", got)
+	}
+
+	// Requests to any peer use the https network.
+	if clientNet.HTTPClientFor("") != defaultHttpClient {
+		t.Error("expected default client for requests to any peer")
+	}
+
+	if _, ok := clientNet.RoundTripperFor("").(*tracedTransport); !ok {
+		t.Error("expected https round tripper for requests to any peer")
+	}
+
+	// Unknown peers cannot be dialed.
+	unknown, err := peer.Decode("12D3KooWJFz4wmVm4DW8p8fjcGqzMyV2ZfzToCrXbSbhm2vpnh3i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, time.Second)
+	defer dialCancel()
+	req, err := http.NewRequestWithContext(dialCtx, http.MethodGet, StreamURL(unknown)+"/blobs/some-blob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := clientNet.HTTPClientFor(unknown).Do(req); err == nil {
+		t.Error("expected request to unknown peer to fail")
+	}
+}
@@ -3,7 +3,6 @@
 package urlparser
 
 import (
-	"fmt"
 	"regexp"
 
 	"github.com/opencontainers/go-digest"
@@ -20,20 +19,12 @@ var (
 		// Azure Blob Storage public cloud blob endpoints.
 		regexp.MustCompile(`https:\/\/[a-zA-Z0-9]+\.blob\.[a-z\.]+\/[a-zA-Z0-9\-]+\/\/docker\/registry\/v2\/blobs\/sha256\/[a-z0-9]{2}\/([a-zA-Z0-9]{64})\/data.*`),
 	}
+
+	// azure parses the URLs of Azure.
+	azure = regexParser{regexes}
 )
 
 // parseDigestFromAzureUrl parses the digest from the given blob url or returns an error.
 func parseDigestFromAzureUrl(url string) (digest.Digest, error) {
-	if url == "" {
-		return "", fmt.Errorf("empty url")
-	}
-
-	for _, r := range regexes {
-		matches := r.FindStringSubmatch(url)
-		if len(matches) == 2 {
-			return digest.Digest("sha256:" + matches[1]), nil
-		}
-	}
-
-	return "", fmt.Errorf("unknown url")
+	return azure.ParseDigest(url, nil)
 }
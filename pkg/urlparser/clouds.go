@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package urlparser
+
+import "regexp"
+
+var (
+	// aws parses the presigned URLs of Amazon S3, in virtual-hosted and path style, such as the layer redirects of
+	// Amazon Elastic Container Registry.
+	aws = regexParser{[]*regexp.Regexp{
+		regexp.MustCompile(`^https:\/\/(?:[a-z0-9\.\-]+\.)?s3(?:[\.\-][a-z0-9\-]+)*\.amazonaws\.com(?:\.cn)?` + blobPath),
+	}}
+
+	// gcp parses the signed URLs of Google Cloud Storage, in virtual-hosted and path style, such as the layer redirects
+	// of Google Container Registry.
+	gcp = regexParser{[]*regexp.Regexp{
+		regexp.MustCompile(`^https:\/\/(?:[a-z0-9\.\-_]+\.)?storage\.googleapis\.com` + blobPath),
+	}}
+
+	// cdn parses the URLs of the CDNs and object storage that registries redirect to, such as Amazon CloudFront,
+	// Cloudflare and Cloudflare R2.
+	cdn = regexParser{[]*regexp.Regexp{
+		regexp.MustCompile(`^https:\/\/[a-z0-9\.\-]+\.(?:cloudfront\.net|cloudflare\.docker\.com|r2\.cloudflarestorage\.com|r2\.dev)` + blobPath),
+	}}
+
+	// distribution parses the blob URLs of the OCI distribution API of any registry, such as /v2/<repo>/blobs/<digest>.
+	distribution = regexParser{[]*regexp.Regexp{
+		regexp.MustCompile(`^https?:\/\/[^\/\?]+\/v2\/[^\?]+\/blobs\/sha256(?::|%3[aA])([a-f0-9]{64})(?:\?.*)?$`),
+	}}
+)
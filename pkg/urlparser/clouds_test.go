@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package urlparser
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	cloudTestCases = []struct {
+		url    string
+		digest string
+		valid  bool
+	}{
+		{
+			"https://prod-us-east-1-starport-layer-bucket.s3.us-east-1.amazonaws.com/bd2b-123456789012-4fbd1c2a-6b8e-b4e0-86a6-5c1ad8d6b2a3/sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a?X-Amz-Security-Token=IQoJb3JpZ2luX2VjEHYaCXVz&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=20230920T011449Z&X-Amz-SignedHeaders=host&X-Amz-Expires=3600&X-Amz-Credential=ASIA%2F20230920%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Signature=7d8e",
+			"sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a",
+			true,
+		},
+		{
+			"https://s3.eu-west-1.amazonaws.com/my-registry/docker/registry/v2/blobs/sha256/1b/1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced/data?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Expires=1200&X-Amz-Signature=7d8e",
+			"sha256:1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced",
+			true,
+		},
+		{
+			"https://my-registry.s3.amazonaws.com/docker/registry/v2/blobs/sha256/1b/1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced/data",
+			"sha256:1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced",
+			true,
+		},
+		{
+			"https://storage.googleapis.com/us.artifacts.my-project.appspot.com/containers/images/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Expires=300&X-Goog-Signature=1a2b",
+			"sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			true,
+		},
+		{
+			"https://my-registry.storage.googleapis.com/docker/registry/v2/blobs/sha256/52/526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1/data?Expires=1695172489&GoogleAccessId=registry&Signature=1a2b",
+			"sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			true,
+		},
+		{
+			"https://production.cloudflare.docker.com/registry-v2/docker/registry/v2/blobs/sha256/dd/dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a/data?verify=1695172489-abc",
+			"sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a",
+			true,
+		},
+		{
+			"https://d2glxqk2uabbnd.cloudfront.net/sha256/1b/1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced?Expires=1695172489&Signature=1a2b&Key-Pair-Id=K2",
+			"sha256:1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced",
+			true,
+		},
+		{
+			"https://0123456789abcdef.r2.cloudflarestorage.com/registry/docker/registry/v2/blobs/sha256/52/526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1/data?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=7d8e",
+			"sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			true,
+		},
+		{
+			"https://ghcr.io/v2/azure/peerd/blobs/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			"sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			true,
+		},
+		{
+			"http://localhost:5000/v2/library/alpine/blobs/sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a?ns=docker.io",
+			"sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a",
+			true,
+		},
+		{
+			"https://ghcr.io/v2/azure/peerd/manifests/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			"",
+			false,
+		},
+		{
+			"https://my-registry.s3.amazonaws.com/docker/registry/v2/blobs/sha256/1b/data",
+			"",
+			false,
+		},
+		{
+			"https://storage.googleapis.com.example.com/containers/images/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			"",
+			false,
+		},
+		{
+			"https://example.com/containers/images/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			"",
+			false,
+		},
+	}
+)
+
+func TestCloudUrls(t *testing.T) {
+	p := New()
+	for _, test := range cloudTestCases {
+		got, err := p.ParseDigest(test.url, nil)
+		if test.valid {
+			if err != nil {
+				t.Errorf("expected no error parsing digest from url %s", test.url)
+			} else if got != digest.Digest(test.digest) {
+				t.Errorf("expected digest %s, got %s", test.digest, got)
+			}
+		} else {
+			if err == nil {
+				t.Errorf("expected error parsing digest from url %s, got %s", test.url, got)
+			}
+		}
+	}
+}
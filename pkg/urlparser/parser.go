@@ -4,27 +4,110 @@
 package urlparser
 
 import (
+	"fmt"
+	"net/http"
+	"regexp"
+
 	"github.com/opencontainers/go-digest"
 )
 
 // Parser describes an interface for parsing information from a URL.
 type Parser interface {
-	// ParseDigest parses the digest from the given URL.
+	// ParseDigest parses the digest from the given URL, or from the headers of the request for it, for origins that do
+	// not put the digest in the URL. The headers may be nil.
 	// If none found, implementations should return an error.
-	ParseDigest(url string) (digest.Digest, error)
+	ParseDigest(url string, header http.Header) (digest.Digest, error)
 }
 
-type parser struct{}
+// Registry is a Parser that tries its parsers in order, and returns the digest parsed by the first that succeeds.
+type Registry struct {
+	parsers []Parser
+}
 
-var _ Parser = &parser{}
+var _ Parser = &Registry{}
 
-// ParseDigest parses the digest from the given URL.
+// NewRegistry creates a new registry of the given parsers.
+func NewRegistry(parsers ...Parser) *Registry {
+	return &Registry{parsers: parsers}
+}
+
+// Register adds a parser to the end of the registry. It must not be called once the registry is in use.
+func (r *Registry) Register(p Parser) {
+	r.parsers = append(r.parsers, p)
+}
+
+// ParseDigest parses the digest with the first parser that succeeds.
 // If none found, returns an error.
-func (p *parser) ParseDigest(url string) (digest.Digest, error) {
-	return parseDigestFromAzureUrl(url)
+func (r *Registry) ParseDigest(url string, header http.Header) (digest.Digest, error) {
+	for _, p := range r.parsers {
+		if d, err := p.ParseDigest(url, header); err == nil {
+			return d, nil
+		}
+	}
+
+	if url == "" {
+		return "", fmt.Errorf("empty url")
+	}
+
+	return "", fmt.Errorf("unknown url")
 }
 
-// New returns a new Parser.
+// New returns a new Parser of the URLs of the major clouds and registries.
 func New() Parser {
-	return &parser{}
+	return NewRegistry(builtins()...)
+}
+
+// NewWithRules returns a new Parser of the URLs matched by the given rules, and of the major clouds and registries.
+// The rules are tried first, in order.
+func NewWithRules(rules []Rule) (Parser, error) {
+	r := NewRegistry()
+	for _, rule := range rules {
+		p, err := rule.parser()
+		if err != nil {
+			return nil, err
+		}
+		r.Register(p)
+	}
+
+	for _, p := range builtins() {
+		r.Register(p)
+	}
+
+	return r, nil
+}
+
+// builtins returns the parsers of the major clouds and registries.
+func builtins() []Parser {
+	return []Parser{azure, aws, gcp, cdn, distribution}
+}
+
+// blobPath matches the end of the path of a blob in object storage and its query, and captures its sha256 hex:
+// the layout of the distribution registry (.../blobs/sha256/ab/<hex>/data), the same layout without the data file
+// (.../sha256/ab/<hex>), or the digest as the last segment of the path (.../sha256:<hex>).
+const blobPath = `/(?:[^?]*/)?(?:sha256/[a-f0-9]{2}/([a-f0-9]{64})(?:/data)?|sha256(?::|%3[aA])([a-f0-9]{64}))(?:\?.*)?$`
+
+// regexParser parses the sha256 digest from the first submatch of the first of its regexes that matches the URL.
+type regexParser struct {
+	regexes []*regexp.Regexp
+}
+
+var _ Parser = regexParser{}
+
+// ParseDigest parses the digest from the given URL.
+// If none found, returns an error.
+func (p regexParser) ParseDigest(url string, _ http.Header) (digest.Digest, error) {
+	if url == "" {
+		return "", fmt.Errorf("empty url")
+	}
+
+	for _, r := range p.regexes {
+		matches := r.FindStringSubmatch(url)
+		for _, m := range matches[min(1, len(matches)):] {
+			if m != "" {
+				return digest.Digest("sha256:" + m), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unknown url")
 }
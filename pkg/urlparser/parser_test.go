@@ -16,7 +16,7 @@ func TestParser(t *testing.T) {
 
 	// Test Azure URLs
 	for _, test := range azureTestCases {
-		got, err := p.ParseDigest(test.url)
+		got, err := p.ParseDigest(test.url, nil)
 		if test.valid {
 			if err != nil {
 				t.Errorf("expected no error parsing digest from url %s", test.url)
@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package urlparser
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Rule is a user-defined rule for parsing the digest of the URLs of an origin that the built-in parsers do not know.
+//
+// For example, the following rules in a rules file parse the digest from the URLs of an origin, and from a request
+// header for another origin that does not put it in its URLs:
+//
+//	[[rule]]
+//	name = "artifactory"
+//	match = '^https://artifactory\.example\.com/.+/sha256__([a-f0-9]{64})$'
+//	digest = 'sha256:$1'
+//
+//	[[rule]]
+//	name = "downloads"
+//	match = '^https://downloads\.example\.com/'
+//	header = 'X-Blob-Digest'
+type Rule struct {
+	// Name identifies the rule in errors.
+	Name string `toml:"name"`
+
+	// Match is the regular expression that a URL must match for the rule to apply. An empty expression matches all URLs.
+	Match string `toml:"match"`
+
+	// Digest is the template of the digest, expanded with the submatches of Match, such as "sha256:$1" or
+	// "${algorithm}:${hex}".
+	Digest string `toml:"digest"`
+
+	// Header is the request header that holds the digest, used instead of Digest.
+	Header string `toml:"header"`
+}
+
+// rulesFile is the format of a rules file.
+type rulesFile struct {
+	Rules []Rule `toml:"rule"`
+}
+
+// LoadRules reads the rules from the given TOML file.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f rulesFile
+	if err := toml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse url parser rules %v: %w", path, err)
+	}
+
+	return f.Rules, nil
+}
+
+// parser compiles the rule into a parser.
+func (r Rule) parser() (Parser, error) {
+	if (r.Digest == "") == (r.Header == "") {
+		return nil, fmt.Errorf("url parser rule %q: exactly one of digest and header must be set", r.Name)
+	}
+
+	match, err := regexp.Compile(r.Match)
+	if err != nil {
+		return nil, fmt.Errorf("url parser rule %q: %w", r.Name, err)
+	}
+
+	return &ruleParser{name: r.Name, match: match, digest: r.Digest, header: http.CanonicalHeaderKey(r.Header)}, nil
+}
+
+// ruleParser parses the digest of the URLs matched by a user-defined rule.
+type ruleParser struct {
+	name   string
+	match  *regexp.Regexp
+	digest string
+	header string
+}
+
+var _ Parser = &ruleParser{}
+
+// ParseDigest parses the digest from the given URL or the headers of the request for it, if the URL matches the rule.
+// If none found, returns an error.
+func (p *ruleParser) ParseDigest(url string, header http.Header) (digest.Digest, error) {
+	m := p.match.FindStringSubmatchIndex(url)
+	if m == nil {
+		return "", fmt.Errorf("url parser rule %q: unknown url", p.name)
+	}
+
+	var v string
+	if p.header != "" {
+		v = header.Get(p.header)
+	} else {
+		v = string(p.match.ExpandString(nil, p.digest, url, m))
+	}
+
+	d, err := digest.Parse(v)
+	if err != nil {
+		return "", fmt.Errorf("url parser rule %q: %w", p.name, err)
+	}
+
+	return d, nil
+}
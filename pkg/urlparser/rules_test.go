@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package urlparser
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const rulesFileContent = `
+[[rule]]
+name = "artifactory"
+match = '^https://artifactory\.example\.com/.+/(sha256)__([a-f0-9]{64})$'
+digest = '${1}:${2}'
+
+[[rule]]
+name = "downloads"
+match = '^https://downloads\.example\.com/'
+header = 'x-blob-digest'
+`
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	if err := os.WriteFile(path, []byte(rulesFileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 || rules[0].Name != "artifactory" || rules[1].Header != "x-blob-digest" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	p, err := NewWithRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Blob-Digest", "sha256:1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced")
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		digest string
+		valid  bool
+	}{
+		{
+			name:   "template",
+			url:    "https://artifactory.example.com/api/docker/remote/v2/alpine/sha256__dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a",
+			digest: "sha256:dd5ad9c9c29f04b41a0155c720cf5ccab28ef6d353f1fe17a06c579c70054f0a",
+			valid:  true,
+		},
+		{
+			name:   "header",
+			url:    "https://downloads.example.com/some/opaque/token",
+			header: header,
+			digest: "sha256:1b930d010525941c1d56ec53b97bd057a67ae1865eebf042686d2a2d18271ced",
+			valid:  true,
+		},
+		{
+			name:  "header missing",
+			url:   "https://downloads.example.com/some/opaque/token",
+			valid: false,
+		},
+		{
+			name:   "header of another origin",
+			url:    "https://example.com/some/opaque/token",
+			header: header,
+			valid:  false,
+		},
+		{
+			name:   "builtin",
+			url:    "https://ghcr.io/v2/azure/peerd/blobs/sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			digest: "sha256:526e51f1c889e6e9ef66e398aac57c388d1305c2490f0d235ff9c8346ab42ec1",
+			valid:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.ParseDigest(tt.url, tt.header)
+			if tt.valid {
+				if err != nil {
+					t.Errorf("expected no error parsing digest, got %v", err)
+				} else if got != digest.Digest(tt.digest) {
+					t.Errorf("expected digest %s, got %s", tt.digest, got)
+				}
+			} else if err == nil {
+				t.Errorf("expected error parsing digest, got %s", got)
+			}
+		})
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "no digest or header", rule: Rule{Name: "r", Match: "."}},
+		{name: "digest and header", rule: Rule{Name: "r", Match: ".", Digest: "sha256:$1", Header: "X-Digest"}},
+		{name: "invalid regex", rule: Rule{Name: "r", Match: "(", Digest: "sha256:$1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWithRules([]Rule{tt.rule}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}